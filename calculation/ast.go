@@ -0,0 +1,95 @@
+package calculation
+
+// Node — узел AST. Узлы только описывают структуру выражения, значения вычисляет Eval.
+type Node interface {
+    Pos() Span              // фрагмент исходной строки, из которого получен узел
+    setSpan(start, end int) // расширяет фрагмент, например, на окружающие скобки
+}
+
+// Span — фрагмент исходной строки [Start, End).
+type Span struct {
+    Start int `json:"start"`
+    End   int `json:"end"`
+}
+
+func (s Span) Pos() Span                { return s }
+func (s *Span) setSpan(start, end int) { s.Start, s.End = start, end }
+//_______________________________________________________________________________________________________________________________
+
+// NumberLit — числовой литерал.
+type NumberLit struct {
+    Span
+    Value float64
+    Text  string // исходная запись числа ("0xFF"); нужна целочисленному режиму для точных больших чисел
+}
+
+// Ident — имя переменной или константы (pi, e).
+type Ident struct {
+    Span
+    Name string
+}
+
+// UnaryOp — унарная операция (минус).
+type UnaryOp struct {
+    Span
+    Operator string
+    Operand  Node
+}
+
+// BinaryOp — бинарная операция: "+", "-", "*", "/" или "^".
+type BinaryOp struct {
+    Span
+    Operator string
+    Left     Node // левый операнд
+    Right    Node // правый операнд
+}
+
+// ArrayLit — вектор [1, 2, 3] или матрица [[1, 2], [3, 4]] (вектор векторов-строк).
+type ArrayLit struct {
+    Span
+    Elements []Node
+}
+
+// Call — вызов встроенной функции: sqrt(x), max(a, b, ...).
+type Call struct {
+    Span
+    Name string
+    Args []Node
+}
+//_______________________________________________________________________________________________________________________________
+
+// Children возвращает дочерние узлы в порядке их следования в исходной строке.
+func Children(node Node) []Node {
+    switch n := node.(type) {
+    case *UnaryOp:
+        return []Node{n.Operand}
+    case *BinaryOp:
+        return []Node{n.Left, n.Right}
+    case *Call:
+        return n.Args
+    case *ArrayLit:
+        return n.Elements
+    case *UserCall:
+        return n.Args
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// withChildren возвращает копию узла с дочерними узлами children (в порядке Children).
+func withChildren(node Node, children []Node) Node {
+    switch n := node.(type) {
+    case *UnaryOp:
+        return &UnaryOp{Span: n.Span, Operator: n.Operator, Operand: children[0]}
+    case *BinaryOp:
+        return &BinaryOp{Span: n.Span, Operator: n.Operator, Left: children[0], Right: children[1]}
+    case *Call:
+        return &Call{Span: n.Span, Name: n.Name, Args: children}
+    case *ArrayLit:
+        return &ArrayLit{Span: n.Span, Elements: children}
+    case *UserCall:
+        return &UserCall{Span: n.Span, Function: n.Function, Args: children}
+    }
+    return node
+}
+//_______________________________________________________________________________________________________________________________
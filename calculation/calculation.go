@@ -2,7 +2,9 @@ package calculation
 
 import (
     "fmt"
+    "math/big"
     "strconv"
+    "strings"
     "unicode"
 )
 
@@ -15,12 +17,45 @@ const (
     TokenDivide
     TokenLParen
     TokenRParen
+    TokenPower // ^
+    TokenComma // разделитель аргументов функции
+    TokenIdent // имя переменной, константы или функции
+    TokenEquals // "=" в уравнении (см. ParseEquation)
+
+    // Целочисленные и побитовые операторы (см. EvalInteger)
+    TokenIntDivide  // "//" — деление с округлением вниз
+    TokenModulo     // "%"
+    TokenAnd        // "&"
+    TokenOr         // "|"
+    TokenXor        // "xor"
+    TokenShiftLeft  // "<<"
+    TokenShiftRight // ">>"
+
+    // Сравнения и логические операторы (см. EvalValue)
+    TokenCompare // "==", "!=", "<", "<=", ">", ">="
+    TokenAndWord // "and"
+    TokenOrWord  // "or"
+    TokenNot     // "not"
+
+    TokenLBracket // "[" — начало вектора или матрицы
+    TokenRBracket // "]"
+
+    TokenSemicolon // ";" — разделитель инструкций программы (см. ParseProgram)
 )
 
+// keywords — имена, которые являются операторами, а не переменными.
+var keywords = map[string]int{
+    "xor": TokenXor,
+    "and": TokenAndWord,
+    "or":  TokenOrWord,
+    "not": TokenNot,
+}
+
 // Token представляет лексему (число, оператор или скобку).
 type Token struct {
     Type  int
     Value string
+    Pos   int // позиция первого символа лексемы во входной строке
 }
 //_______________________________________________________________________________________________________________________________
 func Tokenize(input string) []Token {
@@ -37,30 +72,68 @@ func Tokenize(input string) []Token {
             continue
         }
 
-        // Числа (включая десятичные), с учетом минуса перед числом или скобкой
-        if unicode.IsDigit(rune(c)) || c == '.' || (c == '-' && (i == 0 || !unicode.IsDigit(rune(input[i-1])) && input[i-1] != ')')) {
+        // Целые числа в шестнадцатеричной, восьмеричной и двоичной записи: 0xFF, 0o17, 0b1010
+        if c == '0' && i+1 < len(input) && strings.IndexByte("xXoObB", input[i+1]) >= 0 {
             start := i
-            // Если минус перед числом или после оператора
-            if c == '-' {
+            i += 2
+            for i < len(input) && (unicode.IsDigit(rune(input[i])) || strings.IndexByte("abcdefABCDEF", input[i]) >= 0) {
                 i++
             }
+            tokens = append(tokens, Token{Type: TokenNumber, Value: input[start:i], Pos: start})
+            continue
+        }
+
+        // Числа (включая десятичные). Знак минуса — отдельный токен, унарный минус разбирает парсер
+        if unicode.IsDigit(rune(c)) || c == '.' {
+            start := i
             for i < len(input) && (unicode.IsDigit(rune(input[i])) || input[i] == '.') {
                 i++
             }
-            token := Token{Type: TokenNumber, Value: input[start:i]}
+            token := Token{Type: TokenNumber, Value: input[start:i], Pos: start}
             tokens = append(tokens, token)
             fmt.Printf("[Токенизация] Число: %s\n", token.Value) // Отладка: вывод числа
             continue
         }
 
+        // Имена переменных, констант и функций
+        if isIdentStart(c) {
+            start := i
+            for i < len(input) && (isIdentStart(input[i]) || unicode.IsDigit(rune(input[i]))) {
+                i++
+            }
+            token := Token{Type: TokenIdent, Value: input[start:i], Pos: start}
+            if tokenType, ok := keywords[token.Value]; ok {
+                token.Type = tokenType // оператор, а не имя
+            }
+            tokens = append(tokens, token)
+            continue
+        }
+
+        // Двухсимвольные операторы
+        if i+1 < len(input) {
+            if tokenType, ok := map[string]int{
+                "//": TokenIntDivide, "<<": TokenShiftLeft, ">>": TokenShiftRight,
+                "==": TokenCompare, "!=": TokenCompare, "<=": TokenCompare, ">=": TokenCompare,
+            }[input[i:i+2]]; ok {
+                tokens = append(tokens, Token{Type: tokenType, Value: input[i : i+2], Pos: i})
+                i += 2
+                continue
+            }
+        }
+
         // Операторы и скобки
         switch c {
-        case '+', '-', '*', '/', '(', ')':
+        case '+', '-', '*', '/', '^', '(', ')', ',', '=', '%', '&', '|', '<', '>', '[', ']', ';':
             tokenType := map[byte]int{
                 '+': TokenPlus, '-': TokenMinus, '*': TokenMultiply,
-                '/': TokenDivide, '(': TokenLParen, ')': TokenRParen,
+                '/': TokenDivide, '^': TokenPower, '(': TokenLParen,
+                ')': TokenRParen, ',': TokenComma, '=': TokenEquals,
+                '%': TokenModulo, '&': TokenAnd, '|': TokenOr,
+                '<': TokenCompare, '>': TokenCompare,
+                '[': TokenLBracket, ']': TokenRBracket,
+                ';': TokenSemicolon,
             }[c]
-            tokens = append(tokens, Token{Type: tokenType, Value: string(c)})
+            tokens = append(tokens, Token{Type: tokenType, Value: string(c), Pos: i})
             fmt.Printf("[Токенизация] Оператор/скобка: %c\n", c) // Отладка: вывод оператора или скобки
         default:
             fmt.Printf("[Ошибка] Неизвестный символ: '%c' (позиция %d)\n", c, i) // Отладка: вывод ошибки
@@ -71,17 +144,15 @@ func Tokenize(input string) []Token {
     fmt.Printf("[Токенизация] Итоговый список токенов: %+v\n", tokens) // Отладка: вывод итогового списка токенов
     return tokens
 }
-//_______________________________________________________________________________________________________________________________
 
-// Node представляет узел AST.
-type Node struct {
-    Operator string  // "+", "-", "*", "/" или пустая строка для числа.
-    Left     *Node   // левый операнд
-    Right    *Node   // правый операнд
-    Value    float64 // значение, если узел является числом
+// isIdentStart сообщает, может ли символ начинать имя.
+func isIdentStart(c byte) bool {
+    return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
 }
+//_______________________________________________________________________________________________________________________________
 
 // Parser содержит токены и текущую позицию разбора.
+// Парсер только строит AST, вычисление — отдельно (см. Eval).
 type Parser struct {
     Tokens []Token
     pos    int
@@ -110,52 +181,117 @@ func (p *Parser) Eat(tokenType int) Token {
 }
 //_______________________________________________________________________________________________________________________________
 
-// parseFactor обрабатывает число или выражение в скобках.
-func (p *Parser) ParseFactor() *Node {
+// ParsePrimary обрабатывает число, переменную, вызов функции или выражение в скобках.
+func (p *Parser) ParsePrimary() Node {
     token := p.Current()
 
-    // Проверка для отрицательных чисел
-    if token.Type == TokenNumber {
+    switch token.Type {
+    case TokenNumber:
         p.Eat(TokenNumber)
-        val, err := strconv.ParseFloat(token.Value, 64)
+        val, err := parseNumber(token.Value)
         if err != nil {
             panic(err)
         }
         fmt.Printf("[Парсер (число/скобка)] Узел числа: %v\n", val) // Отладка: вывод числа
-        return &Node{Value: val}
-    } else if token.Type == TokenLParen {
+        number := &NumberLit{Span: Span{token.Pos, token.Pos + len(token.Value)}, Value: val, Text: token.Value}
+
+        // Число перед именем — умножение: "4i" (мнимое число), "5 km" (величина с единицей измерения).
+        // Имя со своей степенью связывает сильнее: "5 m^2" = 5 * m^2, "2^3i" = 2^(3 * i)
+        if p.Current().Type == TokenIdent && !(p.pos+1 < len(p.Tokens) && p.Tokens[p.pos+1].Type == TokenLParen) {
+            unit := p.ParsePower()
+            return &BinaryOp{Span: Span{number.Start, unit.Pos().End}, Operator: "*", Left: number, Right: unit}
+        }
+        return number
+
+    case TokenIdent:
+        p.Eat(TokenIdent)
+        if p.Current().Type != TokenLParen {
+            ident := &Ident{Span: Span{token.Pos, token.Pos + len(token.Value)}, Name: token.Value}
+            // Мнимая единица после имени — умножение, как после числа: "pi i" = pi * i, "2 pi j"
+            if next := p.Current(); next.Type == TokenIdent && ImaginaryUnits[next.Value] &&
+                !(p.pos+1 < len(p.Tokens) && p.Tokens[p.pos+1].Type == TokenLParen) {
+                unit := p.ParsePower()
+                return &BinaryOp{Span: Span{ident.Start, unit.Pos().End}, Operator: "*", Left: ident, Right: unit}
+            }
+            return ident
+        }
+        // Вызов функции: имя(аргумент, ...)
+        p.Eat(TokenLParen)
+        call := &Call{Name: token.Value}
+        if p.Current().Type != TokenRParen {
+            call.Args = append(call.Args, p.ParseExpression())
+            for p.Current().Type == TokenComma {
+                p.Eat(TokenComma)
+                call.Args = append(call.Args, p.ParseExpression())
+            }
+        }
+        closing := p.Eat(TokenRParen)
+        call.Span = Span{token.Pos, closing.Pos + 1}
+        return call
+
+    case TokenLParen:
         p.Eat(TokenLParen)
         node := p.ParseExpression()
-        p.Eat(TokenRParen)
+        closing := p.Eat(TokenRParen)
+        // Фрагмент узла включает скобки
+        node.setSpan(token.Pos, closing.Pos+1)
         return node
+
+    case TokenLBracket:
+        // Вектор или матрица: [элемент, ...]
+        p.Eat(TokenLBracket)
+        array := &ArrayLit{}
+        if p.Current().Type != TokenRBracket {
+            array.Elements = append(array.Elements, p.ParseExpression())
+            for p.Current().Type == TokenComma {
+                p.Eat(TokenComma)
+                array.Elements = append(array.Elements, p.ParseExpression())
+            }
+        }
+        closing := p.Eat(TokenRBracket)
+        array.Span = Span{token.Pos, closing.Pos + 1}
+        return array
     }
 
     panic("[Ошибка] Ожидалось число или '(', но получено что-то другое")
 }
 //_______________________________________________________________________________________________________________________________
 
-// parseTerm обрабатывает умножение и деление.
-// parseTerm обрабатывает умножение и деление.
-func (p *Parser) ParseTerm() *Node {
+// ParsePower обрабатывает возведение в степень (правоассоциативно: 2^3^2 = 2^(3^2)).
+func (p *Parser) ParsePower() Node {
+    node := p.ParsePrimary()
+    if p.Current().Type == TokenPower {
+        p.Eat(TokenPower)
+        right := p.ParseFactor()
+        return &BinaryOp{Span: Span{node.Pos().Start, right.Pos().End}, Operator: "^", Left: node, Right: right}
+    }
+    return node
+}
+//_______________________________________________________________________________________________________________________________
+
+// ParseFactor обрабатывает унарный минус. Степень связывает сильнее: -2^2 = -(2^2).
+func (p *Parser) ParseFactor() Node {
+    token := p.Current()
+    if token.Type == TokenMinus {
+        p.Eat(TokenMinus)
+        operand := p.ParseFactor()
+        return &UnaryOp{Span: Span{token.Pos, operand.Pos().End}, Operator: "-", Operand: operand}
+    }
+    return p.ParsePower()
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseTerm обрабатывает умножение, деление и остаток.
+func (p *Parser) ParseTerm() Node {
     node := p.ParseFactor()
 
     for {
         token := p.Current()
-        if token.Type == TokenMultiply || token.Type == TokenDivide {
+        if token.Type == TokenMultiply || token.Type == TokenDivide || token.Type == TokenIntDivide || token.Type == TokenModulo {
             p.Eat(token.Type)
             right := p.ParseFactor()
-
-            // Проверка на деление на ноль
-            if token.Type == TokenDivide && right.Value == 0 {
-                panic("[Ошибка] Деление на ноль!")
-            }
-
             fmt.Printf("[Парсер (умножение/деление)] Операция: %s с узлами (%v, %v)\n", token.Value, node, right) // Отладка: вывод операции
-            if token.Type == TokenMultiply {
-                node = &Node{Operator: "*", Left: node, Right: right, Value: node.Value * right.Value}
-            } else {
-                node = &Node{Operator: "/", Left: node, Right: right, Value: node.Value / right.Value}
-            }
+            node = &BinaryOp{Span: Span{node.Pos().Start, right.Pos().End}, Operator: token.Value, Left: node, Right: right}
         } else {
             break
         }
@@ -165,8 +301,62 @@ func (p *Parser) ParseTerm() *Node {
 }
 //_______________________________________________________________________________________________________________________________
 
-// parseExpression обрабатывает сложение и вычитание.
-func (p *Parser) ParseExpression() *Node {
+// ParseExpression разбирает выражение целиком. Приоритет операций (от слабых к сильным):
+// or, and, not, сравнения, |, xor, &, сдвиги << и >>, сложение и вычитание, умножение и деление,
+// унарный минус, степень.
+func (p *Parser) ParseExpression() Node {
+    return p.parseBinary(func() Node {
+        return p.parseBinary(p.parseNot, TokenAndWord)
+    }, TokenOrWord)
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseNot обрабатывает логическое отрицание: not x > 3 = not (x > 3).
+func (p *Parser) parseNot() Node {
+    token := p.Current()
+    if token.Type != TokenNot {
+        return p.parseBinary(p.parseBitwise, TokenCompare)
+    }
+    p.Eat(TokenNot)
+    operand := p.parseNot()
+    return &UnaryOp{Span: Span{token.Pos, operand.Pos().End}, Operator: "not", Operand: operand}
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseBitwise обрабатывает побитовые операции и сдвиги.
+func (p *Parser) parseBitwise() Node {
+    return p.parseBinary(func() Node {
+        return p.parseBinary(func() Node {
+            return p.parseBinary(func() Node {
+                return p.parseBinary(p.ParseSum, TokenShiftLeft, TokenShiftRight)
+            }, TokenAnd)
+        }, TokenXor)
+    }, TokenOr)
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseBinary разбирает цепочку левоассоциативных операций одного приоритета с операндами operand.
+func (p *Parser) parseBinary(operand func() Node, types ...int) Node {
+    node := operand()
+
+    for {
+        token := p.Current()
+        matched := false
+        for _, tokenType := range types {
+            matched = matched || token.Type == tokenType
+        }
+        if !matched {
+            return node
+        }
+        p.Eat(token.Type)
+        right := operand()
+        node = &BinaryOp{Span: Span{node.Pos().Start, right.Pos().End}, Operator: token.Value, Left: node, Right: right}
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// ParseSum обрабатывает сложение и вычитание.
+func (p *Parser) ParseSum() Node {
     node := p.ParseTerm()
 
     for {
@@ -175,11 +365,7 @@ func (p *Parser) ParseExpression() *Node {
             p.Eat(token.Type)
             right := p.ParseTerm()
             fmt.Printf("[Парсер (сложение/вычитание)] Операция: %s с узлами (%v, %v)\n", token.Value, node, right) // Отладка: вывод операции
-            if token.Type == TokenPlus {
-                node = &Node{Operator: "+", Left: node, Right: right, Value: node.Value + right.Value}
-            } else {
-                node = &Node{Operator: "-", Left: node, Right: right, Value: node.Value - right.Value}
-            }
+            node = &BinaryOp{Span: Span{node.Pos().Start, right.Pos().End}, Operator: token.Value, Left: node, Right: right}
         } else {
             break
         }
@@ -189,15 +375,227 @@ func (p *Parser) ParseExpression() *Node {
 }
 //_______________________________________________________________________________________________________________________________
 
-// printAST выводит AST в виде строки (для отладки).
-func PrintAST(node *Node) string {
+// printAST выводит AST в виде строки (для отладки и как нормализованная форма выражения).
+func PrintAST(node Node) string {
+    switch n := node.(type) {
+    case *NumberLit:
+        if exact, ok := inexactInteger(n); ok {
+            return exact // целое, которое не помещается в float64 без потерь: ключи кеша не должны совпасть
+        }
+        return fmt.Sprintf("%v", n.Value)
+    case *Ident:
+        return n.Name
+    case *UnaryOp:
+        return fmt.Sprintf("(%s%s)", n.Operator, PrintAST(n.Operand))
+    case *BinaryOp:
+        return fmt.Sprintf("(%s %s %s)", PrintAST(n.Left), n.Operator, PrintAST(n.Right))
+    case *Call:
+        args := ""
+        for i, arg := range n.Args {
+            if i > 0 {
+                args += ", "
+            }
+            args += PrintAST(arg)
+        }
+        return fmt.Sprintf("%s(%s)", n.Name, args)
+    case *UserCall:
+        return PrintAST(&Call{Span: n.Span, Name: n.Function.Name, Args: n.Args})
+    case *ArrayLit:
+        elements := ""
+        for i, element := range n.Elements {
+            if i > 0 {
+                elements += ", "
+            }
+            elements += PrintAST(element)
+        }
+        return "[" + elements + "]"
+    }
+    return ""
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// Format записывает AST обратно в виде выражения, которое снова можно разобрать Parse.
+// Скобки ставятся только там, где без них изменится порядок операций.
+func Format(node Node) string {
+    return formatNode(node, false)
+}
+
+// Приоритет узла при записи: чем больше, тем сильнее связывает операция.
+const (
+    notPrecedence   = 3
+    unaryPrecedence = 11
+)
+
+func precedence(node Node) int {
+    switch n := node.(type) {
+    case *BinaryOp:
+        switch n.Operator {
+        case "or":
+            return 1
+        case "and":
+            return 2
+        case "==", "!=", "<", "<=", ">", ">=":
+            return 4
+        case "|":
+            return 5
+        case "xor":
+            return 6
+        case "&":
+            return 7
+        case "<<", ">>":
+            return 8
+        case "+", "-":
+            return 9
+        case "*", "/", "//", "%":
+            return 10
+        }
+        return 12 // "^"
+    case *UnaryOp:
+        if n.Operator == "not" {
+            return notPrecedence
+        }
+        return unaryPrecedence
+    case *NumberLit:
+        if n.Value < 0 {
+            return unaryPrecedence // записывается с унарным минусом
+        }
+    }
+    return 13
+}
+
+// formatNode записывает узел. nested — узел является операндом, и отрицательное число нужно взять в скобки.
+func formatNode(node Node, nested bool) string {
+    switch n := node.(type) {
+    case *NumberLit:
+        text := strconv.FormatFloat(n.Value, 'f', -1, 64)
+        if n.Text != "" && n.Value >= 0 && !strings.ContainsAny(n.Text, ".") {
+            text = n.Text // целое число — как записано (0xFF, большие числа без потери точности)
+        }
+        if n.Value < 0 && nested {
+            return "(" + text + ")"
+        }
+        return text
+    case *Ident:
+        return n.Name
+    case *UnaryOp:
+        if n.Operator == "not" {
+            operand := formatNode(n.Operand, false)
+            if precedence(n.Operand) < notPrecedence {
+                operand = "(" + operand + ")"
+            }
+            return "not " + operand
+        }
+        operand := formatNode(n.Operand, true)
+        if precedence(n.Operand) <= unaryPrecedence {
+            operand = "(" + formatNode(n.Operand, false) + ")"
+        }
+        text := n.Operator + operand
+        if nested {
+            return "(" + text + ")"
+        }
+        return text
+    case *BinaryOp:
+        parent := precedence(n)
+        left := formatNode(n.Left, true)
+        if leftPrec := precedence(n.Left); leftPrec < parent || (leftPrec == parent && n.Operator == "^") {
+            left = "(" + formatNode(n.Left, false) + ")"
+        }
+        right := formatNode(n.Right, true)
+        if rightPrec := precedence(n.Right); rightPrec < parent || (rightPrec == parent && !sameAssociative(n, n.Right)) {
+            right = "(" + formatNode(n.Right, false) + ")"
+        }
+        return left + " " + n.Operator + " " + right
+    case *Call:
+        args := ""
+        for i, arg := range n.Args {
+            if i > 0 {
+                args += ", "
+            }
+            args += formatNode(arg, false)
+        }
+        return n.Name + "(" + args + ")"
+    case *UserCall:
+        return formatNode(&Call{Span: n.Span, Name: n.Function.Name, Args: n.Args}, nested)
+    case *ArrayLit:
+        elements := ""
+        for i, element := range n.Elements {
+            if i > 0 {
+                elements += ", "
+            }
+            elements += formatNode(element, false)
+        }
+        return "[" + elements + "]"
+    }
+    return ""
+}
+
+// sameAssociative сообщает, что правый операнд — та же ассоциативная операция (a + (b + c), a * (b * c))
+// или степень (2^3^2), и скобки вокруг него не нужны.
+func sameAssociative(parent *BinaryOp, child Node) bool {
+    operation, ok := child.(*BinaryOp)
+    if !ok {
+        return true
+    }
+    if parent.Operator == "^" {
+        return true
+    }
+    return operation.Operator == parent.Operator && (parent.Operator == "+" || parent.Operator == "*")
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// parseNumber читает число: десятичное ("3.14") или целое с префиксом системы счисления ("0xFF", "0o17", "0b1010").
+func parseNumber(text string) (float64, error) {
+    if len(text) > 1 && text[0] == '0' && strings.IndexByte("xXoObB", text[1]) >= 0 {
+        value, ok := new(big.Int).SetString(text, 0)
+        if !ok {
+            return 0, fmt.Errorf("[Ошибка] Некорректное число %q", text)
+        }
+        result, _ := new(big.Float).SetInt(value).Float64()
+        return result, nil
+    }
+    return strconv.ParseFloat(text, 64)
+}
+//_______________________________________________________________________________________________________________________________
+
+// Parse разбирает выражение целиком и возвращает AST.
+// В отличие от ParseExpression не паникует: ошибки разбора возвращаются как error,
+// а лишние (неразобранные) токены в конце выражения считаются ошибкой.
+func Parse(input string) (node Node, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            node = nil
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+
+    tokens := Tokenize(input)
+    if len(tokens) == 0 {
+        return nil, fmt.Errorf("[Ошибка] Пустое выражение")
+    }
+
+    parser := Parser{Tokens: tokens}
+    node = parser.ParseExpression()
+    if parser.pos < len(parser.Tokens) {
+        return nil, fmt.Errorf("[Ошибка] Неожиданный токен %q (позиция %d)", parser.Current().Value, parser.Current().Pos)
+    }
+    return node, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// Depth возвращает глубину AST (число уровней от корня до самого глубокого листа).
+func Depth(node Node) int {
     if node == nil {
-        return ""
+        return 0
     }
-    if node.Operator == "" {
-        return fmt.Sprintf("%v", node.Value)
+    deepest := 0
+    for _, child := range Children(node) {
+        if depth := Depth(child); depth > deepest {
+            deepest = depth
+        }
     }
-    return fmt.Sprintf("(%s %s %s)", PrintAST(node.Left), node.Operator, PrintAST(node.Right))
+    return deepest + 1
 }
 
 //_______________________________________________________________________________________________________________________________
@@ -0,0 +1,180 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+    "math/cmplx"
+)
+
+// Complex — комплексное число в JSON: {"re": 3, "im": 4}.
+type Complex struct {
+    Re float64 `json:"re"`
+    Im float64 `json:"im"`
+}
+
+// ImaginaryUnits — имена мнимой единицы в комплексном режиме (j — как принято в электротехнике).
+// Переменные с тем же именем их перекрывают.
+var ImaginaryUnits = map[string]bool{
+    "i": true,
+    "j": true,
+}
+
+// MaxExactPower — наибольший по модулю целый показатель, до которого комплексная степень считается
+// умножением, а не через тригонометрическую форму cmplx.Pow.
+const MaxExactPower = 64
+
+// ComplexFunctions — встроенные функции комплексного аргумента. Остальные функции из Functions
+// (floor, ceil, round, min, max) в комплексном режиме принимают только действительные аргументы.
+var ComplexFunctions = map[string]func(args []complex128) complex128{
+    "sqrt": func(args []complex128) complex128 { return cmplx.Sqrt(args[0]) },
+    "abs":  func(args []complex128) complex128 { return complex(cmplx.Abs(args[0]), 0) },
+    "arg":  func(args []complex128) complex128 { return complex(cmplx.Phase(args[0]), 0) },
+    "conj": func(args []complex128) complex128 { return cmplx.Conj(args[0]) },
+    "re":   func(args []complex128) complex128 { return complex(real(args[0]), 0) },
+    "im":   func(args []complex128) complex128 { return complex(imag(args[0]), 0) },
+    "exp":  func(args []complex128) complex128 { return cmplx.Exp(args[0]) },
+    "ln":   func(args []complex128) complex128 { return cmplx.Log(args[0]) },
+    "log":  func(args []complex128) complex128 { return cmplx.Log10(args[0]) },
+    "sin":  func(args []complex128) complex128 { return cmplx.Sin(args[0]) },
+    "cos":  func(args []complex128) complex128 { return cmplx.Cos(args[0]) },
+    "tan":  func(args []complex128) complex128 { return cmplx.Tan(args[0]) },
+    "asin": func(args []complex128) complex128 { return cmplx.Asin(args[0]) },
+    "acos": func(args []complex128) complex128 { return cmplx.Acos(args[0]) },
+    "atan": func(args []complex128) complex128 { return cmplx.Atan(args[0]) },
+    "pow":  func(args []complex128) complex128 { return complexPow(args[0], args[1]) },
+}
+//_______________________________________________________________________________________________________________________________
+
+// EvalComplex вычисляет AST в комплексном режиме: i и j — мнимая единица, sqrt(-1) = i, ln(-1) = πi.
+// env — действительные значения переменных (может быть nil).
+func EvalComplex(node Node, env map[string]float64) (complex128, error) {
+    switch n := node.(type) {
+    case *NumberLit:
+        return complex(n.Value, 0), nil
+
+    case *Ident:
+        if value, ok := env[n.Name]; ok {
+            return complex(value, 0), nil
+        }
+        if ImaginaryUnits[n.Name] {
+            return 1i, nil
+        }
+        if value, ok := Constants[n.Name]; ok {
+            return complex(value, 0), nil
+        }
+        return 0, fmt.Errorf("[Ошибка] Неизвестная переменная %q", n.Name)
+
+    case *UnaryOp:
+        operand, err := EvalComplex(n.Operand, env)
+        if err != nil {
+            return 0, err
+        }
+        // 0 - z, а не -z: у -(1+0i) мнимая часть -0, и sqrt(-1) оказался бы по другую сторону разреза (-i)
+        return 0 - operand, nil
+
+    case *BinaryOp:
+        left, err := EvalComplex(n.Left, env)
+        if err != nil {
+            return 0, err
+        }
+        right, err := EvalComplex(n.Right, env)
+        if err != nil {
+            return 0, err
+        }
+        var result complex128
+        switch n.Operator {
+        case "+":
+            result = left + right
+        case "-":
+            result = left - right
+        case "*":
+            result = left * right
+        case "/":
+            if right == 0 {
+                return 0, fmt.Errorf("[Ошибка] Деление на ноль!")
+            }
+            result = left / right
+        case "^":
+            result = complexPow(left, right)
+        default:
+            return 0, fmt.Errorf("[Ошибка] Неизвестный оператор %q", n.Operator)
+        }
+        return checkComplex(n.Operator, result)
+
+    case *Call:
+        if IsSpecialForm(n) {
+            return 0, fmt.Errorf("[Ошибка] %s недоступна в комплексном режиме", n.Name)
+        }
+        function, err := lookupFunction(n)
+        if err != nil {
+            return 0, err
+        }
+        args := make([]complex128, len(n.Args))
+        for i, arg := range n.Args {
+            if args[i], err = EvalComplex(arg, env); err != nil {
+                return 0, err
+            }
+        }
+        if apply, ok := ComplexFunctions[n.Name]; ok {
+            return checkComplex(n.Name, apply(args))
+        }
+        // Функция только действительного аргумента
+        realArgs := make([]float64, len(args))
+        for i, arg := range args {
+            if imag(arg) != 0 {
+                return 0, fmt.Errorf("[Ошибка] Функция %s определена только для действительных чисел", n.Name)
+            }
+            realArgs[i] = real(arg)
+        }
+        return checkComplex(n.Name, complex(function.Apply(realArgs), 0))
+    }
+    return 0, fmt.Errorf("[Ошибка] Неизвестный узел AST %T", node)
+}
+
+// complexPow возводит в степень. Для действительных чисел, где степень определена
+// (неотрицательное основание или целый показатель), результат совпадает с math.Pow без погрешности
+// тригонометрической формы. Небольшая целая степень комплексного числа считается умножением: i^2 = -1 точно.
+func complexPow(base, exponent complex128) complex128 {
+    if imag(base) == 0 && imag(exponent) == 0 && (real(base) >= 0 || real(exponent) == math.Trunc(real(exponent))) {
+        return complex(math.Pow(real(base), real(exponent)), 0)
+    }
+    if n := real(exponent); imag(exponent) == 0 && n == math.Trunc(n) && math.Abs(n) <= MaxExactPower {
+        result := complex(1, 0)
+        for k := 0; k < int(math.Abs(n)); k++ {
+            result *= base
+        }
+        if n < 0 {
+            return 1 / result
+        }
+        return result
+    }
+    return cmplx.Pow(base, exponent)
+}
+
+// checkComplex отбрасывает NaN и бесконечность в любой из частей числа.
+func checkComplex(operation string, result complex128) (complex128, error) {
+    if cmplx.IsNaN(result) {
+        return 0, fmt.Errorf("[Ошибка] Аргумент вне области определения: %s", operation)
+    }
+    if cmplx.IsInf(result) {
+        return 0, fmt.Errorf("[Ошибка] Переполнение: %s", operation)
+    }
+    return result, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// ValidateComplex проверяет AST для комплексного режима: как Validate, но i и j считаются определёнными.
+func ValidateComplex(node Node, env map[string]float64) error {
+    if err := rejectLogic(node, "complex"); err != nil {
+        return err
+    }
+    extended := make(map[string]float64, len(env)+len(ImaginaryUnits))
+    for name := range ImaginaryUnits {
+        extended[name] = 0
+    }
+    for name, value := range env {
+        extended[name] = value
+    }
+    return Validate(node, extended)
+}
+//_______________________________________________________________________________________________________________________________
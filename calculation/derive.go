@@ -0,0 +1,264 @@
+package calculation
+
+import (
+    "fmt"
+    "sort"
+)
+
+// Derive возвращает производную выражения по переменной variable: правила суммы, произведения,
+// частного, степени и цепное правило для встроенных функций. Результат упрощается (Simplify).
+func Derive(node Node, variable string) (Node, error) {
+    derivative, err := derive(node, variable)
+    if err != nil {
+        return nil, err
+    }
+    return Simplify(derivative), nil
+}
+
+func derive(node Node, x string) (Node, error) {
+    if !dependsOn(node, x) {
+        return number(0), nil
+    }
+
+    switch n := node.(type) {
+    case *Ident:
+        return number(1), nil // зависит от x, значит это сама переменная x
+
+    case *UnaryOp:
+        if n.Operator != "-" {
+            break
+        }
+        operand, err := derive(n.Operand, x)
+        if err != nil {
+            return nil, err
+        }
+        return neg(operand), nil
+
+    case *BinaryOp:
+        u, v := n.Left, n.Right
+        du, err := derive(u, x)
+        if err != nil {
+            return nil, err
+        }
+        dv, err := derive(v, x)
+        if err != nil {
+            return nil, err
+        }
+        switch n.Operator {
+        case "+":
+            return add(du, dv), nil
+        case "-":
+            return sub(du, dv), nil
+        case "*":
+            // (uv)' = u'v + uv'
+            return add(mul(du, v), mul(u, dv)), nil
+        case "/":
+            // (u/v)' = (u'v - uv') / v^2
+            return div(sub(mul(du, v), mul(u, dv)), pow(v, number(2))), nil
+        case "^":
+            return derivePower(u, v, du, dv, x), nil
+        }
+
+    case *Call:
+        return deriveCall(n, x)
+    }
+    return nil, fmt.Errorf("[Ошибка] Невозможно продифференцировать %s", PrintAST(node))
+}
+//_______________________________________________________________________________________________________________________________
+
+// derivePower дифференцирует u^v.
+func derivePower(u, v, du, dv Node, x string) Node {
+    // (u^n)' = n * u^(n-1) * u'
+    if !dependsOn(v, x) {
+        return mul(mul(v, pow(u, sub(v, number(1)))), du)
+    }
+    // (a^v)' = a^v * ln(a) * v'
+    if !dependsOn(u, x) {
+        return mul(mul(pow(u, v), call("ln", u)), dv)
+    }
+    // (u^v)' = u^v * (v' * ln(u) + v * u' / u)
+    return mul(pow(u, v), add(mul(dv, call("ln", u)), div(mul(v, du), u)))
+}
+
+// deriveCall дифференцирует вызов функции по цепному правилу: f(u)' = f'(u) * u'.
+func deriveCall(n *Call, x string) (Node, error) {
+    if n.Name == "pow" && len(n.Args) == 2 {
+        return derive(&BinaryOp{Span: n.Span, Operator: "^", Left: n.Args[0], Right: n.Args[1]}, x)
+    }
+    // Кусочная функция: if(c, u, v)' = if(c, u', v') (в точках переключения производная может не существовать)
+    if n.Name == "if" && len(n.Args) == 3 {
+        du, err := derive(n.Args[1], x)
+        if err != nil {
+            return nil, err
+        }
+        dv, err := derive(n.Args[2], x)
+        if err != nil {
+            return nil, err
+        }
+        return call("if", n.Args[0], du, dv), nil
+    }
+    if _, err := lookupFunction(n); err != nil {
+        return nil, err
+    }
+    if len(n.Args) != 1 {
+        return nil, fmt.Errorf("[Ошибка] Функция %s не дифференцируема", n.Name)
+    }
+
+    u := n.Args[0]
+    du, err := derive(u, x)
+    if err != nil {
+        return nil, err
+    }
+
+    var outer Node
+    switch n.Name {
+    case "sqrt":
+        outer = div(number(1), mul(number(2), call("sqrt", u)))
+    case "abs":
+        outer = div(u, call("abs", u))
+    case "exp":
+        outer = call("exp", u)
+    case "ln":
+        outer = div(number(1), u)
+    case "log":
+        outer = div(number(1), mul(u, call("ln", number(10))))
+    case "sin":
+        outer = call("cos", u)
+    case "cos":
+        outer = neg(call("sin", u))
+    case "tan":
+        outer = div(number(1), pow(call("cos", u), number(2)))
+    case "asin":
+        outer = div(number(1), call("sqrt", sub(number(1), pow(u, number(2)))))
+    case "acos":
+        outer = neg(div(number(1), call("sqrt", sub(number(1), pow(u, number(2))))))
+    case "atan":
+        outer = div(number(1), add(number(1), pow(u, number(2))))
+    default:
+        return nil, fmt.Errorf("[Ошибка] Функция %s не дифференцируема", n.Name)
+    }
+    return mul(outer, du), nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// Variables возвращает имена всех свободных переменных выражения (включая константы pi и e) по алфавиту.
+// Связанные переменные integrate и sum не входят в список.
+func Variables(node Node) []string {
+    seen := make(map[string]bool)
+    var collect func(node Node, bound map[string]bool)
+    collect = func(node Node, bound map[string]bool) {
+        if ident, ok := node.(*Ident); ok && !bound[ident.Name] {
+            seen[ident.Name] = true
+        }
+        if call, ok := node.(*Call); ok && IsSpecialForm(call) {
+            if variable, err := specialForm(call); err == nil {
+                inner := map[string]bool{variable: true}
+                for name := range bound {
+                    inner[name] = true
+                }
+                collect(call.Args[0], inner)
+                collect(call.Args[2], bound)
+                collect(call.Args[3], bound)
+                return
+            }
+        }
+        for _, child := range Children(node) {
+            collect(child, bound)
+        }
+    }
+    collect(node, nil)
+
+    names := make([]string, 0, len(seen))
+    for name := range seen {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// dependsOn сообщает, что выражение содержит свободную переменную x.
+func dependsOn(node Node, x string) bool {
+    if ident, ok := node.(*Ident); ok {
+        return ident.Name == x
+    }
+    if call, ok := node.(*Call); ok && IsSpecialForm(call) {
+        if variable, err := specialForm(call); err == nil {
+            return (variable != x && dependsOn(call.Args[0], x)) || dependsOn(call.Args[2], x) || dependsOn(call.Args[3], x)
+        }
+    }
+    for _, child := range Children(node) {
+        if dependsOn(child, x) {
+            return true
+        }
+    }
+    return false
+}
+//_______________________________________________________________________________________________________________________________
+
+// Конструкторы узлов для производных. Они сразу убирают умножение на 0 и 1 и сложение с 0,
+// которых в производных много; остальное упрощает Simplify.
+
+func number(value float64) Node { return &NumberLit{Value: value} }
+
+func call(name string, args ...Node) Node { return &Call{Name: name, Args: args} }
+
+func neg(u Node) Node {
+    if n, ok := u.(*NumberLit); ok {
+        return number(-n.Value)
+    }
+    return &UnaryOp{Operator: "-", Operand: u}
+}
+
+func add(u, v Node) Node {
+    if isNumber(u, 0) {
+        return v
+    }
+    if isNumber(v, 0) {
+        return u
+    }
+    return &BinaryOp{Operator: "+", Left: u, Right: v}
+}
+
+func sub(u, v Node) Node {
+    if isNumber(v, 0) {
+        return u
+    }
+    if isNumber(u, 0) {
+        return neg(v)
+    }
+    return &BinaryOp{Operator: "-", Left: u, Right: v}
+}
+
+func mul(u, v Node) Node {
+    if isNumber(u, 0) || isNumber(v, 0) {
+        return number(0)
+    }
+    if isNumber(u, 1) {
+        return v
+    }
+    if isNumber(v, 1) {
+        return u
+    }
+    return &BinaryOp{Operator: "*", Left: u, Right: v}
+}
+
+func div(u, v Node) Node {
+    if isNumber(u, 0) {
+        return number(0)
+    }
+    if isNumber(v, 1) {
+        return u
+    }
+    return &BinaryOp{Operator: "/", Left: u, Right: v}
+}
+
+func pow(u, v Node) Node {
+    if isNumber(v, 0) {
+        return number(1)
+    }
+    if isNumber(v, 1) {
+        return u
+    }
+    return &BinaryOp{Operator: "^", Left: u, Right: v}
+}
+//_______________________________________________________________________________________________________________________________
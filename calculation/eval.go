@@ -0,0 +1,232 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+)
+
+// Function — встроенная функция.
+type Function struct {
+    MinArgs int
+    MaxArgs int // -1 — любое число аргументов
+    Apply   func(args []float64) float64
+}
+
+// Constants — встроенные константы. Переменные с тем же именем их перекрывают.
+var Constants = map[string]float64{
+    "pi": math.Pi,
+    "e":  math.E,
+}
+
+// Functions — встроенные функции.
+var Functions = map[string]Function{
+    "sqrt":  unaryFunction(math.Sqrt),
+    "abs":   unaryFunction(math.Abs),
+    "exp":   unaryFunction(math.Exp),
+    "ln":    unaryFunction(math.Log),
+    "log":   unaryFunction(math.Log10),
+    "sin":   unaryFunction(math.Sin),
+    "cos":   unaryFunction(math.Cos),
+    "tan":   unaryFunction(math.Tan),
+    "asin":  unaryFunction(math.Asin),
+    "acos":  unaryFunction(math.Acos),
+    "atan":  unaryFunction(math.Atan),
+    "floor": unaryFunction(math.Floor),
+    "ceil":  unaryFunction(math.Ceil),
+    "round": unaryFunction(math.Round),
+    "pow":   {MinArgs: 2, MaxArgs: 2, Apply: func(args []float64) float64 { return math.Pow(args[0], args[1]) }},
+    "min":   {MinArgs: 1, MaxArgs: -1, Apply: func(args []float64) float64 { return fold(args, math.Min) }},
+    "max":   {MinArgs: 1, MaxArgs: -1, Apply: func(args []float64) float64 { return fold(args, math.Max) }},
+
+    // Статистика (stats.go): аргументы — числа и списки; sum с четырьмя аргументами — специальная форма
+    "sum":        {MinArgs: 1, MaxArgs: -1, Apply: sumValues},
+    "count":      {MinArgs: 1, MaxArgs: -1, Apply: func(args []float64) float64 { return float64(len(args)) }},
+    "mean":       {MinArgs: 1, MaxArgs: -1, Apply: mean},
+    "median":     {MinArgs: 1, MaxArgs: -1, Apply: median},
+    "variance":   {MinArgs: 1, MaxArgs: -1, Apply: variance},
+    "stddev":     {MinArgs: 1, MaxArgs: -1, Apply: func(args []float64) float64 { return math.Sqrt(variance(args)) }},
+    "percentile": {MinArgs: 2, MaxArgs: -1, Apply: percentile},
+
+    // Для действительных чисел; в комплексном режиме см. ComplexFunctions
+    "re":   unaryFunction(func(x float64) float64 { return x }),
+    "im":   unaryFunction(func(x float64) float64 { return 0 }),
+    "conj": unaryFunction(func(x float64) float64 { return x }),
+    "arg":  unaryFunction(func(x float64) float64 { return math.Atan2(0, x) }),
+}
+
+func unaryFunction(f func(float64) float64) Function {
+    return Function{MinArgs: 1, MaxArgs: 1, Apply: func(args []float64) float64 { return f(args[0]) }}
+}
+
+func fold(args []float64, f func(a, b float64) float64) float64 {
+    result := args[0]
+    for _, arg := range args[1:] {
+        result = f(result, arg)
+    }
+    return result
+}
+//_______________________________________________________________________________________________________________________________
+
+// Eval вычисляет AST, значение которого — число. env — значения переменных (может быть nil).
+// Ошибки вычисления (деление на ноль, неизвестная переменная, выход за область определения) возвращаются как error,
+// логическое значение вместо числа — как *TypeError.
+func Eval(node Node, env map[string]float64) (float64, error) {
+    value, err := EvalValue(node, env)
+    if err != nil {
+        return 0, err
+    }
+    if value.Kind != KindNumber {
+        return 0, &TypeError{fmt.Sprintf("[Ошибка] Ожидалось число, а не %s: %s", value.Kind, PrintAST(node))}
+    }
+    return value.Number, nil
+}
+
+// evalNumber вычисляет числа, переменные и integrate/sum. Остальные узлы вычисляет EvalValue.
+func evalNumber(node Node, env map[string]float64) (float64, error) {
+    switch n := node.(type) {
+    case *NumberLit:
+        return n.Value, nil
+
+    case *Ident:
+        if value, ok := env[n.Name]; ok {
+            return value, nil
+        }
+        if value, ok := Constants[n.Name]; ok {
+            return value, nil
+        }
+        return 0, fmt.Errorf("[Ошибка] Неизвестная переменная %q", n.Name)
+
+    case *Call:
+        if IsSpecialForm(n) {
+            return evalSpecialForm(n, env)
+        }
+    }
+    return 0, fmt.Errorf("[Ошибка] Неизвестный узел AST %T", node)
+}
+
+// arithmetic выполняет бинарную арифметическую операцию над числами.
+func arithmetic(operator string, left, right float64) (float64, error) {
+    var result float64
+    switch operator {
+    case "+":
+        result = left + right
+    case "-":
+        result = left - right
+    case "*":
+        result = left * right
+    case "/":
+        if right == 0 {
+            return 0, fmt.Errorf("[Ошибка] Деление на ноль!")
+        }
+        result = left / right
+    case "//", "%":
+        if right == 0 {
+            return 0, fmt.Errorf("[Ошибка] Деление на ноль!")
+        }
+        result = math.Floor(left / right)
+        if operator == "%" {
+            result = math.Mod(left, right) // остаток со знаком делимого, как в целочисленном режиме
+        }
+    case "^":
+        result = math.Pow(left, right)
+    default:
+        if IntegerOperators[operator] {
+            return 0, fmt.Errorf("[Ошибка] Оператор %q доступен только в целочисленном режиме", operator)
+        }
+        return 0, fmt.Errorf("[Ошибка] Неизвестный оператор %q", operator)
+    }
+    return checkResult(operator, result)
+}
+
+// checkResult отбрасывает NaN и бесконечность: их нельзя вернуть клиенту в JSON.
+func checkResult(operation string, result float64) (float64, error) {
+    if math.IsNaN(result) {
+        return 0, fmt.Errorf("[Ошибка] Аргумент вне области определения: %s", operation)
+    }
+    if math.IsInf(result, 0) {
+        return 0, fmt.Errorf("[Ошибка] Переполнение: %s", operation)
+    }
+    return result, nil
+}
+
+// lookupFunction находит встроенную функцию и проверяет число аргументов.
+func lookupFunction(call *Call) (Function, error) {
+    function, ok := Functions[call.Name]
+    if !ok {
+        return function, fmt.Errorf("[Ошибка] Неизвестная функция %q", call.Name)
+    }
+    if len(call.Args) < function.MinArgs || (function.MaxArgs >= 0 && len(call.Args) > function.MaxArgs) {
+        return function, fmt.Errorf("[Ошибка] Неверное число аргументов функции %s: %d", call.Name, len(call.Args))
+    }
+    return function, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// Validate проверяет AST без вычисления: все переменные заданы в env (или являются константами),
+// функции существуют и вызваны с правильным числом аргументов.
+func Validate(node Node, env map[string]float64) error {
+    switch n := node.(type) {
+    case *Ident:
+        if _, ok := env[n.Name]; !ok {
+            if _, ok := Constants[n.Name]; !ok {
+                return fmt.Errorf("[Ошибка] Неизвестная переменная %q", n.Name)
+            }
+        }
+    case *BinaryOp:
+        if IntegerOperators[n.Operator] {
+            return fmt.Errorf("[Ошибка] Оператор %q доступен только в целочисленном режиме", n.Operator)
+        }
+    case *UserCall:
+        if len(n.Args) != len(n.Function.Params) {
+            return fmt.Errorf("[Ошибка] Неверное число аргументов функции %s: %d", n.Function.Name, len(n.Args))
+        }
+    case *Call:
+        if IsSpecialForm(n) {
+            return validateSpecialForm(n, env)
+        }
+        if function, ok := MatrixFunctions[n.Name]; ok {
+            if len(n.Args) != function.Args {
+                return fmt.Errorf("[Ошибка] Неверное число аргументов функции %s: %d", n.Name, len(n.Args))
+            }
+        } else if n.Name == "if" {
+            if len(n.Args) != 3 {
+                return fmt.Errorf("[Ошибка] Неверное число аргументов функции if: %d", len(n.Args))
+            }
+        } else if _, err := lookupFunction(n); err != nil {
+            return err
+        }
+    }
+    for _, child := range Children(node) {
+        if err := Validate(child, env); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// validateSpecialForm проверяет integrate и sum: пределы — с переменными env, выражение — ещё и со связанной переменной.
+// Счётчик sum не должен совпадать с именем, у которого есть значение: sum(a, b, c, d) при заданном b
+// читается и как сумма чисел, и как сумма по b, поэтому такая запись — ошибка.
+func validateSpecialForm(call *Call, env map[string]float64) error {
+    variable, err := specialForm(call)
+    if err != nil {
+        return err
+    }
+    if _, ok := env[variable]; ok && call.Name == "sum" {
+        return fmt.Errorf("[Ошибка] Счётчик %s в sum(выражение, %s, от, до) совпадает с переменной; переименуйте счётчик, а сумму четырёх чисел запишите как sum([a, b, c, d])", variable, variable)
+    }
+    for _, limit := range call.Args[2:] {
+        if err := Validate(limit, env); err != nil {
+            return err
+        }
+    }
+    inner := map[string]float64{variable: 0}
+    for name, value := range env {
+        if name != variable {
+            inner[name] = value
+        }
+    }
+    return Validate(call.Args[0], inner)
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,302 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+    "math/big"
+    "strings"
+)
+
+// Разрядность целочисленного режима.
+const (
+    WidthInt64 = "int64" // 64-битные знаковые целые, переполнение — ошибка
+    WidthBig   = "big"   // произвольная точность (math/big)
+)
+
+// MaxIntegerBits — предел длины числа в режиме произвольной точности: 2^10000000 не должно съесть память.
+const MaxIntegerBits = 1 << 16
+
+// IntegerOperators — операторы, доступные только в целочисленном режиме.
+var IntegerOperators = map[string]bool{
+    "&":   true,
+    "|":   true,
+    "xor": true,
+    "<<":  true,
+    ">>":  true,
+}
+
+// IntegerFunctions — встроенные функции целочисленного режима. Остальные функции из Functions
+// (sqrt, sin, ...) возвращают дробные значения и в этом режиме недоступны.
+var IntegerFunctions = map[string]func(args []*big.Int) *big.Int{
+    "abs": func(args []*big.Int) *big.Int { return new(big.Int).Abs(args[0]) },
+    "min": func(args []*big.Int) *big.Int { return foldInteger(args, -1) },
+    "max": func(args []*big.Int) *big.Int { return foldInteger(args, 1) },
+}
+
+// foldInteger выбирает наименьший (sign = -1) или наибольший (sign = 1) аргумент.
+func foldInteger(args []*big.Int, sign int) *big.Int {
+    result := args[0]
+    for _, arg := range args[1:] {
+        if arg.Cmp(result) == sign {
+            result = arg
+        }
+    }
+    return new(big.Int).Set(result)
+}
+//_______________________________________________________________________________________________________________________________
+
+// EvalInteger вычисляет AST в целочисленном режиме разрядности width (WidthInt64 или WidthBig).
+// "/" делит с отбрасыванием дробной части, "//" — с округлением вниз, "%" — остаток со знаком делимого.
+// Побитовые операции над отрицательными числами — как в дополнительном коде. env — значения переменных,
+// они должны быть целыми. В int64 переполнением считается выход за разрядность любой операции,
+// а не только итогового результата: (1 << 70) >> 10 — ошибка, как и в 64-битной арифметике.
+func EvalInteger(node Node, env map[string]float64, width string) (*big.Int, error) {
+    if width != WidthInt64 && width != WidthBig {
+        return nil, fmt.Errorf("[Ошибка] Неизвестная разрядность %q", width)
+    }
+    result, err := evalInteger(node, env, width)
+    if err != nil {
+        return nil, err
+    }
+    return result, checkInteger("результат", result, width)
+}
+
+func evalInteger(node Node, env map[string]float64, width string) (*big.Int, error) {
+    switch n := node.(type) {
+    case *NumberLit:
+        return integerLiteral(n)
+
+    case *Ident:
+        if value, ok := env[n.Name]; ok {
+            return integerValue(n.Name, value)
+        }
+        if _, ok := Constants[n.Name]; ok {
+            return nil, fmt.Errorf("[Ошибка] Константа %s недоступна в целочисленном режиме", n.Name)
+        }
+        return nil, fmt.Errorf("[Ошибка] Неизвестная переменная %q", n.Name)
+
+    case *UnaryOp:
+        operand, err := evalInteger(n.Operand, env, width)
+        if err != nil {
+            return nil, err
+        }
+        // Операнд не проверяется: -9223372036854775808 — наименьшее int64, хотя 9223372036854775808 в него не входит
+        operand.Neg(operand)
+        return operand, checkInteger("-", operand, width)
+
+    case *BinaryOp:
+        left, err := evalInteger(n.Left, env, width)
+        if err != nil {
+            return nil, err
+        }
+        right, err := evalInteger(n.Right, env, width)
+        if err != nil {
+            return nil, err
+        }
+        return integerOperation(n.Operator, left, right, width)
+
+    case *Call:
+        if n.Name == "pow" && len(n.Args) == 2 {
+            return evalInteger(&BinaryOp{Span: n.Span, Operator: "^", Left: n.Args[0], Right: n.Args[1]}, env, width)
+        }
+        apply, ok := IntegerFunctions[n.Name]
+        if !ok {
+            return nil, fmt.Errorf("[Ошибка] Функция %s недоступна в целочисленном режиме", n.Name)
+        }
+        if _, err := lookupFunction(n); err != nil {
+            return nil, err
+        }
+        args := make([]*big.Int, len(n.Args))
+        for i, arg := range n.Args {
+            var err error
+            if args[i], err = evalInteger(arg, env, width); err != nil {
+                return nil, err
+            }
+            if err := checkInteger(n.Name, args[i], width); err != nil {
+                return nil, err
+            }
+        }
+        result := apply(args)
+        return result, checkInteger(n.Name, result, width)
+    }
+    return nil, fmt.Errorf("[Ошибка] Неизвестный узел AST %T", node)
+}
+//_______________________________________________________________________________________________________________________________
+
+// integerOperation выполняет бинарную операцию над целыми числами разрядности width.
+// В int64 операнды и результат проверяются на переполнение.
+func integerOperation(operator string, left, right *big.Int, width string) (*big.Int, error) {
+    if err := checkInteger(operator, left, width); err != nil {
+        return nil, err
+    }
+    if err := checkInteger(operator, right, width); err != nil {
+        return nil, err
+    }
+    result := new(big.Int)
+    switch operator {
+    case "+":
+        result.Add(left, right)
+    case "-":
+        result.Sub(left, right)
+    case "*":
+        result.Mul(left, right)
+    case "/", "//", "%":
+        if right.Sign() == 0 {
+            return nil, fmt.Errorf("[Ошибка] Деление на ноль!")
+        }
+        remainder := new(big.Int)
+        result.QuoRem(left, right, remainder)
+        if operator == "%" {
+            return remainder, nil
+        }
+        // Округление вниз: у ненулевого остатка знак не совпадает со знаком делителя
+        if operator == "//" && remainder.Sign() != 0 && remainder.Sign() != right.Sign() {
+            result.Sub(result, big.NewInt(1))
+        }
+    case "^":
+        if right.Sign() < 0 {
+            return nil, fmt.Errorf("[Ошибка] Отрицательная степень в целочисленном режиме")
+        }
+        if left.BitLen() > 1 && (!right.IsInt64() || int64(left.BitLen()-1)*right.Int64() > MaxIntegerBits) {
+            return nil, fmt.Errorf("[Ошибка] Переполнение: ^")
+        }
+        result.Exp(left, right, nil)
+    case "&":
+        result.And(left, right)
+    case "|":
+        result.Or(left, right)
+    case "xor":
+        result.Xor(left, right)
+    case "<<", ">>":
+        if right.Sign() < 0 {
+            return nil, fmt.Errorf("[Ошибка] Отрицательный сдвиг: %s", operator)
+        }
+        if !right.IsInt64() || right.Int64() > MaxIntegerBits {
+            if operator == ">>" {
+                // Все значащие биты ушли: остаётся 0 или -1 у отрицательного числа
+                if left.Sign() < 0 {
+                    return big.NewInt(-1), nil
+                }
+                return new(big.Int), nil
+            }
+            return nil, fmt.Errorf("[Ошибка] Переполнение: <<")
+        }
+        if operator == "<<" {
+            result.Lsh(left, uint(right.Int64()))
+        } else {
+            result.Rsh(left, uint(right.Int64()))
+        }
+    default:
+        return nil, fmt.Errorf("[Ошибка] Неизвестный оператор %q", operator)
+    }
+    if result.BitLen() > MaxIntegerBits {
+        return nil, fmt.Errorf("[Ошибка] Переполнение: %s", operator)
+    }
+    return result, checkInteger(operator, result, width)
+}
+//_______________________________________________________________________________________________________________________________
+
+// integerLiteral читает целое число из записи в выражении: десятичной или с префиксом 0x, 0o, 0b.
+func integerLiteral(n *NumberLit) (*big.Int, error) {
+    if n.Text == "" {
+        return integerValue(PrintAST(n), n.Value)
+    }
+    base := 10
+    if len(n.Text) > 1 && n.Text[0] == '0' && strings.IndexByte("xXoObB", n.Text[1]) >= 0 {
+        base = 0 // основание по префиксу
+    }
+    value, ok := new(big.Int).SetString(n.Text, base)
+    if !ok {
+        return nil, fmt.Errorf("[Ошибка] Число %s не целое", n.Text)
+    }
+    return value, nil
+}
+
+// inexactInteger возвращает точную десятичную запись целого литерала, если float64 его округляет.
+func inexactInteger(n *NumberLit) (string, bool) {
+    if n.Text == "" || strings.Contains(n.Text, ".") {
+        return "", false
+    }
+    value, err := integerLiteral(n)
+    if err != nil {
+        return "", false
+    }
+    rounded, _ := big.NewFloat(n.Value).Int(nil)
+    if rounded.Cmp(value) == 0 {
+        return "", false
+    }
+    return value.String(), true
+}
+
+// integerValue переводит значение переменной в целое число.
+func integerValue(name string, value float64) (*big.Int, error) {
+    if value != math.Trunc(value) || math.IsInf(value, 0) {
+        return nil, fmt.Errorf("[Ошибка] Значение %s = %v не целое", name, value)
+    }
+    result, _ := big.NewFloat(value).Int(nil)
+    return result, nil
+}
+
+// checkInteger проверяет, что число помещается в разрядность width.
+func checkInteger(operation string, value *big.Int, width string) error {
+    if width == WidthInt64 && !value.IsInt64() {
+        return fmt.Errorf("[Ошибка] Переполнение int64: %s", operation)
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// FormatInteger записывает целое число в системе счисления base (от 2 до 36): 0b1010, 0o17, 0xFF,
+// в остальных системах — без префикса. Знак минуса ставится перед префиксом: -0xFF.
+func FormatInteger(value *big.Int, base int) string {
+    prefix := map[int]string{2: "0b", 8: "0o", 16: "0x"}[base]
+    digits := strings.ToUpper(new(big.Int).Abs(value).Text(base))
+    if value.Sign() < 0 {
+        return "-" + prefix + digits
+    }
+    return prefix + digits
+}
+//_______________________________________________________________________________________________________________________________
+
+// ValidateInteger проверяет AST для целочисленного режима без вычисления: переменные заданы и целые,
+// числа целые, функции доступны в этом режиме.
+func ValidateInteger(node Node, env map[string]float64) error {
+    if err := rejectLogic(node, "integer"); err != nil {
+        return err
+    }
+    return validateInteger(node, env)
+}
+
+func validateInteger(node Node, env map[string]float64) error {
+    switch n := node.(type) {
+    case *NumberLit:
+        if _, err := integerLiteral(n); err != nil {
+            return err
+        }
+    case *Ident:
+        value, ok := env[n.Name]
+        if !ok {
+            if _, ok := Constants[n.Name]; ok {
+                return fmt.Errorf("[Ошибка] Константа %s недоступна в целочисленном режиме", n.Name)
+            }
+            return fmt.Errorf("[Ошибка] Неизвестная переменная %q", n.Name)
+        }
+        if _, err := integerValue(n.Name, value); err != nil {
+            return err
+        }
+    case *Call:
+        if _, ok := IntegerFunctions[n.Name]; !ok && n.Name != "pow" {
+            return fmt.Errorf("[Ошибка] Функция %s недоступна в целочисленном режиме", n.Name)
+        }
+        if _, err := lookupFunction(n); err != nil {
+            return err
+        }
+    }
+    for _, child := range Children(node) {
+        if err := validateInteger(child, env); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
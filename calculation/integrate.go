@@ -0,0 +1,253 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+)
+
+// Специальные формы integrate(выражение, x, a, b) и sum(выражение, i, от, до): аргументы не вычисляются
+// заранее, второй аргумент — связанная переменная, которая пробегает пределы.
+var SpecialForms = map[string]bool{
+    "integrate": true,
+    "sum":       true,
+}
+
+// IsSpecialForm сообщает, что вызов — специальная форма. sum не с четырьмя аргументами — сумма
+// чисел и списков (см. Functions), с четырьмя — всегда sum(выражение, i, от, до). Смысл записи
+// не зависит от variables: если у i есть значение, проверка отклоняет её (см. validateSpecialForm),
+// а сумму четырёх чисел пишут как sum([a, b, c, d]).
+func IsSpecialForm(call *Call) bool {
+    return SpecialForms[call.Name] && (call.Name != "sum" || len(call.Args) == 4)
+}
+
+// sumVariable возвращает второй аргумент sum(выражение, i, от, до), если это имя.
+func sumVariable(call *Call) (*Ident, bool) {
+    if call.Name != "sum" || len(call.Args) != 4 {
+        return nil, false
+    }
+    ident, ok := call.Args[1].(*Ident)
+    return ident, ok
+}
+
+// UsesSpecialForms сообщает, что в выражении есть integrate или sum(выражение, i, от, до).
+// Они могут вычисляться долго, поэтому оркестратор сам их не вычисляет — только агенты.
+func UsesSpecialForms(node Node) bool {
+    if call, ok := node.(*Call); ok && IsSpecialForm(call) {
+        return true
+    }
+    for _, child := range Children(node) {
+        if UsesSpecialForms(child) {
+            return true
+        }
+    }
+    return false
+}
+
+const (
+    MaxSumTerms        = 10000000 // наибольшее число слагаемых в sum
+    MaxSumBound        = 1 << 53  // наибольший по модулю предел sum: дальше не все целые представимы в float64
+    integrateTolerance = 1e-10    // относительная точность интегрирования
+    integrateMaxParts  = 2000     // наибольшее число отрезков адаптивного деления
+)
+
+// Узлы и веса квадратуры Гаусса–Кронрода: 15 точек Кронрода, каждая вторая из них — точка Гаусса (7 точек).
+var (
+    kronrodNodes = [8]float64{
+        0.991455371120812639206854697526329, 0.949107912342758524526189684047851,
+        0.864864423359769072789712788640926, 0.741531185599394439863864773280788,
+        0.586087235467691130294144845693013, 0.405845151377397166906606412076961,
+        0.207784955007898467600689403773245, 0,
+    }
+    kronrodWeights = [8]float64{
+        0.022935322010529224963732008058970, 0.063092092629978553290700663189204,
+        0.104790010322250183839876322541518, 0.140653259715525918745189590510238,
+        0.169004726639267902826583426598550, 0.190350578064785409913256402421014,
+        0.204432940075298892414161999234649, 0.209482141084727828012999174891714,
+    }
+    gaussWeights = [4]float64{
+        0.129484966168869693270611432679082, 0.279705391489276667901467771423780,
+        0.381830050505118944950369775488975, 0.417959183673469387755102040816327,
+    }
+)
+//_______________________________________________________________________________________________________________________________
+
+// specialForm проверяет вызов специальной формы: четыре аргумента, второй — имя переменной.
+// Возвращает имя связанной переменной.
+func specialForm(call *Call) (string, error) {
+    if len(call.Args) != 4 {
+        return "", fmt.Errorf("[Ошибка] %s ожидает 4 аргумента: %s(выражение, переменная, от, до)", call.Name, call.Name)
+    }
+    variable, ok := call.Args[1].(*Ident)
+    if !ok && call.Name == "sum" {
+        return "", fmt.Errorf("[Ошибка] Второй аргумент sum(выражение, i, от, до) должен быть именем счётчика без значения; сумму четырёх чисел запишите как sum([a, b, c, d])")
+    }
+    if !ok {
+        return "", fmt.Errorf("[Ошибка] Второй аргумент %s должен быть именем переменной", call.Name)
+    }
+    return variable.Name, nil
+}
+
+// evalSpecialForm вычисляет integrate или sum.
+func evalSpecialForm(call *Call, env map[string]float64) (float64, error) {
+    variable, err := specialForm(call)
+    if err != nil {
+        return 0, err
+    }
+    from, err := Eval(call.Args[2], env)
+    if err != nil {
+        return 0, err
+    }
+    to, err := Eval(call.Args[3], env)
+    if err != nil {
+        return 0, err
+    }
+    if call.Name == "sum" {
+        return Sum(call.Args[0], variable, env, from, to)
+    }
+    value, _, err := Integrate(call.Args[0], variable, env, from, to)
+    return value, err
+}
+
+// EvalWithErrorEstimate вычисляет выражение и, если это интеграл integrate(...), возвращает оценку его погрешности.
+func EvalWithErrorEstimate(node Node, env map[string]float64) (float64, float64, error) {
+    call, ok := node.(*Call)
+    if !ok || call.Name != "integrate" {
+        value, err := Eval(node, env)
+        return value, 0, err
+    }
+    variable, err := specialForm(call)
+    if err != nil {
+        return 0, 0, err
+    }
+    from, err := Eval(call.Args[2], env)
+    if err != nil {
+        return 0, 0, err
+    }
+    to, err := Eval(call.Args[3], env)
+    if err != nil {
+        return 0, 0, err
+    }
+    return Integrate(call.Args[0], variable, env, from, to)
+}
+//_______________________________________________________________________________________________________________________________
+
+// bind возвращает функцию body(variable) при остальных переменных из env.
+func bind(body Node, variable string, env map[string]float64) func(float64) (float64, error) {
+    point := make(map[string]float64, len(env)+1)
+    for name, value := range env {
+        point[name] = value
+    }
+    return func(at float64) (float64, error) {
+        point[variable] = at
+        return Eval(body, point)
+    }
+}
+
+// Integrate вычисляет интеграл body по variable от a до b адаптивной квадратурой Гаусса–Кронрода:
+// отрезок с наибольшей оценкой погрешности делится пополам, пока суммарная оценка не станет
+// меньше допустимой. Концы отрезка не вычисляются, поэтому интегрируемые особенности на концах
+// (например, 1/sqrt(x) от 0) допустимы. Возвращает значение и оценку абсолютной погрешности.
+func Integrate(body Node, variable string, env map[string]float64, a, b float64) (float64, float64, error) {
+    if !isFinite(a) || !isFinite(b) {
+        return 0, 0, fmt.Errorf("[Ошибка] Пределы интегрирования должны быть конечными")
+    }
+    if a == b {
+        return 0, 0, nil
+    }
+    f := bind(body, variable, env)
+
+    type part struct{ a, b, value, error float64 }
+    first, err := gaussKronrod(f, a, b)
+    if err != nil {
+        return 0, 0, err
+    }
+    parts := []part{{a, b, first[0], first[1]}}
+    value, estimate := first[0], first[1]
+
+    for len(parts) < integrateMaxParts && estimate > integrateTolerance*math.Max(1, math.Abs(value)) {
+        // Делим отрезок с наибольшей погрешностью
+        worst := 0
+        for i := range parts {
+            if parts[i].error > parts[worst].error {
+                worst = i
+            }
+        }
+        p := parts[worst]
+        middle := (p.a + p.b) / 2
+        left, err := gaussKronrod(f, p.a, middle)
+        if err != nil {
+            return 0, 0, err
+        }
+        right, err := gaussKronrod(f, middle, p.b)
+        if err != nil {
+            return 0, 0, err
+        }
+        parts[worst] = part{p.a, middle, left[0], left[1]}
+        parts = append(parts, part{middle, p.b, right[0], right[1]})
+
+        value, estimate = 0, 0
+        for _, q := range parts {
+            value += q.value
+            estimate += q.error
+        }
+    }
+    if !isFinite(value) {
+        return 0, 0, fmt.Errorf("[Ошибка] Интеграл расходится")
+    }
+    return value, estimate, nil
+}
+
+// gaussKronrod вычисляет интеграл на [a, b] по 15 точкам Кронрода; погрешность — разница с 7-точечной формулой Гаусса.
+func gaussKronrod(f func(float64) (float64, error), a, b float64) ([2]float64, error) {
+    center, half := (a+b)/2, (b-a)/2
+    kronrod, gauss := 0.0, 0.0
+    for i, node := range kronrodNodes {
+        points := []float64{center - half*node, center + half*node}
+        if node == 0 {
+            points = points[:1]
+        }
+        for _, x := range points {
+            y, err := f(x)
+            if err != nil {
+                return [2]float64{}, err
+            }
+            kronrod += kronrodWeights[i] * y
+            if i%2 == 1 {
+                gauss += gaussWeights[i/2] * y
+            }
+        }
+    }
+    return [2]float64{kronrod * half, math.Abs((kronrod - gauss) * half)}, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// Sum вычисляет сумму body при variable = from, from+1, ..., to (пределы — целые числа).
+// Используется суммирование Кэхэна, чтобы погрешность не накапливалась на длинных суммах.
+func Sum(body Node, variable string, env map[string]float64, from, to float64) (float64, error) {
+    if from != math.Trunc(from) || to != math.Trunc(to) {
+        return 0, fmt.Errorf("[Ошибка] Пределы суммы должны быть целыми числами")
+    }
+    // Счётчик — целое число: у float64 за 2^53 i++ перестаёт менять i, и цикл не кончается
+    if math.Abs(from) > MaxSumBound || math.Abs(to) > MaxSumBound {
+        return 0, fmt.Errorf("[Ошибка] Пределы суммы должны быть не больше 2^53 по модулю")
+    }
+    first, last := int64(from), int64(to)
+    if last-first+1 > MaxSumTerms {
+        return 0, fmt.Errorf("[Ошибка] Слишком много слагаемых: %d (не больше %d)", last-first+1, MaxSumTerms)
+    }
+    f := bind(body, variable, env)
+
+    sum, compensation := 0.0, 0.0
+    for i := first; i <= last; i++ {
+        term, err := f(float64(i))
+        if err != nil {
+            return 0, err
+        }
+        y := term - compensation
+        t := sum + y
+        compensation = (t - sum) - y
+        sum = t
+    }
+    return checkResult("sum", sum)
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,68 @@
+package calculation
+
+// JSONNode — узел AST в виде, удобном для сериализации в JSON.
+type JSONNode struct {
+    Type     string      `json:"type"`               // "number", "ident", "unary", "binary", "call" или "array"
+    Operator string      `json:"operator,omitempty"` // оператор унарного или бинарного узла
+    Name     string      `json:"name,omitempty"`     // имя переменной или функции
+    Children []*JSONNode `json:"children,omitempty"` // операнды или аргументы функции
+    Span     Span        `json:"span"`
+    Value    *float64    `json:"value,omitempty"` // значение поддерева, если оно не зависит от переменных
+}
+
+// ToJSON преобразует AST в дерево JSONNode. Поддеревья без переменных сворачиваются в значение.
+// Значения считаются за один проход снизу вверх: узел вычисляется с уже свёрнутыми в числа потомками,
+// поэтому поддерево не вычисляется заново для каждого предка. integrate и sum (и их предки)
+// значений не получают: дерево строится в оркестраторе, а их вычисление может занять долгое время.
+func ToJSON(node Node) *JSONNode {
+    if node == nil {
+        return nil
+    }
+    result, _, _ := toJSON(node)
+    return result
+}
+
+// toJSON возвращает узел JSON, копию узла, в которой поддеревья с числовым значением заменены числами,
+// и признак того, что в узле есть integrate или sum.
+func toJSON(node Node) (*JSONNode, Node, bool) {
+    result := &JSONNode{Span: node.Pos()}
+    switch n := node.(type) {
+    case *NumberLit:
+        result.Type = "number"
+    case *Ident:
+        result.Type, result.Name = "ident", n.Name
+    case *UnaryOp:
+        result.Type, result.Operator = "unary", n.Operator
+    case *BinaryOp:
+        result.Type, result.Operator = "binary", n.Operator
+    case *Call:
+        result.Type, result.Name = "call", n.Name
+    case *ArrayLit:
+        result.Type = "array"
+    case *UserCall:
+        result.Type, result.Name = "call", n.Function.Name
+    }
+    call, special := node.(*Call)
+    special = special && IsSpecialForm(call)
+    children := Children(node)
+    if len(children) > 0 {
+        folded := make([]Node, len(children))
+        for i, child := range children {
+            jsonChild, foldedChild, specialChild := toJSON(child)
+            result.Children = append(result.Children, jsonChild)
+            folded[i] = foldedChild
+            special = special || specialChild
+        }
+        node = withChildren(node, folded)
+    }
+    if special {
+        return result, node, true
+    }
+    if value, err := Eval(node, nil); err == nil {
+        result.Value = &value
+        return result, &NumberLit{Span: node.Pos(), Value: value}, false
+    }
+    return result, node, false
+}
+
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,326 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+)
+
+// MatrixFunction — встроенная функция векторов и матриц.
+type MatrixFunction struct {
+    Args  int
+    Type  func(args []Kind) (Kind, bool) // тип результата по типам аргументов; false — аргументы не того типа
+    Apply func(args []Value) (Value, error)
+}
+
+// MatrixFunctions — функции векторов и матриц. Арифметика (+, -, *, /, ^) над векторами и матрицами —
+// поэлементная, с числом — для каждого элемента; матричное произведение — matmul.
+var MatrixFunctions = map[string]MatrixFunction{
+    "dot": {Args: 2, Apply: dot, Type: func(args []Kind) (Kind, bool) {
+        return KindNumber, args[0] == KindVector && args[1] == KindVector
+    }},
+    "matmul": {Args: 2, Apply: matmul, Type: func(args []Kind) (Kind, bool) {
+        switch {
+        case args[0] == KindMatrix && args[1] == KindMatrix:
+            return KindMatrix, true
+        case args[0] == KindMatrix && args[1] == KindVector, args[0] == KindVector && args[1] == KindMatrix:
+            return KindVector, true
+        }
+        return KindMatrix, false
+    }},
+    "transpose": {Args: 1, Apply: transpose, Type: matrixArgument(KindMatrix)},
+    "det":       {Args: 1, Apply: det, Type: matrixArgument(KindNumber)},
+    "inv":       {Args: 1, Apply: inv, Type: matrixArgument(KindMatrix)},
+}
+
+// matrixArgument — тип функции одной матрицы с результатом result.
+func matrixArgument(result Kind) func(args []Kind) (Kind, bool) {
+    return func(args []Kind) (Kind, bool) { return result, args[0] == KindMatrix }
+}
+//_______________________________________________________________________________________________________________________________
+
+// evalArray вычисляет литерал массива: числа образуют вектор, векторы одной длины — матрицу (по строкам).
+func evalArray(n *ArrayLit, env map[string]float64) (Value, error) {
+    if len(n.Elements) == 0 {
+        return Value{}, fmt.Errorf("[Ошибка] Пустой массив")
+    }
+    elements := make([]Value, len(n.Elements))
+    for i, element := range n.Elements {
+        var err error
+        if elements[i], err = EvalValue(element, env); err != nil {
+            return Value{}, err
+        }
+    }
+
+    switch elements[0].Kind {
+    case KindNumber:
+        vector := make([]float64, len(elements))
+        for i, element := range elements {
+            if element.Kind != KindNumber {
+                return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Элементы вектора должны быть числами: %s", Format(n))}
+            }
+            vector[i] = element.Number
+        }
+        return Value{Kind: KindVector, Vector: vector}, nil
+    case KindVector:
+        matrix := make([][]float64, len(elements))
+        for i, element := range elements {
+            if element.Kind != KindVector {
+                return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Строки матрицы должны быть векторами: %s", Format(n))}
+            }
+            if len(element.Vector) != len(elements[0].Vector) {
+                return Value{}, fmt.Errorf("[Ошибка] Строки матрицы разной длины: %d и %d", len(elements[0].Vector), len(element.Vector))
+            }
+            matrix[i] = element.Vector
+        }
+        return Value{Kind: KindMatrix, Matrix: matrix}, nil
+    }
+    return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Элементы массива должны быть числами или векторами: %s", Format(n))}
+}
+//_______________________________________________________________________________________________________________________________
+
+// mapValue применяет f к числу или к каждому элементу вектора или матрицы.
+func mapValue(value Value, f func(float64) (float64, error)) (Value, error) {
+    switch value.Kind {
+    case KindNumber:
+        result, err := f(value.Number)
+        return Value{Kind: KindNumber, Number: result}, err
+    case KindVector:
+        vector, err := mapVector(value.Vector, f)
+        return Value{Kind: KindVector, Vector: vector}, err
+    case KindMatrix:
+        matrix := make([][]float64, len(value.Matrix))
+        for i, row := range value.Matrix {
+            var err error
+            if matrix[i], err = mapVector(row, f); err != nil {
+                return Value{}, err
+            }
+        }
+        return Value{Kind: KindMatrix, Matrix: matrix}, nil
+    }
+    return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Ожидалось число, а не %s", value.Kind)}
+}
+
+func mapVector(vector []float64, f func(float64) (float64, error)) ([]float64, error) {
+    result := make([]float64, len(vector))
+    for i, x := range vector {
+        var err error
+        if result[i], err = f(x); err != nil {
+            return nil, err
+        }
+    }
+    return result, nil
+}
+
+// elementwise выполняет арифметическую операцию над числами, векторами и матрицами:
+// с числом — для каждого элемента, над массивами одного размера — поэлементно.
+func elementwise(operator string, left, right Value) (Value, error) {
+    if left.Kind == KindBoolean || right.Kind == KindBoolean {
+        return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Оператор %s не применим к логическим значениям", operator)}
+    }
+    switch {
+    case left.Kind == KindNumber && right.Kind == KindNumber:
+        result, err := arithmetic(operator, left.Number, right.Number)
+        return Value{Kind: KindNumber, Number: result}, err
+    case left.Kind == KindNumber:
+        return mapValue(right, func(x float64) (float64, error) { return arithmetic(operator, left.Number, x) })
+    case right.Kind == KindNumber:
+        return mapValue(left, func(x float64) (float64, error) { return arithmetic(operator, x, right.Number) })
+    case left.Kind != right.Kind:
+        return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Оператор %s не применим к значениям %s и %s", operator, left.Kind, right.Kind)}
+    }
+
+    if left.Shape() != right.Shape() {
+        return Value{}, fmt.Errorf("[Ошибка] Размеры не совпадают: %s %s %s", left.Shape(), operator, right.Shape())
+    }
+    if left.Kind == KindVector {
+        vector, err := zipVectors(operator, left.Vector, right.Vector)
+        return Value{Kind: KindVector, Vector: vector}, err
+    }
+    matrix := make([][]float64, len(left.Matrix))
+    for i := range left.Matrix {
+        var err error
+        if matrix[i], err = zipVectors(operator, left.Matrix[i], right.Matrix[i]); err != nil {
+            return Value{}, err
+        }
+    }
+    return Value{Kind: KindMatrix, Matrix: matrix}, nil
+}
+
+func zipVectors(operator string, left, right []float64) ([]float64, error) {
+    result := make([]float64, len(left))
+    for i := range left {
+        var err error
+        if result[i], err = arithmetic(operator, left[i], right[i]); err != nil {
+            return nil, err
+        }
+    }
+    return result, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// dot — скалярное произведение векторов одной длины.
+func dot(args []Value) (Value, error) {
+    u, v := args[0].Vector, args[1].Vector
+    if len(u) != len(v) {
+        return Value{}, fmt.Errorf("[Ошибка] Размеры не совпадают: dot(%s, %s)", args[0].Shape(), args[1].Shape())
+    }
+    result := 0.0
+    for i := range u {
+        result += u[i] * v[i]
+    }
+    result, err := checkResult("dot", result)
+    return Value{Kind: KindNumber, Number: result}, err
+}
+
+// matmul — матричное произведение. Вектор слева — строка, вектор справа — столбец; результат тогда — вектор.
+func matmul(args []Value) (Value, error) {
+    left, right := args[0], args[1]
+    a, b := left.Matrix, right.Matrix
+    if left.Kind == KindVector {
+        a = [][]float64{left.Vector}
+    }
+    if right.Kind == KindVector {
+        b = make([][]float64, len(right.Vector))
+        for i, x := range right.Vector {
+            b[i] = []float64{x}
+        }
+    }
+    if len(a[0]) != len(b) {
+        return Value{}, fmt.Errorf("[Ошибка] Размеры не совпадают: matmul(%s, %s)", left.Shape(), right.Shape())
+    }
+
+    product := make([][]float64, len(a))
+    for i := range a {
+        product[i] = make([]float64, len(b[0]))
+        for j := range b[0] {
+            sum := 0.0
+            for k := range b {
+                sum += a[i][k] * b[k][j]
+            }
+            var err error
+            if product[i][j], err = checkResult("matmul", sum); err != nil {
+                return Value{}, err
+            }
+        }
+    }
+
+    switch {
+    case left.Kind == KindVector:
+        return Value{Kind: KindVector, Vector: product[0]}, nil
+    case right.Kind == KindVector:
+        column := make([]float64, len(product))
+        for i, row := range product {
+            column[i] = row[0]
+        }
+        return Value{Kind: KindVector, Vector: column}, nil
+    }
+    return Value{Kind: KindMatrix, Matrix: product}, nil
+}
+
+// transpose — транспонированная матрица.
+func transpose(args []Value) (Value, error) {
+    a := args[0].Matrix
+    result := make([][]float64, len(a[0]))
+    for j := range result {
+        result[j] = make([]float64, len(a))
+        for i := range a {
+            result[j][i] = a[i][j]
+        }
+    }
+    return Value{Kind: KindMatrix, Matrix: result}, nil
+}
+
+// det — определитель квадратной матрицы (LU-разложение с выбором главного элемента).
+func det(args []Value) (Value, error) {
+    a, err := squareCopy("det", args[0])
+    if err != nil {
+        return Value{}, err
+    }
+    result := 1.0
+    for k := range a {
+        pivot := pivotRow(a, k)
+        if a[pivot][k] == 0 {
+            return Value{Kind: KindNumber, Number: 0}, nil
+        }
+        if pivot != k {
+            a[k], a[pivot] = a[pivot], a[k]
+            result = -result
+        }
+        result *= a[k][k]
+        for i := k + 1; i < len(a); i++ {
+            factor := a[i][k] / a[k][k]
+            for j := k; j < len(a); j++ {
+                a[i][j] -= factor * a[k][j]
+            }
+        }
+    }
+    result, err = checkResult("det", result)
+    return Value{Kind: KindNumber, Number: result}, err
+}
+
+// inv — обратная матрица (метод Гаусса–Жордана с выбором главного элемента).
+func inv(args []Value) (Value, error) {
+    a, err := squareCopy("inv", args[0])
+    if err != nil {
+        return Value{}, err
+    }
+    n := len(a)
+    result := make([][]float64, n)
+    for i := range result {
+        result[i] = make([]float64, n)
+        result[i][i] = 1
+    }
+
+    for k := 0; k < n; k++ {
+        pivot := pivotRow(a, k)
+        if math.Abs(a[pivot][k]) < singularTolerance {
+            return Value{}, fmt.Errorf("[Ошибка] Матрица вырождена: inv")
+        }
+        a[k], a[pivot] = a[pivot], a[k]
+        result[k], result[pivot] = result[pivot], result[k]
+
+        scale := a[k][k]
+        for j := 0; j < n; j++ {
+            a[k][j] /= scale
+            result[k][j] /= scale
+        }
+        for i := 0; i < n; i++ {
+            if i == k || a[i][k] == 0 {
+                continue
+            }
+            factor := a[i][k]
+            for j := 0; j < n; j++ {
+                a[i][j] -= factor * a[k][j]
+                result[i][j] -= factor * result[k][j]
+            }
+        }
+    }
+    return mapValue(Value{Kind: KindMatrix, Matrix: result}, func(x float64) (float64, error) { return checkResult("inv", x) })
+}
+
+// singularTolerance — главный элемент меньше этого значения считается нулём: матрица вырождена.
+const singularTolerance = 1e-12
+
+// squareCopy проверяет, что матрица квадратная, и возвращает её копию для разложения.
+func squareCopy(name string, value Value) ([][]float64, error) {
+    if len(value.Matrix) != len(value.Matrix[0]) {
+        return nil, fmt.Errorf("[Ошибка] Матрица должна быть квадратной: %s(%s)", name, value.Shape())
+    }
+    a := make([][]float64, len(value.Matrix))
+    for i, row := range value.Matrix {
+        a[i] = append([]float64(nil), row...)
+    }
+    return a, nil
+}
+
+// pivotRow возвращает строку с наибольшим по модулю элементом столбца k начиная со строки k.
+func pivotRow(a [][]float64, k int) int {
+    pivot := k
+    for i := k + 1; i < len(a); i++ {
+        if math.Abs(a[i][k]) > math.Abs(a[pivot][k]) {
+            pivot = i
+        }
+    }
+    return pivot
+}
+//_______________________________________________________________________________________________________________________________
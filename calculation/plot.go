@@ -0,0 +1,41 @@
+package calculation
+
+// Point — точка графика. Если выражение в точке не вычисляется (деление на ноль, выход из области
+// определения, NaN или бесконечность), значения Y нет, а Error содержит причину.
+type Point struct {
+    X     float64  `json:"x"`
+    Y     *float64 `json:"y"`
+    Error string   `json:"error,omitempty"`
+}
+//_______________________________________________________________________________________________________________________________
+
+// SampleX возвращает i-ю из samples равноотстоящих точек отрезка [min, max] (концы отрезка включены).
+func SampleX(min, max float64, i, samples int) float64 {
+    if samples < 2 || i == 0 {
+        return min
+    }
+    if i == samples-1 {
+        return max
+    }
+    return min + (max-min)*float64(i)/float64(samples-1)
+}
+//_______________________________________________________________________________________________________________________________
+
+// Sample вычисляет выражение node в samples равноотстоящих точках отрезка [min, max] по переменной variable.
+// Ошибка в одной точке не прерывает вычисление, а записывается в эту точку.
+func Sample(node Node, variable string, env map[string]float64, min, max float64, samples int) []Point {
+    f := bind(node, variable, env)
+    points := make([]Point, samples)
+    for i := range points {
+        x := SampleX(min, max, i, samples)
+        points[i].X = x
+        y, err := f(x)
+        if err != nil {
+            points[i].Error = err.Error()
+            continue
+        }
+        points[i].Y = &y
+    }
+    return points
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,420 @@
+package calculation
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Пределы вычисления программы: рекурсия f(n) = if(n <= 1, 1, n * f(n - 1)) без базового случая
+// не должна переполнить стек, а экспоненциальная — занять агента навсегда.
+const (
+    MaxCallDepth    = 256     // наибольшая глубина вложенных вызовов функций программы
+    MaxProgramCalls = 1000000 // наибольшее число вызовов функций программы за одно вычисление
+)
+
+// Statement — инструкция программы: выражение, присваивание "a = выражение"
+// или определение функции "f(x, y) = выражение".
+type Statement struct {
+    Name     string   // имя переменной или функции; пусто — выражение
+    Params   []string // параметры функции
+    Function bool     // определение функции (у f() = 1 параметров нет)
+    Value    Node     // значение или тело функции
+}
+
+// Program — инструкции через ";": "f(x) = x^2 + 1; a = f(3); a * 2". Значение программы —
+// значение последней инструкции.
+type Program struct {
+    Statements []Statement
+}
+
+// UserFunction — функция, определённая в программе. Тело видит параметры и значения переменных
+// на момент определения (замыкание): в "a = 2; f(x) = a * x; a = 3; f(1)" результат — 2.
+type UserFunction struct {
+    Name   string
+    Params []string
+    Body   Node // тело, в котором вызовы функций программы заменены на UserCall
+    Kind   Kind // тип значения функции
+
+    env   map[string]float64 // числа на момент определения
+    text  string             // запись определения "f(x) = x ^ 2 + 1"
+    state *programState
+}
+
+// programState — счётчики вызовов одного вычисления программы.
+type programState struct {
+    depth int
+    calls int
+}
+
+// UserCall — вызов функции программы. Появляется в дереве при разборе программы вместо Call,
+// поэтому работает и внутри integrate и sum.
+type UserCall struct {
+    Span
+    Function *UserFunction
+    Args     []Node
+}
+//_______________________________________________________________________________________________________________________________
+
+// ParseProgram разбирает инструкции, разделённые ";". Слева от "=" — имя переменной
+// или функции с именами параметров. Ошибки разбора возвращаются как error.
+func ParseProgram(input string) (program *Program, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            program = nil
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+
+    tokens := Tokenize(input)
+    parser := Parser{Tokens: tokens}
+    program = &Program{}
+    for parser.pos < len(parser.Tokens) {
+        if parser.Current().Type == TokenSemicolon { // пустая инструкция, например ";" в конце
+            parser.Eat(TokenSemicolon)
+            continue
+        }
+        program.Statements = append(program.Statements, parser.parseStatement())
+        if token := parser.Current(); token.Type != TokenSemicolon && token.Type != -1 {
+            return nil, fmt.Errorf("[Ошибка] Неожиданный токен %q (позиция %d)", token.Value, token.Pos)
+        }
+    }
+    if len(program.Statements) == 0 {
+        return nil, fmt.Errorf("[Ошибка] Пустое выражение")
+    }
+    return program, nil
+}
+
+// parseStatement разбирает одну инструкцию программы.
+func (p *Parser) parseStatement() Statement {
+    value := p.ParseExpression()
+    if p.Current().Type != TokenEquals {
+        return Statement{Value: value}
+    }
+    equals := p.Eat(TokenEquals)
+    body := p.ParseExpression()
+
+    switch target := value.(type) {
+    case *Ident:
+        return Statement{Name: target.Name, Value: body}
+    case *Call:
+        statement := Statement{Name: target.Name, Function: true, Value: body}
+        for _, arg := range target.Args {
+            param, ok := arg.(*Ident)
+            if !ok {
+                panic(fmt.Sprintf("[Ошибка] Параметр функции %s должен быть именем: %s", target.Name, Format(arg)))
+            }
+            for _, name := range statement.Params {
+                if name == param.Name {
+                    panic(fmt.Sprintf("[Ошибка] Повторяющийся параметр %s функции %s", name, target.Name))
+                }
+            }
+            statement.Params = append(statement.Params, param.Name)
+        }
+        return statement
+    }
+    panic(fmt.Sprintf("[Ошибка] Слева от = должно быть имя переменной или функции (позиция %d)", equals.Pos))
+}
+
+// IsProgram сообщает, что запись — программа: инструкции разделены ";". Без ";" запись — выражение
+// или уравнение, и "x = 1" остаётся ошибкой (знак "=" вне уравнения).
+func IsProgram(input string) bool {
+    if !strings.Contains(input, ";") {
+        return false
+    }
+    _, err := ParseProgram(input)
+    return err == nil
+}
+
+// String записывает программу в нормализованном виде (как PrintAST): одинаковые по смыслу программы
+// дают одну запись — ключ кеша результатов.
+func (p *Program) String() string {
+    statements := make([]string, len(p.Statements))
+    for i, statement := range p.Statements {
+        switch {
+        case statement.Function:
+            statements[i] = fmt.Sprintf("%s(%s) = %s", statement.Name, strings.Join(statement.Params, ", "), PrintAST(statement.Value))
+        case statement.Name != "":
+            statements[i] = statement.Name + " = " + PrintAST(statement.Value)
+        default:
+            statements[i] = PrintAST(statement.Value)
+        }
+    }
+    return strings.Join(statements, "; ")
+}
+//_______________________________________________________________________________________________________________________________
+
+// CheckProgram проверяет программу без вычисления: переменные и функции определены до использования,
+// типы операндов верны. env — числа, lists — списки из variables. Возвращает тип значения программы.
+func CheckProgram(program *Program, env map[string]float64, lists map[string][]float64) (Kind, error) {
+    value, _, err := runProgram(program, env, lists, false)
+    return value.Kind, err
+}
+
+// EvalProgram вычисляет программу. Возвращает значение последней инструкции и итоговые значения
+// имён, определённых программой: переменных (числа, векторы, матрицы) и функций (запись "f(x) = x ^ 2").
+func EvalProgram(program *Program, env map[string]float64, lists map[string][]float64) (Value, map[string]interface{}, error) {
+    return runProgram(program, env, lists, true)
+}
+
+// programScope — имена, известные на очередной инструкции программы.
+type programScope struct {
+    numbers   map[string]float64
+    arrays    map[string]Value
+    functions map[string]*UserFunction
+    state     *programState
+}
+
+// runProgram выполняет инструкции по порядку. Без evaluate значения не вычисляются:
+// переменным присваиваются заглушки нужного типа, чтобы проверить следующие инструкции.
+func runProgram(program *Program, env map[string]float64, lists map[string][]float64, evaluate bool) (Value, map[string]interface{}, error) {
+    scope := &programScope{
+        numbers:   make(map[string]float64, len(env)),
+        arrays:    make(map[string]Value, len(lists)),
+        functions: make(map[string]*UserFunction),
+        state:     &programState{},
+    }
+    for name, value := range env {
+        scope.numbers[name] = value
+    }
+    for name, values := range lists {
+        scope.arrays[name] = Value{Kind: KindVector, Vector: values}
+    }
+
+    var value Value
+    assigned := make(map[string]bool)
+    for i, statement := range program.Statements {
+        if statement.Function {
+            if i == len(program.Statements)-1 {
+                return Value{}, nil, fmt.Errorf("[Ошибка] Программа должна заканчиваться выражением или присваиванием")
+            }
+            if err := scope.define(statement); err != nil {
+                return Value{}, nil, err
+            }
+            assigned[statement.Name] = true
+            continue
+        }
+
+        node := scope.resolve(statement.Value, nil)
+        if err := Validate(node, scope.numbers); err != nil {
+            return Value{}, nil, err
+        }
+        kind, err := TypeOf(node)
+        if err != nil {
+            return Value{}, nil, err
+        }
+        if evaluate {
+            if value, err = EvalValue(node, scope.numbers); err != nil {
+                return Value{}, nil, err
+            }
+        } else {
+            value = placeholder(kind)
+        }
+        if statement.Name != "" {
+            if err := scope.assign(statement.Name, value); err != nil {
+                return Value{}, nil, err
+            }
+            assigned[statement.Name] = true
+        }
+    }
+    return value, scope.bindings(assigned), nil
+}
+
+// define определяет функцию программы. Функция видна в своём теле (рекурсия) и в следующих инструкциях.
+func (s *programScope) define(statement Statement) error {
+    _, builtin := Functions[statement.Name]
+    if _, matrix := MatrixFunctions[statement.Name]; matrix || builtin || SpecialForms[statement.Name] || statement.Name == "if" {
+        return fmt.Errorf("[Ошибка] Нельзя переопределить встроенную функцию %s", statement.Name)
+    }
+    function := &UserFunction{
+        Name:   statement.Name,
+        Params: statement.Params,
+        Kind:   KindNumber, // рекурсивный вызов в собственном теле считается числом
+        env:    make(map[string]float64, len(s.numbers)),
+        text:   fmt.Sprintf("%s(%s) = %s", statement.Name, strings.Join(statement.Params, ", "), Format(statement.Value)),
+        state:  s.state,
+    }
+    for name, value := range s.numbers {
+        function.env[name] = value
+    }
+    s.functions[statement.Name] = function
+
+    // Параметры — числа; они перекрывают переменные с теми же именами
+    params := make(map[string]bool, len(statement.Params))
+    local := make(map[string]float64, len(function.env)+len(statement.Params))
+    for name, value := range function.env {
+        local[name] = value
+    }
+    for _, name := range statement.Params {
+        params[name] = true
+        local[name] = 0
+    }
+    function.Body = s.resolve(statement.Value, params)
+    if err := Validate(function.Body, local); err != nil {
+        return err
+    }
+    kind, err := TypeOf(function.Body)
+    function.Kind = kind
+    return err
+}
+
+// assign присваивает значение переменной программы.
+func (s *programScope) assign(name string, value Value) error {
+    switch value.Kind {
+    case KindNumber:
+        s.numbers[name] = value.Number
+        delete(s.arrays, name)
+    case KindVector, KindMatrix:
+        s.arrays[name] = value
+        delete(s.numbers, name)
+    default:
+        return &TypeError{fmt.Sprintf("[Ошибка] Переменной %s можно присвоить число, вектор или матрицу, а не %s", name, value.Kind)}
+    }
+    return nil
+}
+
+// resolve подставляет в выражение векторы и матрицы из переменных программы и заменяет вызовы
+// функций программы на UserCall. params — параметры функции, они перекрывают переменные.
+func (s *programScope) resolve(node Node, params map[string]bool) Node {
+    return rewrite(node, params, func(node Node, bound map[string]bool) Node {
+        switch n := node.(type) {
+        case *Ident:
+            if value, ok := s.arrays[n.Name]; ok && !bound[n.Name] {
+                return valueNode(value, n.Span)
+            }
+        case *Call:
+            if function, ok := s.functions[n.Name]; ok {
+                return &UserCall{Span: n.Span, Function: function, Args: n.Args}
+            }
+        }
+        return node
+    })
+}
+
+// bindings возвращает итоговые значения имён, определённых программой.
+func (s *programScope) bindings(names map[string]bool) map[string]interface{} {
+    result := make(map[string]interface{}, len(names))
+    for name := range names {
+        if value, ok := s.numbers[name]; ok {
+            result[name] = value
+        } else if value, ok := s.arrays[name]; ok {
+            result[name] = value.Interface()
+        } else if function, ok := s.functions[name]; ok {
+            result[name] = function.text
+        }
+    }
+    return result
+}
+//_______________________________________________________________________________________________________________________________
+
+// call вычисляет вызов функции программы: аргументы — в окружении вызова, тело — в окружении определения.
+func (f *UserFunction) call(n *UserCall, env map[string]float64) (Value, error) {
+    if len(n.Args) != len(f.Params) {
+        return Value{}, fmt.Errorf("[Ошибка] Неверное число аргументов функции %s: %d", f.Name, len(n.Args))
+    }
+    local := make(map[string]float64, len(f.env)+len(f.Params))
+    for name, value := range f.env {
+        local[name] = value
+    }
+    for i, arg := range n.Args {
+        value, err := EvalValue(arg, env)
+        if err != nil {
+            return Value{}, err
+        }
+        if value.Kind != KindNumber {
+            return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Аргумент функции %s должен быть числом, а не %s", f.Name, value.Kind)}
+        }
+        local[f.Params[i]] = value.Number
+    }
+
+    if f.state.depth >= MaxCallDepth {
+        return Value{}, fmt.Errorf("[Ошибка] Превышена глубина рекурсии (%d): %s", MaxCallDepth, f.Name)
+    }
+    if f.state.calls >= MaxProgramCalls {
+        return Value{}, fmt.Errorf("[Ошибка] Слишком много вызовов функций (больше %d): %s", MaxProgramCalls, f.Name)
+    }
+    f.state.depth++
+    f.state.calls++
+    defer func() { f.state.depth-- }()
+    return EvalValue(f.Body, local)
+}
+//_______________________________________________________________________________________________________________________________
+
+// rewrite копирует дерево, заменяя узлы функцией replace. replace получает узел с уже переписанными
+// потомками и имена, связанные в нём (bound и переменные integrate и sum).
+func rewrite(node Node, bound map[string]bool, replace func(node Node, bound map[string]bool) Node) Node {
+    switch n := node.(type) {
+    case *UnaryOp:
+        node = &UnaryOp{Span: n.Span, Operator: n.Operator, Operand: rewrite(n.Operand, bound, replace)}
+    case *BinaryOp:
+        node = &BinaryOp{Span: n.Span, Operator: n.Operator, Left: rewrite(n.Left, bound, replace), Right: rewrite(n.Right, bound, replace)}
+    case *ArrayLit:
+        array := &ArrayLit{Span: n.Span}
+        for _, element := range n.Elements {
+            array.Elements = append(array.Elements, rewrite(element, bound, replace))
+        }
+        node = array
+    case *UserCall:
+        call := &UserCall{Span: n.Span, Function: n.Function}
+        for _, arg := range n.Args {
+            call.Args = append(call.Args, rewrite(arg, bound, replace))
+        }
+        node = call
+    case *Call:
+        args := make([]Node, len(n.Args))
+        copy(args, n.Args)
+        // Имя во втором аргументе sum заменяется до связывания: если replace даёт ему значение (список
+        // или массив программы), проверка отклонит неоднозначную запись, а не скроет значение счётчиком
+        if variable, ok := sumVariable(n); ok && !bound[variable.Name] {
+            args[1] = rewrite(variable, bound, replace)
+        }
+        call := &Call{Span: n.Span, Name: n.Name, Args: args}
+        // Выражение и связанная переменная integrate и sum видят её, пределы — нет
+        inner := bound
+        if variable, err := specialForm(call); err == nil && IsSpecialForm(call) {
+            inner = map[string]bool{variable: true}
+            for name := range bound {
+                inner[name] = true
+            }
+        }
+        for i, arg := range args {
+            if i < 2 {
+                args[i] = rewrite(arg, inner, replace)
+            } else {
+                args[i] = rewrite(arg, bound, replace)
+            }
+        }
+        node = call
+    }
+    return replace(node, bound)
+}
+
+// valueNode записывает вектор или матрицу литералом.
+func valueNode(value Value, span Span) Node {
+    row := func(values []float64) *ArrayLit {
+        array := &ArrayLit{Span: span}
+        for _, x := range values {
+            array.Elements = append(array.Elements, &NumberLit{Span: span, Value: x})
+        }
+        return array
+    }
+    if value.Kind == KindVector {
+        return row(value.Vector)
+    }
+    matrix := &ArrayLit{Span: span}
+    for _, values := range value.Matrix {
+        matrix.Elements = append(matrix.Elements, row(values))
+    }
+    return matrix
+}
+
+// placeholder — значение типа kind для проверки программы без вычисления.
+func placeholder(kind Kind) Value {
+    switch kind {
+    case KindVector:
+        return Value{Kind: KindVector, Vector: []float64{0}}
+    case KindMatrix:
+        return Value{Kind: KindMatrix, Matrix: [][]float64{{0}}}
+    }
+    return Value{Kind: kind}
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,134 @@
+package calculation
+
+import (
+    "sort"
+)
+
+// Simplify возвращает упрощённое дерево: сворачивает константы, применяет тождества
+// x+0 = x, x*1 = x, x-0 = x, x-x = 0, x/1 = x, x^1 = x, x^0 = 1, -(-x) = x и приводит
+// коммутативные операции к канонической форме: одинаковые по смыслу выражения
+// ("y + x + 1" и "1 + (x + y)") дают одно и то же дерево.
+//
+// Поддеревья, вычисление которых даёт ошибку (например, деление на ноль), не сворачиваются.
+// Константы pi и e не подставляются, потому что переменные могут их перекрывать.
+func Simplify(node Node) Node {
+    switch n := node.(type) {
+    case *UnaryOp:
+        operand := Simplify(n.Operand)
+        if number, ok := operand.(*NumberLit); ok {
+            return &NumberLit{Span: n.Span, Value: -number.Value}
+        }
+        if inner, ok := operand.(*UnaryOp); ok && inner.Operator == "-" {
+            return inner.Operand
+        }
+        return &UnaryOp{Span: n.Span, Operator: n.Operator, Operand: operand}
+
+    case *Call:
+        call := &Call{Span: n.Span, Name: n.Name}
+        for _, arg := range n.Args {
+            call.Args = append(call.Args, Simplify(arg))
+        }
+        return foldConstant(call)
+
+    case *BinaryOp:
+        left, right := Simplify(n.Left), Simplify(n.Right)
+        switch n.Operator {
+        case "+", "*":
+            return canonicalChain(n.Span, n.Operator, []Node{left, right})
+        case "-":
+            if isNumber(right, 0) {
+                return left
+            }
+            if PrintAST(left) == PrintAST(right) {
+                return &NumberLit{Span: n.Span, Value: 0}
+            }
+            if isNumber(left, 0) {
+                return Simplify(&UnaryOp{Span: n.Span, Operator: "-", Operand: right})
+            }
+        case "/":
+            if isNumber(right, 1) {
+                return left
+            }
+        case "^":
+            if isNumber(right, 1) {
+                return left
+            }
+            if isNumber(right, 0) {
+                return &NumberLit{Span: n.Span, Value: 1}
+            }
+        }
+        return foldConstant(&BinaryOp{Span: n.Span, Operator: n.Operator, Left: left, Right: right})
+    }
+    return node
+}
+//_______________________________________________________________________________________________________________________________
+
+// canonicalChain собирает цепочку одинаковых коммутативных операций (a + b + c),
+// сворачивает числа в одну константу, убирает нейтральный элемент и упорядочивает
+// остальные операнды по их записи. Константа суммы ставится в конец (x + 1), множитель — в начало (2 * x).
+func canonicalChain(span Span, operator string, operands []Node) Node {
+    identity := 0.0
+    if operator == "*" {
+        identity = 1
+    }
+
+    constant := identity
+    var terms []Node
+    var collect func(node Node)
+    collect = func(node Node) {
+        if chain, ok := node.(*BinaryOp); ok && chain.Operator == operator {
+            collect(chain.Left)
+            collect(chain.Right)
+            return
+        }
+        if number, ok := node.(*NumberLit); ok {
+            if operator == "+" {
+                constant += number.Value
+            } else {
+                constant *= number.Value
+            }
+            return
+        }
+        terms = append(terms, node)
+    }
+    for _, operand := range operands {
+        collect(operand)
+    }
+
+    sort.SliceStable(terms, func(i, j int) bool { return PrintAST(terms[i]) < PrintAST(terms[j]) })
+    if constant != identity || len(terms) == 0 {
+        number := &NumberLit{Span: span, Value: constant}
+        if operator == "+" {
+            terms = append(terms, number)
+        } else {
+            terms = append([]Node{number}, terms...)
+        }
+    }
+
+    result := terms[0]
+    for _, term := range terms[1:] {
+        result = &BinaryOp{Span: span, Operator: operator, Left: result, Right: term}
+    }
+    return result
+}
+
+// foldConstant заменяет узел числом, если все его операнды — числа и вычисление прошло без ошибок.
+func foldConstant(node Node) Node {
+    for _, child := range Children(node) {
+        if _, ok := child.(*NumberLit); !ok {
+            return node
+        }
+    }
+    value, err := Eval(node, nil)
+    if err != nil {
+        return node
+    }
+    return &NumberLit{Span: node.Pos(), Value: value}
+}
+
+// isNumber сообщает, что узел — число value.
+func isNumber(node Node, value float64) bool {
+    number, ok := node.(*NumberLit)
+    return ok && number.Value == value
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,313 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+    "sort"
+)
+
+// Equation — уравнение Left = Right.
+type Equation struct {
+    Left  Node
+    Right Node
+}
+
+// Диапазон поиска корней по умолчанию для уравнений, которые не решаются в замкнутой форме.
+const (
+    DefaultSolveMin = -100.0
+    DefaultSolveMax = 100.0
+)
+
+const (
+    solveSamples        = 2000  // число отрезков, на которые делится диапазон при поиске смены знака
+    newtonMaxIterations = 100
+    rootTolerance       = 1e-9  // |f(x)|, при котором x считается корнем
+    polynomialZero      = 1e-14 // относительная величина коэффициента, который считается нулевым
+)
+//_______________________________________________________________________________________________________________________________
+
+// ParseEquation разбирает уравнение "выражение = выражение". Без "=" правая часть равна нулю: f(x) = 0.
+func ParseEquation(input string) (eq *Equation, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            eq = nil
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+
+    tokens := Tokenize(input)
+    if len(tokens) == 0 {
+        return nil, fmt.Errorf("[Ошибка] Пустое выражение")
+    }
+
+    parser := Parser{Tokens: tokens}
+    eq = &Equation{Left: parser.ParseExpression()}
+    if parser.Current().Type == TokenEquals {
+        parser.Eat(TokenEquals)
+        eq.Right = parser.ParseExpression()
+    } else {
+        eq.Right = &NumberLit{Span: Span{len(input), len(input)}}
+    }
+    if parser.pos < len(parser.Tokens) {
+        return nil, fmt.Errorf("[Ошибка] Неожиданный токен %q (позиция %d)", parser.Current().Value, parser.Current().Pos)
+    }
+    return eq, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// Solve находит действительные корни уравнения по переменной variable; env — значения остальных переменных.
+// Линейные и квадратные уравнения решаются в замкнутой форме (все корни). Остальные — численно:
+// на [min, max] ищутся смены знака и касания нуля, затем корни уточняются методом Ньютона
+// с символьной производной (или конечной разностью), не выходя за найденный отрезок.
+// Если решений нет, возвращается пустой список.
+func Solve(eq *Equation, variable string, env map[string]float64, min, max float64) ([]float64, error) {
+    f := &BinaryOp{Operator: "-", Left: eq.Left, Right: eq.Right}
+
+    if coefficients, ok := polynomial(f, variable, env); ok {
+        return solvePolynomial(coefficients, variable)
+    }
+    if !(min < max) {
+        return nil, fmt.Errorf("[Ошибка] Некорректный диапазон поиска корней [%v, %v]", min, max)
+    }
+    return solveNumeric(f, variable, env, min, max), nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// solvePolynomial решает c[0] + c[1]*x + c[2]*x^2 = 0.
+func solvePolynomial(c []float64, variable string) ([]float64, error) {
+    // Почти нулевые старшие коэффициенты (погрешность округления) отбрасываем
+    scale := 0.0
+    for _, coefficient := range c {
+        scale = math.Max(scale, math.Abs(coefficient))
+    }
+    for len(c) > 0 && math.Abs(c[len(c)-1]) <= polynomialZero*scale {
+        c = c[:len(c)-1]
+    }
+
+    switch len(c) {
+    case 0:
+        return nil, fmt.Errorf("[Ошибка] Уравнение верно при любом значении %s", variable)
+    case 1:
+        return []float64{}, nil // ненулевая константа равна нулю — решений нет
+    case 2:
+        return []float64{-c[0] / c[1]}, nil
+    }
+
+    a, b, cc := c[2], c[1], c[0]
+    discriminant := b*b - 4*a*cc
+    switch {
+    case discriminant < 0:
+        return []float64{}, nil
+    case discriminant == 0:
+        return []float64{-b / (2 * a)}, nil
+    }
+    // Устойчивая формула: без вычитания близких чисел
+    q := -(b + math.Copysign(math.Sqrt(discriminant), b)) / 2
+    roots := []float64{q / a, cc / q}
+    sort.Float64s(roots)
+    return roots, nil
+}
+
+// polynomial возвращает коэффициенты выражения как многочлена степени не выше 2 от x
+// или false, если выражение не многочлен (или степень больше двух).
+func polynomial(node Node, x string, env map[string]float64) ([]float64, bool) {
+    if !dependsOn(node, x) {
+        value, err := Eval(node, env)
+        return []float64{value}, err == nil
+    }
+
+    switch n := node.(type) {
+    case *Ident:
+        return []float64{0, 1}, true
+    case *UnaryOp:
+        operand, ok := polynomial(n.Operand, x, env)
+        return scalePolynomial(operand, -1), ok
+    case *BinaryOp:
+        left, ok := polynomial(n.Left, x, env)
+        if !ok {
+            return nil, false
+        }
+        if n.Operator == "^" {
+            exponent, err := Eval(n.Right, env)
+            if dependsOn(n.Right, x) || err != nil || exponent != math.Trunc(exponent) || exponent < 0 || exponent > 2 {
+                return nil, false
+            }
+            result := []float64{1}
+            for i := 0; i < int(exponent) && ok; i++ {
+                result, ok = multiplyPolynomials(result, left)
+            }
+            return result, ok
+        }
+        right, ok := polynomial(n.Right, x, env)
+        if !ok {
+            return nil, false
+        }
+        switch n.Operator {
+        case "+":
+            return addPolynomials(left, right, 1), true
+        case "-":
+            return addPolynomials(left, right, -1), true
+        case "*":
+            return multiplyPolynomials(left, right)
+        case "/":
+            if len(right) != 1 || right[0] == 0 {
+                return nil, false
+            }
+            return scalePolynomial(left, 1/right[0]), true
+        }
+    }
+    return nil, false
+}
+
+func addPolynomials(a, b []float64, sign float64) []float64 {
+    result := make([]float64, len(a))
+    copy(result, a)
+    for len(result) < len(b) {
+        result = append(result, 0)
+    }
+    for i, coefficient := range b {
+        result[i] += sign * coefficient
+    }
+    return result
+}
+
+func multiplyPolynomials(a, b []float64) ([]float64, bool) {
+    if len(a)+len(b)-1 > 3 {
+        return nil, false
+    }
+    result := make([]float64, len(a)+len(b)-1)
+    for i, p := range a {
+        for j, q := range b {
+            result[i+j] += p * q
+        }
+    }
+    return result, true
+}
+
+func scalePolynomial(a []float64, factor float64) []float64 {
+    result := make([]float64, len(a))
+    for i, coefficient := range a {
+        result[i] = coefficient * factor
+    }
+    return result
+}
+//_______________________________________________________________________________________________________________________________
+
+// solveNumeric ищет корни f(x) = 0 на [min, max].
+func solveNumeric(f *BinaryOp, x string, env map[string]float64, min, max float64) []float64 {
+    point := make(map[string]float64, len(env)+1)
+    for name, value := range env {
+        point[name] = value
+    }
+    evaluate := func(node Node, at float64) float64 {
+        point[x] = at
+        value, err := Eval(node, point)
+        if err != nil {
+            return math.NaN() // вне области определения
+        }
+        return value
+    }
+
+    // Производная: символьная, если её удаётся построить, иначе центральная разность
+    derivative := func(at float64) float64 {
+        h := 1e-7 * math.Max(1, math.Abs(at))
+        return (evaluate(f, at+h) - evaluate(f, at-h)) / (2 * h)
+    }
+    if symbolic, err := Derive(f, x); err == nil {
+        derivative = func(at float64) float64 { return evaluate(symbolic, at) }
+    }
+
+    xs := make([]float64, solveSamples+1)
+    ys := make([]float64, solveSamples+1)
+    for i := range xs {
+        xs[i] = min + (max-min)*float64(i)/solveSamples
+        ys[i] = evaluate(f, xs[i])
+    }
+
+    // Корнем считается точка, где левая и правая части совпадают с относительной точностью rootTolerance.
+    // У полюса (1/x = 0) части несоизмеримы, и он отбрасывается
+    var roots []float64
+    accept := func(root float64) {
+        scale := math.Max(1, math.Max(math.Abs(evaluate(f.Left, root)), math.Abs(evaluate(f.Right, root))))
+        if root >= min && root <= max && math.Abs(evaluate(f, root)) <= rootTolerance*scale {
+            roots = append(roots, root)
+        }
+    }
+    for i := range xs {
+        switch {
+        case ys[i] == 0:
+            accept(xs[i])
+        case i > 0 && isFinite(ys[i-1]) && isFinite(ys[i]) && ys[i-1]*ys[i] < 0:
+            // Смена знака: корень (или полюс — его отбросит проверка в accept)
+            accept(newtonBracketed(func(at float64) float64 { return evaluate(f, at) }, derivative, xs[i-1], xs[i], ys[i-1]))
+        case i > 0 && i < solveSamples && isFinite(ys[i]) &&
+            math.Abs(ys[i]) < math.Abs(ys[i-1]) && math.Abs(ys[i]) <= math.Abs(ys[i+1]) && ys[i-1]*ys[i+1] > 0:
+            // Локальный минимум |f| без смены знака: возможно касание нуля (кратный корень)
+            if root, ok := newton(func(at float64) float64 { return evaluate(f, at) }, derivative, xs[i]); ok {
+                accept(root)
+            }
+        }
+    }
+    return uniqueRoots(roots)
+}
+
+// newtonBracketed уточняет корень на отрезке [a, b] со сменой знака: шаг Ньютона,
+// а если он выходит за отрезок — деление пополам. fa — значение f(a).
+func newtonBracketed(f, df func(float64) float64, a, b, fa float64) float64 {
+    x := (a + b) / 2
+    for i := 0; i < newtonMaxIterations; i++ {
+        fx := f(x)
+        if fx == 0 || b-a <= 1e-15*math.Max(1, math.Abs(x)) {
+            return x
+        }
+        // Сужаем отрезок, сохраняя смену знака
+        if (fx < 0) == (fa < 0) {
+            a, fa = x, fx
+        } else {
+            b = x
+        }
+        next := x - fx/df(x)
+        if !(next > a && next < b) {
+            next = (a + b) / 2
+        }
+        x = next
+    }
+    return x
+}
+
+// newton — метод Ньютона без отрезка, для кратных корней без смены знака.
+func newton(f, df func(float64) float64, x float64) (float64, bool) {
+    for i := 0; i < newtonMaxIterations; i++ {
+        fx, dfx := f(x), df(x)
+        if fx == 0 {
+            return x, true
+        }
+        if dfx == 0 || !isFinite(dfx) || !isFinite(fx) {
+            break
+        }
+        next := x - fx/dfx
+        if math.Abs(next-x) <= 1e-15*math.Max(1, math.Abs(x)) {
+            return next, true
+        }
+        x = next
+    }
+    return x, isFinite(x)
+}
+
+// uniqueRoots сортирует корни и объединяет совпадающие с точностью до погрешности.
+func uniqueRoots(roots []float64) []float64 {
+    sort.Float64s(roots)
+    unique := []float64{}
+    for _, root := range roots {
+        if len(unique) > 0 && math.Abs(root-unique[len(unique)-1]) <= 1e-7*math.Max(1, math.Abs(root)) {
+            continue
+        }
+        unique = append(unique, root)
+    }
+    return unique
+}
+
+func isFinite(value float64) bool {
+    return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,130 @@
+package calculation
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// Статистические функции. Как и min и max, они принимают любое число аргументов, а аргумент-список (вектор)
+// разворачивается в свои элементы: mean([1, 2], 3) = mean(1, 2, 3). Результат вне области определения
+// (дисперсия одного значения, процентиль вне [0, 100]) — NaN, его отклоняет checkResult.
+
+// sumValues складывает значения с компенсацией ошибок округления (суммирование Кэхэна, как в Sum).
+func sumValues(values []float64) float64 {
+    sum, compensation := 0.0, 0.0
+    for _, value := range values {
+        y := value - compensation
+        t := sum + y
+        compensation = (t - sum) - y
+        sum = t
+    }
+    return sum
+}
+
+func mean(values []float64) float64 {
+    return sumValues(values) / float64(len(values))
+}
+
+// variance — выборочная дисперсия (делитель n - 1).
+func variance(values []float64) float64 {
+    if len(values) < 2 {
+        return math.NaN()
+    }
+    average := mean(values)
+    deviations := make([]float64, len(values))
+    for i, value := range values {
+        deviations[i] = (value - average) * (value - average)
+    }
+    return sumValues(deviations) / float64(len(values)-1)
+}
+
+func median(values []float64) float64 {
+    return percentile(append(append([]float64(nil), values...), 50))
+}
+
+// percentile — процентиль p (последний аргумент, от 0 до 100) с линейной интерполяцией между
+// соседними по порядку значениями: percentile([1, 2, 3, 4], 50) = 2.5.
+func percentile(args []float64) float64 {
+    p := args[len(args)-1]
+    if p < 0 || p > 100 {
+        return math.NaN()
+    }
+    sorted := append([]float64(nil), args[:len(args)-1]...)
+    sort.Float64s(sorted)
+
+    rank := p / 100 * float64(len(sorted)-1)
+    lower := int(math.Floor(rank))
+    if lower+1 >= len(sorted) {
+        return sorted[lower]
+    }
+    return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+//_______________________________________________________________________________________________________________________________
+
+// BindLists подставляет в выражение значения переменных-списков: переменная data со значением [1, 2, 3]
+// заменяется литералом вектора. Так большой набор данных передаётся в variables, а не в тексте выражения.
+// Связанная переменная integrate перекрывает список с тем же именем. Список с именем счётчика sum
+// подставляется во второй аргумент, и проверка отклоняет такую запись (см. rewrite).
+func BindLists(node Node, lists map[string][]float64) Node {
+    if len(lists) == 0 {
+        return node
+    }
+    return rewrite(node, nil, func(node Node, bound map[string]bool) Node {
+        if ident, ok := node.(*Ident); ok && !bound[ident.Name] {
+            if values, ok := lists[ident.Name]; ok {
+                return valueNode(Value{Kind: KindVector, Vector: values}, ident.Span)
+            }
+        }
+        return node
+    })
+}
+//_______________________________________________________________________________________________________________________________
+
+// SplitVariables разделяет значения переменных из JSON ({"x": 2, "data": [1, 2, 3]}) на числа и списки чисел.
+func SplitVariables(raw map[string]json.RawMessage) (map[string]float64, map[string][]float64, error) {
+    var numbers map[string]float64
+    var lists map[string][]float64
+    for name, value := range raw {
+        if len(value) > 0 && value[0] == '[' {
+            var list []float64
+            if err := json.Unmarshal(value, &list); err != nil {
+                return nil, nil, fmt.Errorf("значение %s должно быть числом или списком чисел", name)
+            }
+            if len(list) == 0 {
+                return nil, nil, fmt.Errorf("список %s пуст", name)
+            }
+            if lists == nil {
+                lists = make(map[string][]float64)
+            }
+            lists[name] = list
+            continue
+        }
+        var number float64
+        if err := json.Unmarshal(value, &number); err != nil {
+            return nil, nil, fmt.Errorf("значение %s должно быть числом или списком чисел", name)
+        }
+        if numbers == nil {
+            numbers = make(map[string]float64)
+        }
+        numbers[name] = number
+    }
+    return numbers, lists, nil
+}
+
+// JoinVariables собирает числа и списки обратно в одно значение variables для JSON.
+func JoinVariables(numbers map[string]float64, lists map[string][]float64) map[string]interface{} {
+    if len(numbers) == 0 && len(lists) == 0 {
+        return nil
+    }
+    variables := make(map[string]interface{}, len(numbers)+len(lists))
+    for name, value := range numbers {
+        variables[name] = value
+    }
+    for name, values := range lists {
+        variables[name] = values
+    }
+    return variables
+}
+//_______________________________________________________________________________________________________________________________
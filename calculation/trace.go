@@ -0,0 +1,111 @@
+package calculation
+
+import (
+    "strconv"
+    "strings"
+)
+
+// Step — один шаг пошагового вычисления: свёртка одной операции в число.
+type Step struct {
+    Expression string  `json:"expression"` // вычисляемое подвыражение в том виде, какой оно имеет на этом шаге
+    Span       Span    `json:"span"`       // положение подвыражения в исходной строке
+    Result     float64 `json:"result"`
+    Rewritten  string  `json:"rewritten"` // всё выражение после шага
+}
+
+// Trace вычисляет разобранное выражение по шагам: каждый раз сворачивается самая левая
+// операция, все операнды которой уже числа. Например, (2+3)*4 → 5*4 → 20.
+// Переменные и отрицательные литералы подставляются без отдельного шага.
+func Trace(input string, node Node, env map[string]float64) ([]Step, error) {
+    if node == nil {
+        return nil, nil
+    }
+    t := tracer{input: input, root: node, env: env, reduced: make(map[Node]float64)}
+    if _, err := t.reduce(node); err != nil {
+        return nil, err
+    }
+    return t.steps, nil
+}
+
+// tracer хранит состояние пошагового вычисления.
+type tracer struct {
+    input   string
+    root    Node
+    env     map[string]float64
+    reduced map[Node]float64 // узлы, уже свёрнутые в число
+    steps   []Step
+}
+
+// reduce сворачивает узел в число и возвращает его значение. Вычисление идёт снизу вверх: операция
+// применяется к уже найденным значениям потомков, поэтому каждый узел вычисляется один раз.
+func (t *tracer) reduce(node Node) (float64, error) {
+    if literal, ok := node.(*NumberLit); ok {
+        return literal.Value, nil
+    }
+
+    // integrate и sum вычисляются одним шагом: их аргументы зависят от связанной переменной
+    folded := node
+    if call, ok := node.(*Call); !ok || !IsSpecialForm(call) {
+        children := Children(node)
+        if len(children) > 0 {
+            values := make([]Node, len(children))
+            for i, child := range children {
+                value, err := t.reduce(child)
+                if err != nil {
+                    return 0, err
+                }
+                values[i] = &NumberLit{Span: child.Pos(), Value: value}
+            }
+            folded = withChildren(node, values)
+        }
+    }
+
+    value, err := Eval(folded, t.env)
+    if err != nil {
+        return 0, err
+    }
+    expression := t.render(node)
+    t.reduced[node] = value
+
+    // Подстановка переменной и минус перед числом — не шаг вычисления
+    if _, ident := node.(*Ident); ident {
+        return value, nil
+    }
+    if unary, ok := node.(*UnaryOp); ok {
+        if _, literal := unary.Operand.(*NumberLit); literal {
+            return value, nil
+        }
+    }
+
+    root := t.root.Pos()
+    t.steps = append(t.steps, Step{
+        Expression: expression,
+        Span:       node.Pos(),
+        Result:     value,
+        Rewritten:  strings.TrimSpace(t.input[:root.Start] + t.render(t.root) + t.input[root.End:]),
+    })
+    return value, nil
+}
+
+// render восстанавливает текст узла из исходной строки, подставляя значения свёрнутых узлов.
+func (t *tracer) render(node Node) string {
+    if value, ok := t.reduced[node]; ok {
+        text := strconv.FormatFloat(value, 'f', -1, 64)
+        // Отрицательное число внутри выражения берём в скобки, чтобы не получилось "2--3"
+        if value < 0 && node != t.root {
+            text = "(" + text + ")"
+        }
+        return text
+    }
+
+    span := node.Pos()
+    text, pos := "", span.Start
+    for _, child := range Children(node) {
+        childSpan := child.Pos()
+        text += t.input[pos:childSpan.Start] + t.render(child)
+        pos = childSpan.End
+    }
+    return text + t.input[pos:span.End]
+}
+
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,329 @@
+package calculation
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Dimension — показатели степени основных единиц СИ в порядке m, kg, s, A, K, mol, cd.
+// Например, скорость (m/s) — {1, 0, -1, 0, 0, 0, 0}.
+type Dimension [7]int
+
+// Основные единицы СИ в порядке Dimension
+var baseUnits = [7]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+// MaxUnitPower — наибольший по модулю показатель степени единицы после возведения в степень: m^1e300
+// не переводится в int.
+const MaxUnitPower = 100
+
+// Quantity — величина: значение в основных единицах СИ и размерность.
+type Quantity struct {
+    Value     float64
+    Dimension Dimension
+}
+
+// DimensionError — ошибка размерности (например, m + s). В отличие от ошибок вычисления,
+// она не зависит от значений и обнаруживается до постановки задачи в очередь.
+type DimensionError struct {
+    Message string
+}
+
+func (e *DimensionError) Error() string { return e.Message }
+
+// quantity строит единицу: множитель к СИ и показатели степени в порядке m, kg, s, A, K, mol, cd.
+func quantity(factor float64, exponents ...int) Quantity {
+    q := Quantity{Value: factor}
+    copy(q.Dimension[:], exponents)
+    return q
+}
+
+// Units — встроенные единицы измерения (множитель к СИ и размерность).
+// Переменные и константы с тем же именем их перекрывают.
+var Units = map[string]Quantity{
+    // Длина
+    "m": quantity(1, 1), "km": quantity(1e3, 1), "cm": quantity(1e-2, 1), "mm": quantity(1e-3, 1),
+    "um": quantity(1e-6, 1), "nm": quantity(1e-9, 1),
+    "in": quantity(0.0254, 1), "ft": quantity(0.3048, 1), "yd": quantity(0.9144, 1), "mi": quantity(1609.344, 1),
+    "nmi": quantity(1852, 1),
+    // Площадь и объём
+    "ha": quantity(1e4, 2), "L": quantity(1e-3, 3), "mL": quantity(1e-6, 3),
+    // Масса
+    "kg": quantity(1, 0, 1), "g": quantity(1e-3, 0, 1), "mg": quantity(1e-6, 0, 1), "t": quantity(1e3, 0, 1),
+    "lb": quantity(0.45359237, 0, 1), "oz": quantity(0.028349523125, 0, 1),
+    // Время и частота
+    "s": quantity(1, 0, 0, 1), "ms": quantity(1e-3, 0, 0, 1), "us": quantity(1e-6, 0, 0, 1),
+    "min": quantity(60, 0, 0, 1), "h": quantity(3600, 0, 0, 1), "day": quantity(86400, 0, 0, 1),
+    "Hz": quantity(1, 0, 0, -1), "kHz": quantity(1e3, 0, 0, -1), "MHz": quantity(1e6, 0, 0, -1),
+    // Скорость
+    "mph": quantity(1609.344/3600, 1, 0, -1), "kn": quantity(1852.0/3600, 1, 0, -1),
+    // Ток, температура, количество вещества, сила света
+    "A": quantity(1, 0, 0, 0, 1), "mA": quantity(1e-3, 0, 0, 0, 1),
+    "K":   quantity(1, 0, 0, 0, 0, 1),
+    "mol": quantity(1, 0, 0, 0, 0, 0, 1),
+    "cd":  quantity(1, 0, 0, 0, 0, 0, 0, 1),
+    // Сила, энергия, мощность, давление
+    "N": quantity(1, 1, 1, -2), "kN": quantity(1e3, 1, 1, -2),
+    "J": quantity(1, 2, 1, -2), "kJ": quantity(1e3, 2, 1, -2), "MJ": quantity(1e6, 2, 1, -2),
+    "Wh": quantity(3600, 2, 1, -2), "kWh": quantity(3.6e6, 2, 1, -2),
+    "cal": quantity(4.184, 2, 1, -2), "kcal": quantity(4184, 2, 1, -2), "eV": quantity(1.602176634e-19, 2, 1, -2),
+    "W": quantity(1, 2, 1, -3), "kW": quantity(1e3, 2, 1, -3), "MW": quantity(1e6, 2, 1, -3),
+    "Pa": quantity(1, -1, 1, -2), "kPa": quantity(1e3, -1, 1, -2), "MPa": quantity(1e6, -1, 1, -2),
+    "bar": quantity(1e5, -1, 1, -2), "atm": quantity(101325, -1, 1, -2), "psi": quantity(6894.757293168, -1, 1, -2),
+    // Электричество
+    "C": quantity(1, 0, 0, 1, 1), "V": quantity(1, 2, 1, -3, -1), "ohm": quantity(1, 2, 1, -3, -2),
+}
+
+// Производные единицы СИ, которыми записывается результат, если размерность совпадает
+var namedUnits = []string{"N", "J", "W", "Pa", "C", "V", "ohm"}
+//_______________________________________________________________________________________________________________________________
+
+// EvalUnits вычисляет AST с единицами измерения: имена из Units — единицы, число перед ними —
+// их количество ("5 km" = 5 * km). Складывать и вычитать можно только величины одной размерности,
+// аргументы exp, ln, sin и других функций должны быть безразмерными. Результат — в основных единицах СИ.
+// env — значения переменных (безразмерные числа, может быть nil).
+func EvalUnits(node Node, env map[string]float64) (Quantity, error) {
+    switch n := node.(type) {
+    case *NumberLit:
+        return Quantity{Value: n.Value}, nil
+
+    case *Ident:
+        if value, ok := env[n.Name]; ok {
+            return Quantity{Value: value}, nil
+        }
+        if value, ok := Constants[n.Name]; ok {
+            return Quantity{Value: value}, nil
+        }
+        if unit, ok := Units[n.Name]; ok {
+            return unit, nil
+        }
+        return Quantity{}, fmt.Errorf("[Ошибка] Неизвестная переменная или единица %q", n.Name)
+
+    case *UnaryOp:
+        operand, err := EvalUnits(n.Operand, env)
+        operand.Value = -operand.Value
+        return operand, err
+
+    case *BinaryOp:
+        left, err := EvalUnits(n.Left, env)
+        if err != nil {
+            return Quantity{}, err
+        }
+        right, err := EvalUnits(n.Right, env)
+        if err != nil {
+            return Quantity{}, err
+        }
+        var result Quantity
+        switch n.Operator {
+        case "+", "-":
+            if left.Dimension != right.Dimension {
+                return Quantity{}, &DimensionError{fmt.Sprintf("[Ошибка] Несовместимые размерности: %s %s %s",
+                    left.Dimension.label(), n.Operator, right.Dimension.label())}
+            }
+            result = Quantity{Value: left.Value + right.Value, Dimension: left.Dimension}
+            if n.Operator == "-" {
+                result.Value = left.Value - right.Value
+            }
+        case "*":
+            result = Quantity{Value: left.Value * right.Value, Dimension: left.Dimension.add(right.Dimension, 1)}
+        case "/":
+            if right.Value == 0 {
+                return Quantity{}, fmt.Errorf("[Ошибка] Деление на ноль!")
+            }
+            result = Quantity{Value: left.Value / right.Value, Dimension: left.Dimension.add(right.Dimension, -1)}
+        case "^":
+            if result, err = powerUnits(left, right); err != nil {
+                return Quantity{}, err
+            }
+        default:
+            return Quantity{}, fmt.Errorf("[Ошибка] Неизвестный оператор %q", n.Operator)
+        }
+        result.Value, err = checkResult(n.Operator, result.Value)
+        return result, err
+
+    case *Call:
+        if IsSpecialForm(n) {
+            return Quantity{}, fmt.Errorf("[Ошибка] %s недоступна с единицами измерения", n.Name)
+        }
+        function, err := lookupFunction(n)
+        if err != nil {
+            return Quantity{}, err
+        }
+        args := make([]Quantity, len(n.Args))
+        for i, arg := range n.Args {
+            if args[i], err = EvalUnits(arg, env); err != nil {
+                return Quantity{}, err
+            }
+        }
+        result, err := callUnits(n.Name, function, args)
+        if err != nil {
+            return Quantity{}, err
+        }
+        result.Value, err = checkResult(n.Name, result.Value)
+        return result, err
+    }
+    return Quantity{}, fmt.Errorf("[Ошибка] Неизвестный узел AST %T", node)
+}
+
+// powerUnits возводит величину в безразмерную степень. Размерная величина возводится только в такую степень,
+// при которой показатели остаются целыми и не больше MaxUnitPower по модулю: m^2, (m^2)^0.5, но не m^0.5.
+func powerUnits(base, exponent Quantity) (Quantity, error) {
+    if !exponent.Dimension.dimensionless() {
+        return Quantity{}, &DimensionError{fmt.Sprintf("[Ошибка] Показатель степени должен быть безразмерным, а не %s", exponent.Dimension.label())}
+    }
+    result := Quantity{Value: math.Pow(base.Value, exponent.Value)}
+    for i, power := range base.Dimension {
+        scaled := float64(power) * exponent.Value
+        if scaled != math.Trunc(scaled) {
+            return Quantity{}, &DimensionError{fmt.Sprintf("[Ошибка] Нельзя возвести %s в степень %v", base.Dimension.label(), exponent.Value)}
+        }
+        if math.Abs(scaled) > MaxUnitPower {
+            return Quantity{}, &DimensionError{fmt.Sprintf("[Ошибка] Слишком большой показатель размерности %s^%v (больше %d)", base.Dimension.label(), exponent.Value, MaxUnitPower)}
+        }
+        result.Dimension[i] = int(scaled)
+    }
+    return result, nil
+}
+
+// callUnits применяет функцию к величинам: sqrt и pow — как степень, abs, floor, ceil, round, min, max
+// сохраняют размерность (у min и max она должна совпадать), остальные принимают только безразмерные аргументы.
+func callUnits(name string, function Function, args []Quantity) (Quantity, error) {
+    switch name {
+    case "sqrt":
+        return powerUnits(args[0], Quantity{Value: 0.5})
+    case "pow":
+        return powerUnits(args[0], args[1])
+    case "abs", "floor", "ceil", "round", "min", "max", "re", "conj", "sum", "mean", "median", "stddev":
+        values := make([]float64, len(args))
+        for i, arg := range args {
+            if arg.Dimension != args[0].Dimension {
+                return Quantity{}, &DimensionError{fmt.Sprintf("[Ошибка] Несовместимые размерности в %s: %s и %s",
+                    name, args[0].Dimension.label(), arg.Dimension.label())}
+            }
+            values[i] = arg.Value
+        }
+        return Quantity{Value: function.Apply(values), Dimension: args[0].Dimension}, nil
+    }
+    values := make([]float64, len(args))
+    for i, arg := range args {
+        if !arg.Dimension.dimensionless() {
+            return Quantity{}, &DimensionError{fmt.Sprintf("[Ошибка] Аргумент %s должен быть безразмерным, а не %s", name, arg.Dimension.label())}
+        }
+        values[i] = arg.Value
+    }
+    return Quantity{Value: function.Apply(values)}, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// ParseUnit разбирает запись единицы измерения ("km/h", "kg*m^2", "N*m") в величину с множителем к СИ.
+func ParseUnit(unit string) (Quantity, error) {
+    node, err := Parse(unit)
+    if err != nil {
+        return Quantity{}, err
+    }
+    for _, name := range Variables(node) {
+        if _, ok := Units[name]; !ok {
+            return Quantity{}, fmt.Errorf("[Ошибка] Неизвестная единица %q", name)
+        }
+    }
+    q, err := EvalUnits(node, nil)
+    if err == nil && q.Value == 0 {
+        err = fmt.Errorf("[Ошибка] Нулевая единица %q", unit)
+    }
+    return q, err
+}
+
+// Convert переводит величину в единицу unit. Пустая unit — основные (или производные) единицы СИ.
+// Возвращает значение и запись единицы.
+func Convert(q Quantity, unit string) (float64, string, error) {
+    if unit == "" {
+        return q.Value, q.Dimension.String(), nil
+    }
+    target, err := ParseUnit(unit)
+    if err != nil {
+        return 0, "", err
+    }
+    if target.Dimension != q.Dimension {
+        return 0, "", &DimensionError{fmt.Sprintf("[Ошибка] Нельзя перевести %s в %s", q.Dimension.label(), unit)}
+    }
+    return q.Value / target.Value, unit, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+func (d Dimension) add(other Dimension, sign int) Dimension {
+    for i := range d {
+        d[i] += sign * other[i]
+    }
+    return d
+}
+
+func (d Dimension) dimensionless() bool {
+    return d == Dimension{}
+}
+
+// String записывает размерность в единицах СИ: "kg*m^2/s^2" или производной единицей ("J"), если она есть.
+// Безразмерная величина — пустая строка.
+func (d Dimension) String() string {
+    for _, name := range namedUnits {
+        if Units[name].Dimension == d {
+            return name
+        }
+    }
+    var numerator, denominator []string
+    for _, i := range []int{1, 0, 2, 3, 4, 5, 6} { // kg*m*s — привычный порядок
+        power := d[i]
+        if power == 0 {
+            continue
+        }
+        part := baseUnits[i]
+        if power > 1 || power < -1 {
+            part += "^" + strconv.Itoa(abs(power))
+        }
+        if power > 0 {
+            numerator = append(numerator, part)
+        } else {
+            denominator = append(denominator, part)
+        }
+    }
+    result := strings.Join(numerator, "*")
+    if len(denominator) > 0 {
+        if result == "" {
+            result = "1"
+        }
+        result += "/" + strings.Join(denominator, "/")
+    }
+    return result
+}
+
+// label — запись размерности для сообщений об ошибках.
+func (d Dimension) label() string {
+    if d.dimensionless() {
+        return "безразмерное число"
+    }
+    return d.String()
+}
+
+func abs(x int) int {
+    if x < 0 {
+        return -x
+    }
+    return x
+}
+//_______________________________________________________________________________________________________________________________
+
+// ValidateUnits проверяет AST для вычисления с единицами: как Validate, но имена из Units считаются определёнными.
+func ValidateUnits(node Node, env map[string]float64) error {
+    if err := rejectLogic(node, "units"); err != nil {
+        return err
+    }
+    extended := make(map[string]float64, len(env)+len(Units))
+    for name := range Units {
+        extended[name] = 0
+    }
+    for name, value := range env {
+        extended[name] = value
+    }
+    return Validate(node, extended)
+}
+//_______________________________________________________________________________________________________________________________
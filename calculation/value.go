@@ -0,0 +1,474 @@
+package calculation
+
+import (
+    "fmt"
+)
+
+// Kind — тип значения выражения.
+type Kind int
+
+const (
+    KindNumber  Kind = iota // число
+    KindBoolean             // логическое значение: результат сравнения, and, or, not
+    KindVector              // вектор [1, 2, 3]
+    KindMatrix              // матрица [[1, 2], [3, 4]]
+)
+
+func (k Kind) String() string {
+    return map[Kind]string{
+        KindNumber:  "число",
+        KindBoolean: "логическое значение",
+        KindVector:  "вектор",
+        KindMatrix:  "матрица",
+    }[k]
+}
+
+// Value — значение выражения: число, логическое значение, вектор или матрица (по строкам).
+type Value struct {
+    Kind    Kind
+    Number  float64
+    Boolean bool
+    Vector  []float64
+    Matrix  [][]float64
+}
+
+// Shape возвращает размер вектора ("3") или матрицы ("2×3") для сообщений об ошибках.
+func (v Value) Shape() string {
+    switch v.Kind {
+    case KindVector:
+        return fmt.Sprint(len(v.Vector))
+    case KindMatrix:
+        return fmt.Sprintf("%d×%d", len(v.Matrix), len(v.Matrix[0]))
+    }
+    return v.Kind.String()
+}
+
+// Interface возвращает значение для записи в JSON: число, true/false или вложенные массивы.
+func (v Value) Interface() interface{} {
+    switch v.Kind {
+    case KindBoolean:
+        return v.Boolean
+    case KindVector:
+        return v.Vector
+    case KindMatrix:
+        return v.Matrix
+    }
+    return v.Number
+}
+
+// TypeError — ошибка типа (например, 1 + (x > 2) или if(x, 1, 2)). Как и ошибка размерности,
+// она не зависит от значений переменных и обнаруживается до постановки задачи в очередь (см. TypeOf).
+type TypeError struct {
+    Message string
+}
+
+func (e *TypeError) Error() string { return e.Message }
+
+// ComparisonOperators — операторы сравнения. Числа сравниваются любым из них,
+// логические значения — только == и !=.
+var ComparisonOperators = map[string]bool{
+    "==": true,
+    "!=": true,
+    "<":  true,
+    "<=": true,
+    ">":  true,
+    ">=": true,
+}
+
+// LogicalOperators — логические операторы над логическими значениями (кроме унарного not).
+var LogicalOperators = map[string]bool{
+    "and": true,
+    "or":  true,
+}
+//_______________________________________________________________________________________________________________________________
+
+// EvalValue вычисляет AST, значение которого — число, логическое значение, вектор или матрица.
+// and, or и if вычисляются с коротким замыканием: в if(x == 0, 0, 1/x) при x = 0
+// деление не выполняется.
+func EvalValue(node Node, env map[string]float64) (Value, error) {
+    switch n := node.(type) {
+    case *UnaryOp:
+        if n.Operator == "not" {
+            operand, err := evalBoolean(n.Operand, env)
+            return Value{Kind: KindBoolean, Boolean: !operand}, err
+        }
+        operand, err := EvalValue(n.Operand, env)
+        if err != nil {
+            return Value{}, err
+        }
+        return mapValue(operand, func(x float64) (float64, error) { return -x, nil })
+
+    case *BinaryOp:
+        if LogicalOperators[n.Operator] {
+            left, err := evalBoolean(n.Left, env)
+            if err != nil {
+                return Value{}, err
+            }
+            // Результат уже известен: false and ..., true or ...
+            if left == (n.Operator == "or") {
+                return Value{Kind: KindBoolean, Boolean: left}, nil
+            }
+            right, err := evalBoolean(n.Right, env)
+            return Value{Kind: KindBoolean, Boolean: right}, err
+        }
+        if ComparisonOperators[n.Operator] {
+            return compare(n, env)
+        }
+        left, err := EvalValue(n.Left, env)
+        if err != nil {
+            return Value{}, err
+        }
+        right, err := EvalValue(n.Right, env)
+        if err != nil {
+            return Value{}, err
+        }
+        return elementwise(n.Operator, left, right)
+
+    case *ArrayLit:
+        return evalArray(n, env)
+
+    case *UserCall:
+        return n.Function.call(n, env)
+
+    case *Call:
+        if n.Name == "if" {
+            if len(n.Args) != 3 {
+                return Value{}, fmt.Errorf("[Ошибка] Неверное число аргументов функции if: %d", len(n.Args))
+            }
+            condition, err := evalBoolean(n.Args[0], env)
+            if err != nil {
+                return Value{}, err
+            }
+            if condition {
+                return EvalValue(n.Args[1], env)
+            }
+            return EvalValue(n.Args[2], env)
+        }
+        if !IsSpecialForm(n) {
+            return evalCall(n, env)
+        }
+    }
+
+    number, err := evalNumber(node, env)
+    if err != nil {
+        return Value{}, err
+    }
+    return Value{Kind: KindNumber, Number: number}, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// evalCall вычисляет вызов функции. Функция одного числа применяется к вектору или матрице поэлементно: sqrt([4, 9]),
+// функция любого числа аргументов получает элементы списков: max([1, 5], 3) = max(1, 5, 3).
+func evalCall(n *Call, env map[string]float64) (Value, error) {
+    matrixFunction, isMatrix := MatrixFunctions[n.Name]
+    var function Function
+    if isMatrix {
+        if len(n.Args) != matrixFunction.Args {
+            return Value{}, fmt.Errorf("[Ошибка] Неверное число аргументов функции %s: %d", n.Name, len(n.Args))
+        }
+    } else {
+        var err error
+        if function, err = lookupFunction(n); err != nil {
+            return Value{}, err
+        }
+    }
+
+    args := make([]Value, len(n.Args))
+    kinds := make([]Kind, len(n.Args))
+    for i, arg := range n.Args {
+        var err error
+        if args[i], err = EvalValue(arg, env); err != nil {
+            return Value{}, err
+        }
+        kinds[i] = args[i].Kind
+    }
+    if _, ok := callType(n.Name, kinds); !ok {
+        return Value{}, &TypeError{fmt.Sprintf("[Ошибка] Неверные типы аргументов функции %s: %v", n.Name, kinds)}
+    }
+    if isMatrix {
+        return matrixFunction.Apply(args)
+    }
+
+    if len(args) == 1 && args[0].Kind != KindNumber && function.MaxArgs == 1 {
+        return mapValue(args[0], func(x float64) (float64, error) {
+            return checkResult(n.Name, function.Apply([]float64{x}))
+        })
+    }
+    numbers := make([]float64, 0, len(args))
+    for _, arg := range args {
+        if arg.Kind == KindVector {
+            numbers = append(numbers, arg.Vector...)
+        } else {
+            numbers = append(numbers, arg.Number)
+        }
+    }
+    result, err := checkResult(n.Name, function.Apply(numbers))
+    return Value{Kind: KindNumber, Number: result}, err
+}
+
+// callType возвращает тип результата функции по типам аргументов; false — аргументы не того типа.
+// Число аргументов уже проверено.
+func callType(name string, args []Kind) (Kind, bool) {
+    if function, ok := MatrixFunctions[name]; ok {
+        return function.Type(args)
+    }
+    function := Functions[name]
+    if len(args) == 1 && function.MaxArgs == 1 && (args[0] == KindVector || args[0] == KindMatrix) {
+        return args[0], true
+    }
+    for _, arg := range args {
+        // Функции любого числа аргументов (min, mean, ...) разворачивают списки
+        if arg != KindNumber && (function.MaxArgs >= 0 || arg != KindVector) {
+            return KindNumber, false
+        }
+    }
+    return KindNumber, true
+}
+//_______________________________________________________________________________________________________________________________
+
+// compare вычисляет сравнение. Операнды должны быть одного типа.
+func compare(n *BinaryOp, env map[string]float64) (Value, error) {
+    left, err := EvalValue(n.Left, env)
+    if err != nil {
+        return Value{}, err
+    }
+    right, err := EvalValue(n.Right, env)
+    if err != nil {
+        return Value{}, err
+    }
+    if err := compareTypes(n, left.Kind, right.Kind); err != nil {
+        return Value{}, err
+    }
+
+    var result bool
+    switch n.Operator {
+    case "==":
+        result = left.Number == right.Number && left.Boolean == right.Boolean
+    case "!=":
+        result = left.Number != right.Number || left.Boolean != right.Boolean
+    case "<":
+        result = left.Number < right.Number
+    case "<=":
+        result = left.Number <= right.Number
+    case ">":
+        result = left.Number > right.Number
+    case ">=":
+        result = left.Number >= right.Number
+    }
+    return Value{Kind: KindBoolean, Boolean: result}, nil
+}
+
+// compareTypes проверяет типы операндов сравнения.
+func compareTypes(n *BinaryOp, left, right Kind) error {
+    if left == KindVector || left == KindMatrix || right == KindVector || right == KindMatrix {
+        return &TypeError{fmt.Sprintf("[Ошибка] Векторы и матрицы нельзя сравнивать: %s", Format(n))}
+    }
+    if left != right {
+        return &TypeError{fmt.Sprintf("[Ошибка] Нельзя сравнить %s и %s: %s", left, right, Format(n))}
+    }
+    if left == KindBoolean && n.Operator != "==" && n.Operator != "!=" {
+        return &TypeError{fmt.Sprintf("[Ошибка] Логические значения сравниваются только == и !=: %s", Format(n))}
+    }
+    return nil
+}
+
+// evalBoolean вычисляет условие: его значение должно быть логическим.
+func evalBoolean(node Node, env map[string]float64) (bool, error) {
+    value, err := EvalValue(node, env)
+    if err != nil {
+        return false, err
+    }
+    if value.Kind != KindBoolean {
+        return false, &TypeError{fmt.Sprintf("[Ошибка] Ожидалось логическое значение, а не число: %s", Format(node))}
+    }
+    return value.Boolean, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// TypeOf определяет тип значения выражения без вычисления и возвращает *TypeError,
+// если операнд имеет не тот тип: арифметика принимает числа, векторы и матрицы, and, or, not и условие if —
+// логические значения, ветви if должны быть одного типа. Размеры векторов и матриц проверяются при вычислении.
+func TypeOf(node Node) (Kind, error) {
+    switch n := node.(type) {
+    case *UnaryOp:
+        if n.Operator == "not" {
+            return KindBoolean, expectType(n.Operand, KindBoolean)
+        }
+        kind, err := TypeOf(n.Operand)
+        if err == nil && kind == KindBoolean {
+            err = &TypeError{fmt.Sprintf("[Ошибка] Ожидалось число, а не %s: %s", kind, Format(n.Operand))}
+        }
+        return kind, err
+
+    case *BinaryOp:
+        if LogicalOperators[n.Operator] {
+            if err := expectType(n.Left, KindBoolean); err != nil {
+                return KindBoolean, err
+            }
+            return KindBoolean, expectType(n.Right, KindBoolean)
+        }
+        left, err := TypeOf(n.Left)
+        if err != nil {
+            return left, err
+        }
+        right, err := TypeOf(n.Right)
+        if err != nil {
+            return right, err
+        }
+        if ComparisonOperators[n.Operator] {
+            return KindBoolean, compareTypes(n, left, right)
+        }
+        return arithmeticType(n, left, right)
+
+    case *UserCall:
+        for _, arg := range n.Args {
+            if err := expectType(arg, KindNumber); err != nil {
+                return n.Function.Kind, err
+            }
+        }
+        return n.Function.Kind, nil
+
+    case *ArrayLit:
+        if len(n.Elements) == 0 {
+            return KindVector, &TypeError{"[Ошибка] Пустой массив"}
+        }
+        first, err := TypeOf(n.Elements[0])
+        if err != nil {
+            return KindVector, err
+        }
+        if first != KindNumber && first != KindVector {
+            return KindVector, &TypeError{fmt.Sprintf("[Ошибка] Элементы массива должны быть числами или векторами: %s", Format(n))}
+        }
+        for _, element := range n.Elements[1:] {
+            if err := expectType(element, first); err != nil {
+                return KindVector, err
+            }
+        }
+        if first == KindVector {
+            return KindMatrix, nil
+        }
+        return KindVector, nil
+
+    case *Call:
+        if n.Name == "if" && len(n.Args) == 3 {
+            if err := expectType(n.Args[0], KindBoolean); err != nil {
+                return KindNumber, err
+            }
+            kind, err := TypeOf(n.Args[1])
+            if err != nil {
+                return kind, err
+            }
+            if other, err := TypeOf(n.Args[2]); err != nil || other != kind {
+                if err == nil {
+                    err = &TypeError{fmt.Sprintf("[Ошибка] Ветви if разного типа: %s и %s", kind, other)}
+                }
+                return kind, err
+            }
+            return kind, nil
+        }
+        if IsSpecialForm(n) {
+            break
+        }
+        kinds := make([]Kind, len(n.Args))
+        for i, arg := range n.Args {
+            var err error
+            if kinds[i], err = TypeOf(arg); err != nil {
+                return KindNumber, err
+            }
+        }
+        if function, ok := MatrixFunctions[n.Name]; ok && len(n.Args) != function.Args {
+            return KindNumber, fmt.Errorf("[Ошибка] Неверное число аргументов функции %s: %d", n.Name, len(n.Args))
+        }
+        kind, ok := callType(n.Name, kinds)
+        if !ok {
+            return kind, &TypeError{fmt.Sprintf("[Ошибка] Неверные типы аргументов функции %s: %v", n.Name, kinds)}
+        }
+        return kind, nil
+    }
+
+    // Числа, переменные, integrate и sum: все операнды — числа
+    for _, child := range Children(node) {
+        if err := expectType(child, KindNumber); err != nil {
+            return KindNumber, err
+        }
+    }
+    return KindNumber, nil
+}
+
+// expectType проверяет, что значение выражения имеет тип kind.
+func expectType(node Node, kind Kind) error {
+    actual, err := TypeOf(node)
+    if err == nil && actual != kind {
+        err = &TypeError{fmt.Sprintf("[Ошибка] Ожидалось %s, а не %s: %s", kind, actual, Format(node))}
+    }
+    return err
+}
+
+// arithmeticType — тип результата арифметической операции: с числом — тип массива,
+// над двумя массивами — только одного типа (вектор + матрица — ошибка).
+func arithmeticType(n *BinaryOp, left, right Kind) (Kind, error) {
+    switch {
+    case left == KindBoolean || right == KindBoolean:
+        return KindNumber, &TypeError{fmt.Sprintf("[Ошибка] Оператор %s не применим к логическим значениям: %s", n.Operator, Format(n))}
+    case left == KindNumber:
+        return right, nil
+    case right == KindNumber || left == right:
+        return left, nil
+    }
+    return left, &TypeError{fmt.Sprintf("[Ошибка] Оператор %s не применим к значениям %s и %s: %s", n.Operator, left, right, Format(n))}
+}
+//_______________________________________________________________________________________________________________________________
+
+// UsesLogic сообщает, что в выражении есть сравнения, логические операторы или if.
+func UsesLogic(node Node) bool {
+    switch n := node.(type) {
+    case *UnaryOp:
+        if n.Operator == "not" {
+            return true
+        }
+    case *BinaryOp:
+        if LogicalOperators[n.Operator] || ComparisonOperators[n.Operator] {
+            return true
+        }
+    case *Call:
+        if n.Name == "if" {
+            return true
+        }
+    }
+    for _, child := range Children(node) {
+        if UsesLogic(child) {
+            return true
+        }
+    }
+    return false
+}
+
+// UsesArrays сообщает, что в выражении есть векторы или матрицы.
+func UsesArrays(node Node) bool {
+    switch n := node.(type) {
+    case *ArrayLit:
+        return true
+    case *Call:
+        if _, ok := MatrixFunctions[n.Name]; ok {
+            return true
+        }
+    }
+    for _, child := range Children(node) {
+        if UsesArrays(child) {
+            return true
+        }
+    }
+    return false
+}
+
+// rejectLogic запрещает сравнения, условия, векторы и матрицы в режимах, где значения — не действительные числа.
+func rejectLogic(node Node, mode string) error {
+    if UsesLogic(node) {
+        return fmt.Errorf("[Ошибка] Сравнения и условия недоступны в режиме %s", mode)
+    }
+    if UsesArrays(node) {
+        return fmt.Errorf("[Ошибка] Векторы и матрицы недоступны в режиме %s", mode)
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
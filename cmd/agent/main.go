@@ -10,15 +10,35 @@ import (
     "github.com/gulovv/web_calculator/calculation"
 )
 
+// HeartbeatInterval — как часто агент сообщает оркестратору, что вычисляет задачу
+const HeartbeatInterval = 2 * time.Second
+
 type Response struct {
     Task Task `json:"task"`
 }
 
 type Task struct {
-    ID         int     `json:"id"`
-    Expression string  `json:"expression"`
-    Result     float64 `json:"result,omitempty"`
-    Status     string  `json:"status"`
+    ID         int                        `json:"id"`
+    Expression string                     `json:"expression"`
+    Result     interface{}                `json:"result,omitempty"` // число, {"re", "im"} в комплексном режиме, строка в целочисленном, true/false или массивы
+    Estimate   float64                    `json:"error_estimate,omitempty"`
+    Status     string                     `json:"status"`
+    Error      string                     `json:"error,omitempty"`
+    Variables  map[string]json.RawMessage `json:"variables,omitempty"` // числа и списки чисел
+    Simplified string                     `json:"simplified,omitempty"`
+    Type       string                     `json:"type,omitempty"`
+    Variable   string                     `json:"variable,omitempty"`
+    Range      []float64                  `json:"range,omitempty"`
+    Roots      []float64                  `json:"roots,omitempty"`
+    Samples    int                        `json:"samples,omitempty"`
+    Points     []calculation.Point        `json:"points,omitempty"`
+    Mode       string                     `json:"mode,omitempty"`
+    Unit       string                     `json:"unit,omitempty"`
+    Width      string                     `json:"width,omitempty"`
+    Base       int                        `json:"base,omitempty"`
+    Trace      bool                       `json:"trace,omitempty"`
+    Steps      []calculation.Step         `json:"steps,omitempty"`
+    Bindings   map[string]interface{}     `json:"bindings,omitempty"` // итоговые значения программы
 }
 
 //_______________________________________________________________________________________________________________________________
@@ -71,21 +91,82 @@ func agent() {
             continue
         }
 
+        // Проверяем, не отменили ли задачу, пока она ждала агента
+        if isCancelled(task.ID) {
+            fmt.Printf("Задача ID=%d отменена, пропускаем её.\n", task.ID)
+            continue
+        }
+
         // Выводим математическое выражение
         fmt.Println("Полученное выражение:", task.Expression)
-        tokens := calculation.Tokenize(task.Expression)
 
-        
+        // Если оркестратор упростил выражение, вычисляем упрощённую форму
+        expression := task.Expression
+        if task.Simplified != "" {
+            expression = task.Simplified
+        }
+
+        // Значения переменных: числа и списки чисел
+        variables, lists, err := calculation.SplitVariables(task.Variables)
 
-        // Парсинг: построение AST
-        parser := calculation.Parser{Tokens: tokens}
-        ast := parser.ParseExpression() // исправленный вызов функции
+        // Парсинг: построение AST и вычисление (или решение уравнения).
+        // Вычисление идёт в отдельной горутине, а агент тем временем отправляет heartbeat
+        var result interface{}
+        var estimate float64
+        var steps []calculation.Step
+        var roots []float64
+        var points []calculation.Point
+        done := make(chan struct{})
+        go func() {
+            defer close(done)
+            defer func() {
+                if r := recover(); r != nil {
+                    err = fmt.Errorf("%v", r)
+                }
+            }()
+            if err != nil {
+                // Некорректные значения переменных: задача завершается ошибкой
+            } else if task.Type == "solve" {
+                roots, err = solve(expression, task.Variable, variables, task.Range)
+            } else if task.Type == "plot" {
+                points, err = plot(expression, task.Variable, variables, task.Range, task.Samples)
+            } else if task.Mode == "complex" {
+                result, err = evaluateComplex(expression, variables)
+            } else if task.Mode == "units" {
+                result, task.Unit, err = evaluateUnits(expression, variables, task.Unit)
+            } else if task.Mode == "integer" {
+                result, err = evaluateInteger(expression, variables, task.Width, task.Base)
+            } else if calculation.IsProgram(expression) {
+                result, task.Bindings, err = evaluateProgram(expression, variables, lists)
+            } else {
+                result, estimate, steps, err = evaluate(expression, variables, lists, task.Trace)
+            }
+        }()
 
-        fmt.Println("Результат вычисления:", ast.Value)
+        // Задачу могли отменить во время вычисления — тогда результат не нужен.
+        // Горутина досчитает сама, её переменные следующей задаче не достанутся
+        if !waitEvaluation(task.ID, done) {
+            fmt.Printf("Задача ID=%d отменена во время вычисления, результат не отправляем.\n", task.ID)
+            continue
+        }
+        if err != nil {
+            fmt.Println("Ошибка при вычислении выражения:", err)
+        } else {
+            fmt.Println("Результат вычисления:", result, roots)
+        }
 
         // Обновляем результат задачи
-        task.Result = ast.Value
-        task.Status = "completed" // Обновляем статус задачи на "completed"
+        if err != nil {
+            task.Status = "failed" // Сообщаем оркестратору об ошибке вычисления
+            task.Error = err.Error()
+        } else {
+            task.Result = result
+            task.Estimate = estimate
+            task.Steps = steps
+            task.Roots = roots
+            task.Points = points
+            task.Status = "completed" // Обновляем статус задачи на "completed"
+        }
         taskData, _ := json.Marshal(task)
 
 
@@ -99,7 +180,7 @@ func agent() {
 
         
 
-        fmt.Printf("Задача: %s, Результат: %f\n", task.Expression, task.Result)
+        fmt.Printf("Задача: %s, Результат: %v\n", task.Expression, task.Result)
 
         // Пауза перед следующим запросом
         time.Sleep(1 * time.Second)
@@ -108,6 +189,162 @@ func agent() {
 
 //_______________________________________________________________________________________________________________________________
 
+// evaluate разбирает и вычисляет выражение со значениями переменных. Результат — число,
+// true/false для сравнений и условий или вложенные массивы для векторов и матриц. lists — переменные-списки.
+// Для интеграла integrate(...) возвращает оценку погрешности, если trace — шаги вычисления
+func evaluate(expression string, variables map[string]float64, lists map[string][]float64, trace bool) (interface{}, float64, []calculation.Step, error) {
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        return 0, 0, nil, err
+    }
+    ast = calculation.BindLists(ast, lists)
+    if kind, _ := calculation.TypeOf(ast); kind != calculation.KindNumber {
+        value, err := calculation.EvalValue(ast, variables)
+        if err != nil {
+            return nil, 0, nil, err
+        }
+        return value.Interface(), 0, nil, nil
+    }
+    result, estimate, err := calculation.EvalWithErrorEstimate(ast, variables)
+    if err != nil || !trace {
+        return result, estimate, nil, err
+    }
+    steps, err := calculation.Trace(expression, ast, variables)
+    return result, estimate, steps, err
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// evaluateProgram выполняет программу из нескольких инструкций ("f(x) = x^2 + 1; a = f(3); a * 2").
+// Возвращает значение последней инструкции и итоговые значения переменных и функций программы
+func evaluateProgram(expression string, variables map[string]float64, lists map[string][]float64) (interface{}, map[string]interface{}, error) {
+    program, err := calculation.ParseProgram(expression)
+    if err != nil {
+        return nil, nil, err
+    }
+    value, bindings, err := calculation.EvalProgram(program, variables, lists)
+    if err != nil {
+        return nil, nil, err
+    }
+    return value.Interface(), bindings, nil
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// evaluateComplex разбирает и вычисляет выражение в комплексном режиме
+func evaluateComplex(expression string, variables map[string]float64) (calculation.Complex, error) {
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        return calculation.Complex{}, err
+    }
+    result, err := calculation.EvalComplex(ast, variables)
+    return calculation.Complex{Re: real(result), Im: imag(result)}, err
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// evaluateUnits вычисляет выражение с единицами измерения и переводит результат в единицу unit
+// (если она не задана — в единицы СИ). Возвращает значение и единицу результата
+func evaluateUnits(expression string, variables map[string]float64, unit string) (float64, string, error) {
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        return 0, unit, err
+    }
+    quantity, err := calculation.EvalUnits(ast, variables)
+    if err != nil {
+        return 0, unit, err
+    }
+    return calculation.Convert(quantity, unit)
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// evaluateInteger вычисляет выражение в целочисленном режиме разрядности width
+// и записывает результат в системе счисления base ("0xFF")
+func evaluateInteger(expression string, variables map[string]float64, width string, base int) (string, error) {
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        return "", err
+    }
+    result, err := calculation.EvalInteger(ast, variables, width)
+    if err != nil {
+        return "", err
+    }
+    return calculation.FormatInteger(result, base), nil
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// solve разбирает уравнение и находит его корни по переменной variable.
+// searchRange — отрезок численного поиска [min, max], по умолчанию [-100, 100]
+func solve(expression, variable string, variables map[string]float64, searchRange []float64) ([]float64, error) {
+    equation, err := calculation.ParseEquation(expression)
+    if err != nil {
+        return nil, err
+    }
+    min, max := calculation.DefaultSolveMin, calculation.DefaultSolveMax
+    if len(searchRange) == 2 {
+        min, max = searchRange[0], searchRange[1]
+    }
+    return calculation.Solve(equation, variable, variables, min, max)
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// plot вычисляет samples точек графика выражения по переменной variable на отрезке plotRange
+func plot(expression, variable string, variables map[string]float64, plotRange []float64, samples int) ([]calculation.Point, error) {
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        return nil, err
+    }
+    if len(plotRange) != 2 {
+        return nil, fmt.Errorf("[Ошибка] Не задан отрезок графика")
+    }
+    return calculation.Sample(ast, variable, variables, plotRange[0], plotRange[1], samples), nil
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// isCancelled отправляет heartbeat по задаче и возвращает true, если оркестратор её отменил
+func isCancelled(id int) bool {
+    body, _ := json.Marshal(map[string]int{"id": id})
+    resp, err := http.Post("http://orchestrator:8080/api/v1/task/heartbeat", "application/json", bytes.NewBuffer(body))
+    if err != nil {
+        fmt.Println("Ошибка отправки heartbeat:", err)
+        return false // Не удалось связаться с оркестратором — продолжаем работу
+    }
+    defer resp.Body.Close()
+
+    var heartbeat struct {
+        Cancelled bool `json:"cancelled"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&heartbeat); err != nil {
+        return false
+    }
+    return heartbeat.Cancelled
+}
+
+//_______________________________________________________________________________________________________________________________
+
+// waitEvaluation ждёт окончания вычисления задачи id (закрытия done) и раз в HeartbeatInterval
+// отправляет heartbeat. Возвращает false, если задачу отменили — во время вычисления или сразу после
+func waitEvaluation(id int, done <-chan struct{}) bool {
+    ticker := time.NewTicker(HeartbeatInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-done:
+            return !isCancelled(id)
+        case <-ticker.C:
+            if isCancelled(id) {
+                return false
+            }
+        }
+    }
+}
+
+//_______________________________________________________________________________________________________________________________
+
 
 func main(){
 	agent()
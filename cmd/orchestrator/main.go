@@ -9,13 +9,30 @@ import (
 func main() {
     fmt.Println("Запуск сервера Оркестратора...")
 
+    // Загрузка настроек из переменных окружения
+    handler.LoadConfig()
+
+    // Фоновая проверка отложенных задач и расписаний
+    handler.StartScheduler(handler.SchedulerInterval)
+
     // Добавление всех эндпоинтов
     http.HandleFunc("/api/v1/calculate", handler.AddTask)         // Для добавления новой задачи
     http.HandleFunc("/api/v1/task", handler.GetTask)              // Для получения задачи агентом
     http.HandleFunc("/api/v1/task/result", handler.UpdateTaskResult) // Для обновления результата задачи
+    http.HandleFunc("/api/v1/evaluate", handler.Evaluate)         // Синхронное вычисление без очереди
+    http.HandleFunc("/api/v1/ast", handler.InspectAST)            // Дерево разбора выражения в JSON
+    http.HandleFunc("/api/v1/derive", handler.DeriveExpression)   // Производная выражения по переменной
+    http.HandleFunc("/api/v1/plot", handler.Plot)                 // Точки графика выражения (JSON или SVG)
+    http.HandleFunc("/api/v1/plot/", handler.PlotByID)            // График, который строили агенты
     http.HandleFunc("/api/v1/tasks/delete", handler.DeleteAllTasks) // Удаление всех задач
-    http.HandleFunc("/api/v1/expressions/", handler.GetExpressionByID)
+    http.HandleFunc("/api/v1/task/heartbeat", handler.TaskHeartbeat) // Heartbeat агента (проверка отмены задачи)
+    http.HandleFunc("/api/v1/expressions/stream", handler.StreamTaskEvents) // Поток событий задач (SSE)
+    http.HandleFunc("/api/v1/expressions/", handler.ExpressionByID)   // GET — получить, DELETE — отменить задачу
     http.HandleFunc("/api/v1/expressions", handler.GetAllExpressions)
+    http.HandleFunc("/api/v1/schedules", handler.GetAllSchedules)   // Список расписаний
+    http.HandleFunc("/api/v1/schedules/", handler.ScheduleByID)     // GET — расписание с историей, DELETE — остановить
+    http.HandleFunc("/api/v1/callbacks/dead-letters", handler.GetDeadLetters) // Недоставленные обратные вызовы
+    http.HandleFunc("/api/v1/metrics", handler.GetMetrics)          // Метрики (отказы, глубина очереди)
 
     // Запуск сервера
     fmt.Println("Сервер Оркестратора запущен на http://localhost:8080")
@@ -0,0 +1,197 @@
+package handler
+
+import (
+    "container/list"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// cacheEntry — результат вычисления нормализованного выражения.
+type cacheEntry struct {
+    Key string
+    TaskResult
+    Expires time.Time
+}
+
+// CacheStats — статистика кеша результатов.
+type CacheStats struct {
+    Hits      int     `json:"hits"`      // результат взят из кеша
+    Misses    int     `json:"misses"`    // выражение отправлено агентам
+    Coalesced int     `json:"coalesced"` // задача присоединена к уже вычисляемому выражению
+    Size      int     `json:"size"`
+    HitRatio  float64 `json:"hit_ratio"` // (hits + coalesced) / все запросы
+}
+
+var (
+    // LRU-кеш результатов: элементы списка — *cacheEntry, в начале самые свежие. Доступ под TaskMutex.
+    cacheList  = list.New()
+    cacheIndex = make(map[string]*list.Element)
+    cacheStats CacheStats
+
+    // Вычисляемые прямо сейчас выражения: ключ → ID задачи-лидера в очереди.
+    inflightTasks = make(map[string]int)
+    // Задачи, ожидающие результата лидера: ID лидера → задачи с тем же выражением.
+    coalescedTasks = make(map[int][]Task)
+)
+//_______________________________________________________________________________________________________________________________
+
+// expressionKey возвращает ключ кеша: нормализованное выражение и значения переменных, упорядоченные по имени.
+func expressionKey(ast calculation.Node, variables map[string]float64) string {
+    return calculation.PrintAST(ast) + variablesKey(variables)
+}
+//_______________________________________________________________________________________________________________________________
+
+// programKey возвращает ключ кеша программы: нормализованные инструкции, числа и списки из variables.
+func programKey(program *calculation.Program, variables map[string]float64, lists map[string][]float64) string {
+    key := "program " + program.String() + variablesKey(variables)
+    if len(lists) > 0 {
+        data, _ := json.Marshal(lists) // ключи map записываются по порядку
+        key += " lists " + string(data)
+    }
+    return key
+}
+//_______________________________________________________________________________________________________________________________
+
+// variablesKey записывает значения переменных, упорядоченные по имени: " where x=1, y=2".
+func variablesKey(variables map[string]float64) string {
+    key := ""
+    names := make([]string, 0, len(variables))
+    for name := range variables {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for i, name := range names {
+        if i == 0 {
+            key += " where "
+        } else {
+            key += ", "
+        }
+        key += name + "=" + strconv.FormatFloat(variables[name], 'g', -1, 64)
+    }
+    return key
+}
+//_______________________________________________________________________________________________________________________________
+
+// cacheGet возвращает результат из кеша, если он есть и не устарел. Вызывается под TaskMutex.
+func cacheGet(key string, now time.Time) (cacheEntry, bool) {
+    element, exists := cacheIndex[key]
+    if !exists {
+        return cacheEntry{}, false
+    }
+    entry := element.Value.(*cacheEntry)
+    if now.After(entry.Expires) {
+        cacheList.Remove(element)
+        delete(cacheIndex, key)
+        return cacheEntry{}, false
+    }
+    cacheList.MoveToFront(element)
+    return *entry, true
+}
+//_______________________________________________________________________________________________________________________________
+
+// cachePut сохраняет результат задачи и вытесняет самые старые записи сверх ResultCacheSize. Вызывается под TaskMutex.
+func cachePut(key string, task Task, now time.Time) {
+    if key == "" || ResultCacheSize <= 0 {
+        return
+    }
+    if element, exists := cacheIndex[key]; exists {
+        entry := element.Value.(*cacheEntry)
+        entry.TaskResult = task.TaskResult
+        entry.Expires = now.Add(ResultCacheTTL)
+        cacheList.MoveToFront(element)
+        return
+    }
+
+    cacheIndex[key] = cacheList.PushFront(&cacheEntry{Key: key, TaskResult: task.TaskResult, Expires: now.Add(ResultCacheTTL)})
+    for cacheList.Len() > ResultCacheSize {
+        oldest := cacheList.Back()
+        cacheList.Remove(oldest)
+        delete(cacheIndex, oldest.Value.(*cacheEntry).Key)
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// ResetResultCache очищает кеш результатов и его статистику.
+func ResetResultCache() {
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+
+    cacheList = list.New()
+    cacheIndex = make(map[string]*list.Element)
+    cacheStats = CacheStats{}
+}
+//_______________________________________________________________________________________________________________________________
+
+// inflightLeader возвращает ID задачи, которая сейчас вычисляет то же выражение. Вызывается под TaskMutex.
+func inflightLeader(key string) (int, bool) {
+    leaderID, exists := inflightTasks[key]
+    if !exists {
+        return 0, false
+    }
+    // Лидер уже завершён или отменён — присоединяться не к чему
+    if _, finished := CompletedTasks[leaderID]; finished {
+        delete(inflightTasks, key)
+        return 0, false
+    }
+    return leaderID, true
+}
+//_______________________________________________________________________________________________________________________________
+
+// completeTask сохраняет завершённую (или проваленную) задачу в истории, кладёт результат в кеш
+// и завершает все задачи, ожидавшие этого же выражения. Вызывается под TaskMutex.
+func completeTask(task Task) {
+    recordCompletion(task)
+
+    if task.cacheKey == "" {
+        return
+    }
+    // В кеш попадают только успешные результаты, ошибку стоит пересчитать при следующем запросе
+    if task.Status == "completed" {
+        cachePut(task.cacheKey, task, time.Now())
+    }
+    if inflightTasks[task.cacheKey] == task.ID {
+        delete(inflightTasks, task.cacheKey)
+    }
+
+    for _, follower := range coalescedTasks[task.ID] {
+        follower.TaskResult = task.TaskResult
+        follower.Status = task.Status
+        follower.Error = task.Error
+        recordCompletion(follower)
+        fmt.Printf("Задача ID=%d завершена вместе с задачей ID=%d\n", follower.ID, task.ID)
+    }
+    delete(coalescedTasks, task.ID)
+}
+//_______________________________________________________________________________________________________________________________
+
+// recordCompletion сохраняет задачу в конечном состоянии в истории, публикует событие, будит
+// ожидающих её клиентов, обновляет историю расписания и отправляет обратный вызов. Вызывается под TaskMutex.
+func recordCompletion(task Task) {
+    CompletedTasks[task.ID] = task
+    publishEvent(task.Status, task)
+    notifyWaiters(task.ID)
+    if task.ScheduleID != 0 {
+        recordScheduleRun(task)
+    }
+    if task.ParentID != 0 {
+        recordPart(task)
+    }
+    notifyCallback(task)
+}
+//_______________________________________________________________________________________________________________________________
+
+// currentCacheStats возвращает статистику кеша. Вызывается под TaskMutex.
+func currentCacheStats() CacheStats {
+    stats := cacheStats
+    stats.Size = cacheList.Len()
+    if total := stats.Hits + stats.Misses + stats.Coalesced; total > 0 {
+        stats.HitRatio = float64(stats.Hits+stats.Coalesced) / float64(total)
+    }
+    return stats
+}
+//_______________________________________________________________________________________________________________________________
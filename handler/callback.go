@@ -0,0 +1,201 @@
+package handler
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// DeadLetter — обратный вызов, который так и не удалось доставить.
+type DeadLetter struct {
+    TaskID    int             `json:"task_id"`
+    URL       string          `json:"callback_url"`
+    Attempts  int             `json:"attempts"`
+    LastError string          `json:"last_error"`
+    Payload   json.RawMessage `json:"payload"`
+    FailedAt  time.Time       `json:"failed_at"`
+}
+
+var (
+    DeadLetters   []DeadLetter
+    CallbackMutex sync.Mutex
+
+    callbackClient = &http.Client{
+        Timeout:   10 * time.Second,
+        Transport: &http.Transport{DialContext: dialCallback},
+    }
+    callbackWG     sync.WaitGroup // незавершённые доставки (для тестов и остановки сервера)
+)
+//_______________________________________________________________________________________________________________________________
+
+// checkCallbackURL проверяет адрес обратного вызова: абсолютный http(s) URL, узел которого
+// не указывает на внутренние адреса (см. forbiddenCallbackIP), если он не в CallbackAllowedHosts.
+// Без CallbackSecret обратные вызовы не принимаются: подпись с пустым ключом ничего не защищает.
+func checkCallbackURL(raw string) error {
+    if CallbackSecret == "" {
+        return errors.New("обратные вызовы отключены: не задан CALLBACK_SECRET")
+    }
+    parsed, err := url.Parse(raw)
+    if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+        return errors.New("callback_url должен быть абсолютным http(s) адресом")
+    }
+    host := parsed.Hostname()
+    if CallbackAllowedHosts[host] {
+        return nil
+    }
+    ips, err := net.LookupIP(host)
+    if err != nil || len(ips) == 0 {
+        return fmt.Errorf("не удалось определить адрес %s", host)
+    }
+    for _, ip := range ips {
+        if forbiddenCallbackIP(ip) {
+            return fmt.Errorf("адрес %s (%s) — внутренний, обратные вызовы на него запрещены", host, ip)
+        }
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// forbiddenCallbackIP — адреса, на которые обратные вызовы не отправляются: loopback, link-local
+// (в том числе метаданные облака 169.254.169.254), частные сети, multicast и 0.0.0.0.
+func forbiddenCallbackIP(ip net.IP) bool {
+    return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() ||
+        ip.IsMulticast() || ip.IsUnspecified()
+}
+//_______________________________________________________________________________________________________________________________
+
+// dialCallback соединяется с получателем обратного вызова. Адрес проверяется в момент соединения:
+// имя могло начать указывать на внутренний адрес уже после проверки callback_url (DNS rebinding).
+func dialCallback(ctx context.Context, network, address string) (net.Conn, error) {
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    if host, _, err := net.SplitHostPort(address); err != nil || !CallbackAllowedHosts[host] {
+        dialer.Control = func(network, address string, _ syscall.RawConn) error {
+            host, _, err := net.SplitHostPort(address)
+            if ip := net.ParseIP(host); err != nil || ip == nil || forbiddenCallbackIP(ip) {
+                return fmt.Errorf("адрес %s — внутренний, обратные вызовы на него запрещены", address)
+            }
+            return nil
+        }
+    }
+    return dialer.DialContext(ctx, network, address)
+}
+//_______________________________________________________________________________________________________________________________
+
+// signPayload возвращает подпись тела запроса: "sha256=" + HMAC-SHA256(CallbackSecret, body) в hex.
+func signPayload(body []byte) string {
+    mac := hmac.New(sha256.New, []byte(CallbackSecret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+//_______________________________________________________________________________________________________________________________
+
+// notifyCallback запускает доставку результата задачи на её callback_url. Вызывается под TaskMutex,
+// сама доставка идёт в отдельной горутине.
+func notifyCallback(task Task) {
+    if task.CallbackURL == "" || (task.Status != "completed" && task.Status != "failed") {
+        return
+    }
+    if CallbackSecret == "" {
+        fmt.Printf("Обратный вызов не отправлен: ID=%d, не задан CALLBACK_SECRET\n", task.ID)
+        return
+    }
+    payload, err := json.Marshal(task)
+    if err != nil {
+        fmt.Println("Ошибка подготовки обратного вызова:", err)
+        return
+    }
+
+    callbackWG.Add(1)
+    go func() {
+        defer callbackWG.Done()
+        deliverCallback(task.ID, task.CallbackURL, payload)
+    }()
+}
+//_______________________________________________________________________________________________________________________________
+
+// deliverCallback отправляет POST с результатом, повторяя попытки с экспоненциальной задержкой.
+// После CallbackMaxAttempts неудачных попыток запись попадает в DeadLetters.
+func deliverCallback(taskID int, callbackURL string, payload []byte) {
+    delay := CallbackBaseDelay
+    var lastErr error
+
+    for attempt := 1; attempt <= CallbackMaxAttempts; attempt++ {
+        lastErr = postCallback(callbackURL, payload)
+        if lastErr == nil {
+            fmt.Printf("Обратный вызов доставлен: ID=%d, URL=%s, попытка %d\n", taskID, callbackURL, attempt)
+            return
+        }
+        fmt.Printf("Ошибка обратного вызова: ID=%d, URL=%s, попытка %d: %v\n", taskID, callbackURL, attempt, lastErr)
+
+        if attempt < CallbackMaxAttempts {
+            time.Sleep(delay)
+            delay *= 2
+        }
+    }
+
+    CallbackMutex.Lock()
+    defer CallbackMutex.Unlock()
+    DeadLetters = append(DeadLetters, DeadLetter{
+        TaskID:    taskID,
+        URL:       callbackURL,
+        Attempts:  CallbackMaxAttempts,
+        LastError: lastErr.Error(),
+        Payload:   payload,
+        FailedAt:  time.Now(),
+    })
+}
+//_______________________________________________________________________________________________________________________________
+
+// postCallback делает одну попытку доставки. Успехом считается любой ответ 2xx.
+func postCallback(callbackURL string, payload []byte) error {
+    req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Signature", signPayload(payload))
+
+    resp, err := callbackClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("получен статус %d", resp.StatusCode)
+    }
+    return nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// WaitCallbacks ждёт окончания всех начатых доставок обратных вызовов.
+func WaitCallbacks() {
+    callbackWG.Wait()
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для получения недоставленных обратных вызовов
+func GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+    CallbackMutex.Lock()
+    defer CallbackMutex.Unlock()
+
+    deadLetters := DeadLetters
+    if deadLetters == nil {
+        deadLetters = []DeadLetter{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string][]DeadLetter{"dead_letters": deadLetters})
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,193 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// Эндпоинт для выражения по ID: GET — получить, DELETE — отменить
+func ExpressionByID(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        GetExpressionByID(w, r)
+    case http.MethodDelete:
+        CancelTask(w, r)
+    default:
+        w.Header().Set("Allow", "GET, DELETE")
+        http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed) // 405
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для отмены задачи
+func CancelTask(w http.ResponseWriter, r *http.Request) {
+    idStr := r.URL.Path[len("/api/v1/expressions/"):] // Парсим ID из URL
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        http.Error(w, "Некорректный идентификатор", http.StatusBadRequest) // 400
+        return
+    }
+
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+
+    task, found := cancelTask(id)
+    if !found {
+        if finished, exists := CompletedTasks[id]; exists {
+            fmt.Printf("Задачу ID=%d нельзя отменить: статус %s\n", id, finished.Status)
+            http.Error(w, "Задача уже завершена или отменена", http.StatusConflict) // 409
+            return
+        }
+        http.Error(w, "Выражение не найдено", http.StatusNotFound) // 404
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]Task{"expression": task})
+}
+//_______________________________________________________________________________________________________________________________
+
+// cancelTask снимает задачу с выполнения и переносит её в историю со статусом "cancelled".
+// Возвращает false, если среди незавершённых задач её нет. Вызывается под TaskMutex.
+func cancelTask(id int) (Task, bool) {
+    var task Task
+    found := false
+
+    // Задача, разбитая на части: отменяются все её незавершённые части
+    if parted, exists := PartedTasks[id]; exists {
+        delete(PartedTasks, id)
+        parted.Task.Status = "cancelled"
+        CompletedTasks[id] = parted.Task
+        publishEvent("cancelled", parted.Task)
+        notifyWaiters(id)
+        cancelParts(parted.Task)
+        fmt.Printf("Задача отменена вместе с частями: ID=%d\n", id)
+        return parted.Task, true
+    }
+
+    if inProgress, exists := InProgressTasks[id]; exists {
+        // Задача уже у агента: он узнает об отмене при следующем heartbeat
+        task, found = inProgress, true
+        delete(InProgressTasks, id)
+    } else if scheduled, exists := ScheduledTasks[id]; exists {
+        // Отложенная задача ещё не попала в очередь
+        task, found = scheduled, true
+        delete(ScheduledTasks, id)
+    } else {
+        // Задача ждёт агента в очереди
+        for i := range TaskQueue {
+            if TaskQueue[i].ID == id {
+                task, found = removeQueuedTask(i), true
+                break
+            }
+        }
+    }
+
+    if found {
+        fmt.Printf("Задача отменена: ID=%d, прежний статус=%s\n", id, task.Status)
+        task.Status = "cancelled"
+        CompletedTasks[id] = task
+        publishEvent("cancelled", task)
+        notifyWaiters(id)
+
+        // Если к задаче присоединены другие с тем же выражением, первая из них занимает её место в очереди
+        if followers := coalescedTasks[id]; len(followers) > 0 {
+            leader := followers[0]
+            leader.virtualFinish = task.virtualFinish
+            pushQueuedTask(leader)
+            inflightTasks[leader.cacheKey] = leader.ID
+            if len(followers) > 1 {
+                coalescedTasks[leader.ID] = followers[1:]
+            }
+            delete(coalescedTasks, id)
+            fmt.Printf("Задача ID=%d заменила отменённую задачу ID=%d в очереди\n", leader.ID, id)
+        } else if inflightTasks[task.cacheKey] == id {
+            delete(inflightTasks, task.cacheKey)
+        }
+        if task.ParentID != 0 {
+            recordPart(task)
+        }
+        return task, true
+    }
+
+    // Задача ждёт результата другой задачи с тем же выражением
+    for leaderID, followers := range coalescedTasks {
+        for i, task := range followers {
+            if task.ID != id {
+                continue
+            }
+            task.Status = "cancelled"
+            CompletedTasks[id] = task
+            publishEvent("cancelled", task)
+            notifyWaiters(id)
+            coalescedTasks[leaderID] = append(followers[:i], followers[i+1:]...)
+            fmt.Printf("Задача отменена: ID=%d (ожидала задачу ID=%d)\n", id, leaderID)
+            if task.ParentID != 0 {
+                recordPart(task)
+            }
+            return task, true
+        }
+    }
+
+    return Task{}, false
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для heartbeat агента: агент сообщает, что всё ещё вычисляет задачу,
+// и узнаёт, не была ли она отменена
+func TaskHeartbeat(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        ID int `json:"id"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+        http.Error(w, "Некорректные данные задачи", http.StatusBadRequest) // 400
+        return
+    }
+
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+
+    task, exists := findTask(request.ID)
+    if !exists {
+        http.NotFound(w, r)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "id":        task.ID,
+        "status":    task.Status,
+        "cancelled": task.Status == "cancelled",
+    })
+}
+//_______________________________________________________________________________________________________________________________
+
+// findTask ищет задачу в любом состоянии. Вызывается под TaskMutex.
+func findTask(id int) (Task, bool) {
+    if task, exists := InProgressTasks[id]; exists {
+        return task, true
+    }
+    if task, exists := ScheduledTasks[id]; exists {
+        return task, true
+    }
+    for _, task := range TaskQueue {
+        if task.ID == id {
+            return task, true
+        }
+    }
+    for _, followers := range coalescedTasks {
+        for _, task := range followers {
+            if task.ID == id {
+                return task, true
+            }
+        }
+    }
+    if parted, exists := PartedTasks[id]; exists {
+        return parted.Task, true
+    }
+    task, exists := CompletedTasks[id]
+    return task, exists
+}
+//_______________________________________________________________________________________________________________________________
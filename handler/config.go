@@ -0,0 +1,195 @@
+package handler
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Настройки оркестратора. Значения по умолчанию можно переопределить
+// переменными окружения через LoadConfig (см. README).
+var (
+    RateLimitPerSecond  = 10.0  // Скорость пополнения токенов одного клиента (запросов в секунду)
+    RateLimitBurst      = 50    // Ёмкость "ведра" токенов одного клиента
+    MaxQueueDepth       = 1000  // Максимальное число задач в очереди TaskQueue
+    MaxWaitingTasks     = 1000  // Максимальное число задач вне очереди: отложенных, расписаний и ждущих такое же выражение
+    MaxExpressionLength = 1000  // Максимальная длина выражения в символах
+    MaxBodyOverhead     = 65536 // Сколько байт тела запроса допускается сверх выражения (переменные и остальные поля)
+    MaxASTDepth         = 100   // Максимальная глубина AST выражения
+    QueueRetryAfter     = 5     // Значение заголовка Retry-After (в секундах) при переполненной очереди
+
+    // Ключи API, по которым различаются клиенты (заголовок X-API-Key). С другим ключом клиент определяется по IP.
+    APIKeys = map[string]bool{}
+
+    IdempotencyRetention = 24 * time.Hour // Сколько хранится соответствие Idempotency-Key → ID задачи
+
+    ResultCacheSize = 1000             // Максимальное число результатов в кеше (0 — кеш выключен)
+    ResultCacheTTL  = 10 * time.Minute // Время жизни результата в кеше
+
+    // Веса владельцев в справедливой очереди (по умолчанию у всех 1).
+    // Ключ — значение поля owner задачи, например "key:9f86d081884c7d65" или "ip:10.0.0.5".
+    OwnerWeights = map[string]float64{}
+
+    SchedulerInterval    = time.Second // Как часто проверяются отложенные задачи и расписания
+    ScheduleHistoryLimit = 100         // Сколько последних запусков хранится в истории расписания
+
+    CallbackSecret      = ""          // Ключ HMAC-подписи обратных вызовов (заголовок X-Signature); пустой — обратные вызовы отключены
+    CallbackMaxAttempts = 5           // Сколько раз пытаться доставить обратный вызов
+    CallbackBaseDelay   = time.Second // Задержка перед второй попыткой, дальше удваивается
+
+    // Узлы, на которые обратные вызовы разрешены, даже если это внутренние адреса ("hooks.internal", "127.0.0.1").
+    // Остальные callback_url не могут указывать на loopback, link-local и частные сети.
+    CallbackAllowedHosts = map[string]bool{}
+
+    EventHistorySize = 1000 // Сколько последних событий хранится для возобновления потока по Last-Event-ID
+
+    MaxWaitTimeout = time.Minute // Максимальное время ожидания в GET /api/v1/expressions/{id}?wait=
+
+    EvaluateEnabled = true // Разрешено ли синхронное вычисление через POST /api/v1/evaluate
+
+    MaxTaskParts = 64 // Наибольшее число частей, на которые можно разбить integrate, sum или matmul (поле parts)
+
+    MaxPlotSamples  = 100000 // Наибольшее число точек графика
+    PlotPartSamples = 1000   // Сколько точек графика вычисляется сразу или одной задачей агента
+)
+//_______________________________________________________________________________________________________________________________
+
+// LoadConfig читает настройки из переменных окружения.
+// Некорректные значения игнорируются, остаются значения по умолчанию.
+func LoadConfig() {
+    loadFloatEnv("RATE_LIMIT_RPS", &RateLimitPerSecond)
+    loadIntEnv("RATE_LIMIT_BURST", &RateLimitBurst)
+    loadIntEnv("MAX_QUEUE_DEPTH", &MaxQueueDepth)
+    loadIntEnv("MAX_WAITING_TASKS", &MaxWaitingTasks)
+    loadIntEnv("MAX_EXPRESSION_LENGTH", &MaxExpressionLength)
+    loadIntEnv("MAX_BODY_OVERHEAD", &MaxBodyOverhead)
+    loadIntEnv("MAX_AST_DEPTH", &MaxASTDepth)
+    loadIntEnv("QUEUE_RETRY_AFTER", &QueueRetryAfter)
+    loadSetEnv("API_KEYS", APIKeys)
+    loadDurationEnv("IDEMPOTENCY_RETENTION", &IdempotencyRetention)
+    loadIntEnv("RESULT_CACHE_SIZE", &ResultCacheSize)
+    loadDurationEnv("RESULT_CACHE_TTL", &ResultCacheTTL)
+    loadWeightsEnv("OWNER_WEIGHTS", OwnerWeights)
+    loadDurationEnv("SCHEDULER_INTERVAL", &SchedulerInterval)
+    loadIntEnv("SCHEDULE_HISTORY_LIMIT", &ScheduleHistoryLimit)
+    loadStringEnv("CALLBACK_SECRET", &CallbackSecret)
+    loadIntEnv("CALLBACK_MAX_ATTEMPTS", &CallbackMaxAttempts)
+    loadDurationEnv("CALLBACK_BASE_DELAY", &CallbackBaseDelay)
+    loadSetEnv("CALLBACK_ALLOWED_HOSTS", CallbackAllowedHosts)
+    loadIntEnv("EVENT_HISTORY_SIZE", &EventHistorySize)
+    loadDurationEnv("MAX_WAIT_TIMEOUT", &MaxWaitTimeout)
+    loadBoolEnv("EVALUATE_ENABLED", &EvaluateEnabled)
+    loadIntEnv("MAX_TASK_PARTS", &MaxTaskParts)
+    loadIntEnv("MAX_PLOT_SAMPLES", &MaxPlotSamples)
+    loadIntEnv("PLOT_PART_SAMPLES", &PlotPartSamples)
+    if CallbackSecret == "" {
+        fmt.Println("Внимание: CALLBACK_SECRET не задан, обратные вызовы (callback_url) отключены")
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadStringEnv записывает в target значение переменной окружения name, если оно задано.
+func loadStringEnv(name string, target *string) {
+    if raw, ok := os.LookupEnv(name); ok {
+        *target = raw
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadBoolEnv записывает в target логическое значение переменной окружения name ("true", "false", "1", "0").
+func loadBoolEnv(name string, target *bool) {
+    raw, ok := os.LookupEnv(name)
+    if !ok {
+        return
+    }
+    value, err := strconv.ParseBool(raw)
+    if err != nil {
+        fmt.Printf("Некорректное значение %s=%q: %v\n", name, raw, err)
+        return
+    }
+    *target = value
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadIntEnv записывает в target целое значение переменной окружения name, если оно задано.
+func loadIntEnv(name string, target *int) {
+    raw, ok := os.LookupEnv(name)
+    if !ok {
+        return
+    }
+    value, err := strconv.Atoi(raw)
+    if err != nil {
+        fmt.Printf("Некорректное значение %s=%q: %v\n", name, raw, err)
+        return
+    }
+    *target = value
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadFloatEnv записывает в target дробное значение переменной окружения name, если оно задано.
+func loadFloatEnv(name string, target *float64) {
+    raw, ok := os.LookupEnv(name)
+    if !ok {
+        return
+    }
+    value, err := strconv.ParseFloat(raw, 64)
+    if err != nil {
+        fmt.Printf("Некорректное значение %s=%q: %v\n", name, raw, err)
+        return
+    }
+    *target = value
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadDurationEnv записывает в target длительность из переменной окружения name (например, "30s", "24h").
+func loadDurationEnv(name string, target *time.Duration) {
+    raw, ok := os.LookupEnv(name)
+    if !ok {
+        return
+    }
+    value, err := time.ParseDuration(raw)
+    if err != nil {
+        fmt.Printf("Некорректное значение %s=%q: %v\n", name, raw, err)
+        return
+    }
+    *target = value
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadWeightsEnv читает веса владельцев из переменной окружения вида "owner=2,owner2=0.5".
+func loadWeightsEnv(name string, target map[string]float64) {
+    raw, ok := os.LookupEnv(name)
+    if !ok {
+        return
+    }
+    for _, pair := range strings.Split(raw, ",") {
+        separator := strings.LastIndex(pair, "=")
+        if separator <= 0 {
+            fmt.Printf("Некорректное значение %s: %q\n", name, pair)
+            continue
+        }
+        weight, err := strconv.ParseFloat(pair[separator+1:], 64)
+        if err != nil || weight <= 0 {
+            fmt.Printf("Некорректный вес в %s: %q\n", name, pair)
+            continue
+        }
+        target[strings.TrimSpace(pair[:separator])] = weight
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// loadSetEnv читает множество значений из переменной окружения вида "a,b,c".
+func loadSetEnv(name string, target map[string]bool) {
+    raw, ok := os.LookupEnv(name)
+    if !ok {
+        return
+    }
+    for _, value := range strings.Split(raw, ",") {
+        if value = strings.TrimSpace(value); value != "" {
+            target[value] = true
+        }
+    }
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,155 @@
+package handler
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// cronSpec — разобранное расписание в формате cron: "минута час день месяц день_недели".
+// Каждое поле хранится как битовая маска допустимых значений.
+type cronSpec struct {
+    minute, hour, dom, month, dow uint64
+    domAny, dowAny                bool          // поле задано как "*" или "*/n"
+    every                         time.Duration // для расписаний вида "@every 15m"
+}
+
+// Сокращения для распространённых расписаний.
+var cronMacros = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseCron разбирает расписание: пять полей cron, сокращение (@hourly, @daily, ...) или "@every <длительность>".
+func parseCron(spec string) (*cronSpec, error) {
+    spec = strings.TrimSpace(spec)
+    if strings.HasPrefix(spec, "@every ") {
+        every, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+        if err != nil || every < time.Second {
+            return nil, fmt.Errorf("некорректный интервал в расписании %q", spec)
+        }
+        return &cronSpec{every: every}, nil
+    }
+    if macro, ok := cronMacros[spec]; ok {
+        spec = macro
+    }
+
+    fields := strings.Fields(spec)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("расписание %q должно содержать 5 полей", spec)
+    }
+
+    c := &cronSpec{domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*")}
+    var err error
+    if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+        return nil, err
+    }
+    if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+        return nil, err
+    }
+    if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+        return nil, err
+    }
+    if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+        return nil, err
+    }
+    if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+        return nil, err
+    }
+    // Воскресенье можно записать и как 0, и как 7
+    if c.dow&(1<<7) != 0 {
+        c.dow |= 1
+    }
+    return c, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseCronField разбирает одно поле: "*", "5", "1-5", "*/15", "0-30/10" или их список через запятую.
+func parseCronField(field string, min, max int) (uint64, error) {
+    var bits uint64
+    for _, part := range strings.Split(field, ",") {
+        step := 1
+        if slash := strings.Index(part, "/"); slash >= 0 {
+            var err error
+            step, err = strconv.Atoi(part[slash+1:])
+            if err != nil || step <= 0 {
+                return 0, fmt.Errorf("некорректный шаг в поле расписания %q", field)
+            }
+            part = part[:slash]
+        }
+
+        low, high := min, max
+        if part != "*" {
+            bounds := strings.SplitN(part, "-", 2)
+            var err error
+            if low, err = strconv.Atoi(bounds[0]); err != nil {
+                return 0, fmt.Errorf("некорректное значение в поле расписания %q", field)
+            }
+            high = low
+            if len(bounds) == 2 {
+                if high, err = strconv.Atoi(bounds[1]); err != nil {
+                    return 0, fmt.Errorf("некорректное значение в поле расписания %q", field)
+                }
+            }
+        }
+        if low < min || high > max || low > high {
+            return 0, fmt.Errorf("значение поля расписания %q вне диапазона %d-%d", field, min, max)
+        }
+
+        for value := low; value <= high; value += step {
+            bits |= 1 << uint(value)
+        }
+    }
+    return bits, nil
+}
+//_______________________________________________________________________________________________________________________________
+
+// next возвращает ближайший момент запуска строго после after.
+func (c *cronSpec) next(after time.Time) time.Time {
+    if c.every > 0 {
+        return after.Add(c.every)
+    }
+
+    t := after.Truncate(time.Minute).Add(time.Minute)
+    // Ограничиваем поиск пятью годами: расписание вроде "0 0 30 2 *" не наступит никогда
+    limit := t.AddDate(5, 0, 0)
+    for t.Before(limit) {
+        if c.month&(1<<uint(t.Month())) == 0 {
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if !c.dayMatches(t) {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if c.hour&(1<<uint(t.Hour())) == 0 {
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+            continue
+        }
+        if c.minute&(1<<uint(t.Minute())) == 0 {
+            t = t.Add(time.Minute)
+            continue
+        }
+        return t
+    }
+    return time.Time{}
+}
+//_______________________________________________________________________________________________________________________________
+
+// dayMatches проверяет день месяца и день недели. Как в классическом cron,
+// если заданы оба поля, достаточно совпадения любого из них.
+func (c *cronSpec) dayMatches(t time.Time) bool {
+    domMatch := c.dom&(1<<uint(t.Day())) != 0
+    dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+    if c.domAny || c.dowAny {
+        return domMatch && dowMatch
+    }
+    return domMatch || dowMatch
+}
+//_______________________________________________________________________________________________________________________________
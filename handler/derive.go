@@ -0,0 +1,87 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "regexp"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// Имя переменной: буква или подчёркивание, затем буквы, цифры и подчёркивания
+var IdentRegex = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// DeriveResponse — производная выражения.
+type DeriveResponse struct {
+    Expression string   `json:"expression"`
+    Variable   string   `json:"variable"`
+    Derivative string   `json:"derivative"`
+    Value      *float64 `json:"value,omitempty"` // значение производной в точке variables, если она задана
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для символьного дифференцирования выражения по переменной
+func DeriveExpression(w http.ResponseWriter, r *http.Request) {
+    request, ok := readExpression(w, r)
+    if !ok {
+        return
+    }
+    if !IdentRegex.MatchString(request.Variable) {
+        fmt.Println("Ошибка: некорректная переменная дифференцирования:", request.Variable)
+        http.Error(w, "Некорректная переменная дифференцирования", http.StatusUnprocessableEntity) // 422
+        return
+    }
+
+    ast, status, message := parseExpression(request.Expression)
+    if status != 0 {
+        http.Error(w, message, status)
+        return
+    }
+
+    // Без точки вычисления переменные остаются символами: проверяем только функции
+    env := request.Variables
+    if len(env) == 0 {
+        env = make(map[string]float64)
+        for _, name := range calculation.Variables(ast) {
+            env[name] = 0
+        }
+    }
+    if err := calculation.Validate(ast, env); err != nil {
+        fmt.Println("Ошибка проверки выражения:", err)
+        http.Error(w, "Некорректное выражение: "+err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    if status, message := requireNumber(ast); status != 0 {
+        http.Error(w, message, status)
+        return
+    }
+
+    derivative, err := calculation.Derive(ast, request.Variable)
+    if err != nil {
+        fmt.Println("Ошибка дифференцирования:", err)
+        http.Error(w, "Ошибка дифференцирования: "+err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    response := DeriveResponse{
+        Expression: request.Expression,
+        Variable:   request.Variable,
+        Derivative: calculation.Format(derivative),
+    }
+
+    // Значение производной в точке
+    if len(request.Variables) > 0 {
+        value, err := calculation.Eval(derivative, request.Variables)
+        if err != nil {
+            fmt.Println("Ошибка вычисления производной:", err)
+            http.Error(w, "Ошибка вычисления производной: "+err.Error(), http.StatusUnprocessableEntity)
+            return
+        }
+        response.Value = &value
+    }
+    fmt.Printf("Производная: d/d%s %s = %s\n", request.Variable, request.Expression, response.Derivative)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,195 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// EvaluateResponse — результат синхронного вычисления.
+type EvaluateResponse struct {
+    Expression     string                `json:"expression"`
+    Result         float64               `json:"result"`
+    Boolean        bool                  `json:"-"` // результат — логическое значение: result — true или false
+    Array          json.RawMessage       `json:"-"` // результат — вектор или матрица: result — вложенные массивы
+    AST            string                `json:"ast"`
+    Tree           *calculation.JSONNode `json:"tree"`
+    EvaluationTime int64                 `json:"evaluation_time_ns"` // время вычисления в наносекундах
+}
+
+// MarshalJSON записывает результат сравнения или условия как true или false, вектора и матрицы —
+// как вложенные массивы.
+func (r EvaluateResponse) MarshalJSON() ([]byte, error) {
+    type plain EvaluateResponse
+    if r.Array != nil {
+        return json.Marshal(struct {
+            plain
+            Result json.RawMessage `json:"result"`
+        }{plain(r), r.Array})
+    }
+    if !r.Boolean {
+        return json.Marshal(plain(r))
+    }
+    return json.Marshal(struct {
+        plain
+        Result bool `json:"result"`
+    }{plain(r), r.Result != 0})
+}
+
+// UnmarshalJSON читает результат: число, логическое значение (хранится как 1 или 0) или вложенные массивы.
+func (r *EvaluateResponse) UnmarshalJSON(data []byte) error {
+    type plain EvaluateResponse
+    var decoded struct {
+        plain
+        Result json.RawMessage `json:"result"`
+    }
+    if err := json.Unmarshal(data, &decoded); err != nil {
+        return err
+    }
+    *r = EvaluateResponse(decoded.plain)
+    switch string(decoded.Result) {
+    case "", "null":
+        return nil
+    case "true", "false":
+        r.Boolean = true
+        if string(decoded.Result) == "true" {
+            r.Result = 1
+        }
+        return nil
+    }
+    if decoded.Result[0] == '[' {
+        r.Array = append(json.RawMessage(nil), decoded.Result...)
+        return nil
+    }
+    return json.Unmarshal(decoded.Result, &r.Result)
+}
+
+// ExpressionRequest — тело запросов /api/v1/evaluate, /api/v1/ast и /api/v1/derive.
+type ExpressionRequest struct {
+    Expression string             `json:"expression"`
+    Variables  map[string]float64 `json:"variables,omitempty"`
+    Variable   string             `json:"variable,omitempty"` // переменная дифференцирования
+}
+
+// ASTResponse — дерево разбора выражения.
+type ASTResponse struct {
+    Expression string                `json:"expression"`
+    Tree       *calculation.JSONNode `json:"tree"`
+}
+//_______________________________________________________________________________________________________________________________
+
+// readExpression проверяет метод и лимит запросов и читает ExpressionRequest из тела запроса.
+// При ошибке ответ уже записан и возвращается false.
+func readExpression(w http.ResponseWriter, r *http.Request) (ExpressionRequest, bool) {
+    var request ExpressionRequest
+    return request, readRequest(w, r, &request)
+}
+//_______________________________________________________________________________________________________________________________
+
+// readRequest проверяет метод (POST) и лимит запросов и декодирует JSON из тела запроса в request.
+// При ошибке ответ уже записан и возвращается false.
+func readRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", "POST")
+        http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed) // 405
+        return false
+    }
+
+    // Ограничение частоты запросов — то же, что и для постановки задач
+    if allowed, wait := allowRequest(ClientKey(r), time.Now()); !allowed {
+        countRejection(&Rejections.RateLimited)
+        fmt.Println("Ошибка: превышен лимит запросов для клиента", ClientKey(r))
+        setRetryAfter(w, wait)
+        http.Error(w, "Слишком много запросов", http.StatusTooManyRequests) // 429
+        return false
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+        fmt.Println("Ошибка декодирования выражения:", err)
+        http.Error(w, "Некорректные данные", http.StatusUnprocessableEntity) // 422
+        return false
+    }
+    return true
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для синхронного вычисления небольших выражений прямо в оркестраторе, без очереди и агентов
+func Evaluate(w http.ResponseWriter, r *http.Request) {
+    if !EvaluateEnabled {
+        http.Error(w, "Синхронное вычисление отключено", http.StatusNotFound) // 404
+        return
+    }
+    request, ok := readExpression(w, r)
+    if !ok {
+        return
+    }
+
+    ast, status, message := validateExpression(request.Expression, request.Variables)
+    if status != 0 {
+        http.Error(w, message, status)
+        return
+    }
+    // Интегралы и длинные суммы заняли бы обработчик запроса без ограничения времени
+    if calculation.UsesSpecialForms(ast) {
+        fmt.Println("Ошибка: integrate и sum не вычисляются синхронно:", request.Expression)
+        http.Error(w, "integrate и sum вычисляются только агентами: отправьте задачу в /api/v1/calculate", http.StatusUnprocessableEntity) // 422
+        return
+    }
+
+    started := time.Now()
+    value, err := calculation.EvalValue(ast, request.Variables)
+    elapsed := time.Since(started)
+    if err != nil {
+        fmt.Println("Ошибка вычисления выражения:", err)
+        http.Error(w, "Ошибка вычисления: "+err.Error(), http.StatusUnprocessableEntity) // 422
+        return
+    }
+
+    result := value.Number
+    if value.Kind == calculation.KindBoolean && value.Boolean {
+        result = 1
+    }
+    var array json.RawMessage
+    if value.Kind == calculation.KindVector || value.Kind == calculation.KindMatrix {
+        if array, err = json.Marshal(value.Interface()); err != nil {
+            fmt.Println("Ошибка записи результата:", err)
+            http.Error(w, "Ошибка при обработке запроса", http.StatusInternalServerError) // 500
+            return
+        }
+    }
+    response := EvaluateResponse{
+        Expression:     request.Expression,
+        Result:         result,
+        Boolean:        value.Kind == calculation.KindBoolean,
+        Array:          array,
+        AST:            calculation.PrintAST(ast),
+        Tree:           calculation.ToJSON(ast),
+        EvaluationTime: elapsed.Nanoseconds(),
+    }
+    fmt.Printf("Синхронное вычисление: %s = %v (%v)\n", request.Expression, value.Interface(), elapsed)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для получения дерева разбора выражения (для отрисовки на фронтенде и отладки приоритетов)
+func InspectAST(w http.ResponseWriter, r *http.Request) {
+    request, ok := readExpression(w, r)
+    if !ok {
+        return
+    }
+
+    ast, status, message := validateExpression(request.Expression, request.Variables)
+    if status != 0 {
+        http.Error(w, message, status)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(ASTResponse{Expression: request.Expression, Tree: calculation.ToJSON(ast)})
+}
+//_______________________________________________________________________________________________________________________________
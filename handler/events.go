@@ -0,0 +1,187 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// TaskEvent — изменение состояния задачи: created, leased, completed, failed или cancelled.
+type TaskEvent struct {
+    ID   int       `json:"-"` // порядковый номер события (поле id в SSE)
+    Type string    `json:"type"`
+    Time time.Time `json:"time"`
+    Task Task      `json:"task"`
+}
+
+var (
+    // Последние события для возобновления потока по Last-Event-ID. Доступ под TaskMutex.
+    recentEvents   []TaskEvent
+    EventIDCounter int
+    // Подписчики потока событий. Доступ под TaskMutex.
+    eventSubscribers = make(map[chan TaskEvent]struct{})
+)
+
+// Размер буфера событий одного подписчика. Если клиент не успевает читать,
+// поток закрывается, и клиент переподключается с Last-Event-ID.
+const subscriberBuffer = 256
+
+// Как часто отправлять комментарий-пинг, чтобы прокси не закрывали соединение.
+const streamPingInterval = 15 * time.Second
+//_______________________________________________________________________________________________________________________________
+
+// publishEvent сохраняет событие и рассылает его подписчикам. Вызывается под TaskMutex.
+func publishEvent(eventType string, task Task) {
+    EventIDCounter++
+    event := TaskEvent{ID: EventIDCounter, Type: eventType, Time: time.Now(), Task: task}
+
+    recentEvents = append(recentEvents, event)
+    if len(recentEvents) > EventHistorySize {
+        recentEvents = recentEvents[len(recentEvents)-EventHistorySize:]
+    }
+
+    for subscriber := range eventSubscribers {
+        select {
+        case subscriber <- event:
+        default:
+            // Подписчик не успевает читать — отключаем его
+            delete(eventSubscribers, subscriber)
+            close(subscriber)
+        }
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// eventFilter отбирает события по ID задач и владельцу.
+type eventFilter struct {
+    ids   map[int]bool
+    owner string
+}
+
+// parseEventFilter читает фильтр из параметров ?id=1,2&id=3&owner=...
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+    filter := eventFilter{owner: r.URL.Query().Get("owner")}
+    for _, value := range r.URL.Query()["id"] {
+        for _, part := range strings.Split(value, ",") {
+            id, err := strconv.Atoi(strings.TrimSpace(part))
+            if err != nil {
+                return filter, fmt.Errorf("некорректный идентификатор %q", part)
+            }
+            if filter.ids == nil {
+                filter.ids = make(map[int]bool)
+            }
+            filter.ids[id] = true
+        }
+    }
+    return filter, nil
+}
+
+func (f eventFilter) matches(event TaskEvent) bool {
+    if f.ids != nil && !f.ids[event.Task.ID] {
+        return false
+    }
+    return f.owner == "" || f.owner == event.Task.Owner
+}
+//_______________________________________________________________________________________________________________________________
+
+// writeEvent записывает событие в формате Server-Sent Events.
+func writeEvent(w http.ResponseWriter, event TaskEvent) error {
+    data, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+    return err
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт потока событий задач (Server-Sent Events)
+func StreamTaskEvents(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError) // 500
+        return
+    }
+
+    filter, err := parseEventFilter(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest) // 400
+        return
+    }
+
+    // Возобновление потока: события с номером больше Last-Event-ID
+    lastEventID := -1
+    if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+        if lastEventID, err = strconv.Atoi(raw); err != nil {
+            http.Error(w, "Некорректный Last-Event-ID", http.StatusBadRequest) // 400
+            return
+        }
+    }
+
+    // Подписка и выборка пропущенных событий под одной блокировкой, чтобы ничего не потерять
+    subscriber := make(chan TaskEvent, subscriberBuffer)
+    TaskMutex.Lock()
+    var backlog []TaskEvent
+    if lastEventID >= 0 {
+        for _, event := range recentEvents {
+            if event.ID > lastEventID {
+                backlog = append(backlog, event)
+            }
+        }
+    }
+    eventSubscribers[subscriber] = struct{}{}
+    TaskMutex.Unlock()
+
+    defer func() {
+        TaskMutex.Lock()
+        if _, subscribed := eventSubscribers[subscriber]; subscribed {
+            delete(eventSubscribers, subscriber)
+            close(subscriber)
+        }
+        TaskMutex.Unlock()
+    }()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.WriteHeader(http.StatusOK)
+
+    for _, event := range backlog {
+        if filter.matches(event) {
+            if writeEvent(w, event) != nil {
+                return
+            }
+        }
+    }
+    flusher.Flush()
+
+    ping := time.NewTicker(streamPingInterval)
+    defer ping.Stop()
+
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case event, open := <-subscriber:
+            if !open {
+                return // Отключены за медленное чтение
+            }
+            if !filter.matches(event) {
+                continue
+            }
+            if writeEvent(w, event) != nil {
+                return
+            }
+            flusher.Flush()
+        case <-ping.C:
+            if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+                return
+            }
+            flusher.Flush()
+        }
+    }
+}
+//_______________________________________________________________________________________________________________________________
@@ -7,17 +7,78 @@ import (
 	"fmt"
 	"encoding/json"
 	"strconv"
+	"time"
+	"io"
+	"crypto/sha256"
+	"strings"
+	"errors"
 
+	"github.com/gulovv/web_calculator/calculation"
 )
 type Task struct {
-    ID         int     `json:"id"`
-    Expression string  `json:"expression"`
-    Result     float64 `json:"result,omitempty"`
-    Status     string  `json:"status"`
+    ID         int    `json:"id"`
+    Expression string `json:"expression"`
+    Status     string `json:"status"`
+    Priority   int    `json:"priority,omitempty"` // 0..MaxPriority, чем больше — тем раньше выполняется среди задач владельца
+    Owner      string `json:"owner,omitempty"`    // владелец задачи (клиент, отправивший выражение)
+
+    RunAt      *time.Time `json:"run_at,omitempty"`      // не ставить в очередь раньше этого времени
+    Delay      string     `json:"delay,omitempty"`       // либо отложить на длительность ("30s", "1h")
+    Schedule   string     `json:"schedule,omitempty"`    // повторять по расписанию cron ("0 * * * *", "@every 1h")
+    ScheduleID int        `json:"schedule_id,omitempty"` // расписание, создавшее задачу
+
+    CallbackURL string `json:"callback_url,omitempty"` // куда отправить результат после завершения
+    Error       string `json:"error,omitempty"`        // причина ошибки для задач со статусом "failed"
+
+    TaskOptions
+    TaskResult
+
+    Parts    int   `json:"parts,omitempty"`     // разбить integrate/sum/matmul на столько задач для разных агентов
+    ParentID int   `json:"parent_id,omitempty"` // задача, частью которой является эта
+    Children []int `json:"children,omitempty"`  // части задачи (для задач с parts)
+
+    cacheKey      string               // нормализованное выражение (ключ кеша результатов)
+    program       *calculation.Program // разобранная программа из нескольких инструкций
+    virtualFinish float64 // виртуальное время окончания в справедливой очереди
+}
+
+// TaskOptions — параметры вычисления из запроса. Расписание хранит их и передаёт каждому запуску.
+type TaskOptions struct {
+    Variables  map[string]float64   `json:"variables,omitempty"`  // значения переменных выражения ("x": 2)
+    Lists      map[string][]float64 `json:"-"`                    // переменные-списки ("data": [1, 2, 3]), в JSON — тоже в variables
+    Simplify   bool                 `json:"simplify,omitempty"`   // упростить выражение перед вычислением
+    Simplified string               `json:"simplified,omitempty"` // упрощённая форма, которую вычисляет агент
+
+    Type     string    `json:"type,omitempty"`     // "" — вычислить выражение, "solve" — решить уравнение, "plot" — построить график
+    Variable string    `json:"variable,omitempty"` // неизвестное уравнения или переменная графика
+    Range    []float64 `json:"range,omitempty"`    // отрезок [min, max] для численного поиска корней или графика
+    Samples  int       `json:"samples,omitempty"`  // число точек графика
+
+    Mode  string `json:"mode,omitempty"`  // "" — действительные числа, "complex" — комплексные (result — {"re", "im"}), "units" — с единицами, "integer" — целые
+    Width string `json:"width,omitempty"` // разрядность в режиме "integer": "int64" (по умолчанию) или "big"
+    Base  int    `json:"base,omitempty"`  // система счисления результата в режиме "integer" (по умолчанию 10)
+    Trace bool   `json:"trace,omitempty"` // записать ход вычисления по шагам
+}
+
+// TaskResult — результат вычисления. Целиком хранится в кеше и в истории расписания,
+// достаётся задачам с тем же выражением и приходит от агента.
+type TaskResult struct {
+    Result  float64         `json:"result,omitempty"`
+    Imag    float64         `json:"-"`              // мнимая часть результата в комплексном режиме
+    Unit    string          `json:"unit,omitempty"` // единица результата в режиме "units" (в запросе — в какую перевести, если не задана — единицы СИ)
+    Integer string          `json:"-"`              // результат в режиме "integer" (result — строка "0xFF")
+    Boolean bool            `json:"-"`              // результат — логическое значение (сравнение, and, or, not): result — true или false
+    Array   json.RawMessage `json:"-"`              // результат — вектор или матрица: result — вложенные массивы ([[1, 2], [3, 4]])
+
+    Bindings      map[string]interface{} `json:"bindings,omitempty"`       // итоговые значения переменных и функций программы ("a = f(3); a * 2")
+    Steps         []calculation.Step     `json:"steps,omitempty"`          // шаги вычисления (для задач с trace)
+    ErrorEstimate float64                `json:"error_estimate,omitempty"` // оценка погрешности интеграла integrate(...)
+    Roots         []float64              `json:"roots,omitempty"`          // найденные корни уравнения (нет поля — нет корней)
+    Points        []calculation.Point    `json:"points,omitempty"`         // точки графика (для задач type: "plot")
 }
 
 var (
-    TaskQueue       []Task
+    TaskQueue       TaskHeap                  // Очередь задач (куча по справедливости, приоритет — внутри владельца)
     CompletedTasks  = make(map[int]Task) // Хранилище завершённых задач
     TaskMutex       sync.Mutex
     TaskIDCounter   int
@@ -35,66 +96,362 @@ var InvalidOperatorsRegex = regexp.MustCompile(`[+\-*/]\s*([+\-*/])`)
 
 // Проверка на числа с запятыми
 var InvalidCommaInNumberRegex = regexp.MustCompile(`\d+,\d+`)
+
+// Вызов функции или литерал массива ("[1,2]"): в них запятая разделяет аргументы и элементы, а не целую и дробную части
+var FunctionCallRegex = regexp.MustCompile(`[A-Za-z_]\w*\s*\(`)
+//_______________________________________________________________________________________________________________________________
+
+// validateExpression проверяет выражение перед вычислением. Возвращает разобранное дерево
+// или код ответа и сообщение об ошибке, если выражение недопустимо. variables — значения переменных выражения.
+func validateExpression(expression string, variables map[string]float64) (calculation.Node, int, string) {
+    ast, status, message := parseExpression(expression)
+    if status != 0 {
+        return nil, status, message
+    }
+    if status, message := checkExpression(ast, variables); status != 0 {
+        return nil, status, message
+    }
+    return ast, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// checkExpression проверяет разобранное выражение: переменные, функции и типы операндов.
+func checkExpression(ast calculation.Node, variables map[string]float64) (int, string) {
+    // Все переменные заданы, функции существуют и вызваны с правильным числом аргументов
+    if err := calculation.Validate(ast, variables); err != nil {
+        fmt.Println("Ошибка проверки выражения:", err)
+        return http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+    }
+    // Типы операндов: 1 + (x > 2) и if(x, 1, 2) — ошибки
+    if _, err := calculation.TypeOf(ast); err != nil {
+        fmt.Println("Ошибка типов в выражении:", err)
+        return http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+    }
+    return 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// requireNumber проверяет, что значения выражений — числа, а не логические значения
+// (графики, уравнения и производные строятся только для чисел).
+func requireNumber(nodes ...calculation.Node) (int, string) {
+    for _, node := range nodes {
+        kind, err := calculation.TypeOf(node)
+        if err == nil && kind != calculation.KindNumber {
+            err = fmt.Errorf("[Ошибка] Ожидалось число, а не %s: %s", kind, calculation.Format(node))
+        }
+        if err != nil {
+            fmt.Println("Ошибка типов в выражении:", err)
+            return http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+        }
+    }
+    return 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseExpression проверяет длину и синтаксис выражения и глубину AST, не требуя значений переменных.
+func parseExpression(expression string) (calculation.Node, int, string) {
+    if status, message := checkExpressionText(expression); status != 0 {
+        return nil, status, message
+    }
+
+    // Разбор выражения и проверка глубины AST
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        fmt.Println("Ошибка разбора выражения:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректное выражение"
+    }
+    if MaxASTDepth > 0 && calculation.Depth(ast) > MaxASTDepth {
+        countRejection(&Rejections.TooDeep)
+        fmt.Println("Ошибка: слишком глубокая вложенность выражения:", expression)
+        return nil, http.StatusUnprocessableEntity, "Слишком глубокая вложенность выражения"
+    }
+    return ast, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// checkExpressionText проверяет текст выражения до разбора: длину, повторяющиеся операторы,
+// запятые и ведущие нули в числах, явное деление на ноль.
+func checkExpressionText(expression string) (int, string) {
+    // Проверка длины выражения
+    if MaxExpressionLength > 0 && len(expression) > MaxExpressionLength {
+        countRejection(&Rejections.TooLong)
+        fmt.Println("Ошибка: выражение слишком длинное:", len(expression))
+        return http.StatusRequestEntityTooLarge, "Выражение слишком длинное" // 413
+    }
+
+    // Проверка на повторяющиеся операторы ("//" — целочисленное деление, один оператор)
+    if InvalidOperatorsRegex.MatchString(strings.ReplaceAll(expression, "//", "/")) {
+        fmt.Println("Ошибка: выражение содержит повторяющиеся операторы:", expression)
+        return http.StatusUnprocessableEntity, "Выражение не должно содержать повторяющиеся операторы" // 422
+    }
+    // Проверка на запятую
+    if InvalidCommaInNumberRegex.MatchString(expression) && !FunctionCallRegex.MatchString(expression) && !strings.Contains(expression, "[") {
+        fmt.Println("Ошибка: выражение содержит недопустимую запятую в числе:", expression)
+        return http.StatusUnprocessableEntity, "Запятая в числе недопустима"
+    }
+
+    // Проверка деления на ноль
+    if DivisionByZeroRegex.MatchString(expression) {
+        fmt.Println("Ошибка: деление на ноль в выражении:", expression)
+        return http.StatusUnprocessableEntity, "Деление на ноль невозможно"
+    }
+
+    // Проверка на недопустимые числа (например, 08)
+    if InvalidNumberRegex.MatchString(expression) {
+        fmt.Println("Ошибка: выражение содержит числа с ведущими нулями:", expression)
+        return http.StatusUnprocessableEntity, "Числа с ведущими нулями недопустимы"
+    }
+
+    // Проверка, что выражение не пустое
+    if expression == "" {
+        fmt.Println("Ошибка: выражение отсутствует")
+        return http.StatusUnprocessableEntity, "Выражение не должно быть пустым"
+    }
+    return 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// maxBodySize — наибольший размер тела POST /api/v1/calculate: выражение длиной MaxExpressionLength
+// (в JSON символ занимает до 6 байт, "\u0416") и MaxBodyOverhead на остальные поля. 0 — без ограничения.
+func maxBodySize() int64 {
+    if MaxExpressionLength <= 0 {
+        return 0
+    }
+    return int64(MaxExpressionLength)*6 + int64(MaxBodyOverhead)
+}
 //_______________________________________________________________________________________________________________________________
 
 // 1) Эндпоинт для добавления новой задачи
 func AddTask(w http.ResponseWriter, r *http.Request) {
     var newTask Task
 
+    // Ограничение частоты запросов для каждого клиента
+    if allowed, wait := allowRequest(ClientKey(r), time.Now()); !allowed {
+        countRejection(&Rejections.RateLimited)
+        fmt.Println("Ошибка: превышен лимит запросов для клиента", ClientKey(r))
+        setRetryAfter(w, wait)
+        http.Error(w, "Слишком много запросов", http.StatusTooManyRequests) // 429
+        return
+    }
+
+    // Чтение тела запроса: его хеш нужен для проверки Idempotency-Key.
+    // Тело ограничено заранее, чтобы не читать в память запрос любого размера
+    if limit := maxBodySize(); limit > 0 {
+        r.Body = http.MaxBytesReader(w, r.Body, limit)
+    }
+    body, err := io.ReadAll(r.Body)
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        countRejection(&Rejections.TooLong)
+        fmt.Println("Ошибка: тело запроса больше", tooLarge.Limit, "байт")
+        http.Error(w, "Тело запроса слишком большое", http.StatusRequestEntityTooLarge) // 413
+        return
+    }
+    if err != nil {
+        fmt.Println("Ошибка чтения тела запроса:", err)
+        http.Error(w, "Некорректные данные задачи", http.StatusBadRequest)
+        return
+    }
+    bodyHash := sha256.Sum256(body)
+
     // Декодирование JSON-запроса
-    err := json.NewDecoder(r.Body).Decode(&newTask)
+    err = json.Unmarshal(body, &newTask)
     if err != nil {
         fmt.Println("Ошибка декодирования данных задачи:", err)
         http.Error(w, "Некорректные данные задачи", http.StatusUnprocessableEntity)
         return
     }
-    
-   
-    // Проверка на повторяющиеся операторы
-    if InvalidOperatorsRegex.MatchString(newTask.Expression) {
-        fmt.Println("Ошибка: выражение содержит повторяющиеся операторы:", newTask.Expression)
-        http.Error(w, "Выражение не должно содержать повторяющиеся операторы", http.StatusUnprocessableEntity) // 422
-        return
+    // ID, статус, связи с другими задачами и результат назначает оркестратор, из запроса они не берутся.
+    // Единица unit остаётся: в режиме units это единица, в которую перевести результат
+    newTask.ID, newTask.Status, newTask.Error = 0, "", ""
+    newTask.ScheduleID, newTask.ParentID, newTask.Children = 0, 0, nil
+    newTask.TaskResult = TaskResult{Unit: newTask.Unit}
+
+    // Проверка выражения (или уравнения): длина, синтаксис и глубина вложенности
+    var ast calculation.Node
+    var equation *calculation.Equation
+    status, message := http.StatusUnprocessableEntity, "Неизвестный тип задачи"
+    switch newTask.Type {
+    case "":
+        ast, status, message = validateTaskExpression(&newTask)
+    case "solve":
+        equation, status, message = validateEquation(&newTask)
     }
-    // Проверка на запятую 
-    if InvalidCommaInNumberRegex.MatchString(newTask.Expression) {
-        fmt.Println("Ошибка: выражение содержит недопустимую запятую в числе:", newTask.Expression)
-        http.Error(w, "Запятая в числе недопустима", http.StatusUnprocessableEntity)
-        return
+    if status == 0 && newTask.Mode != "" && newTask.Type != "" {
+        status, message = http.StatusUnprocessableEntity, "Режим mode доступен только для вычисления выражений"
     }
-    
-    // Проверка деления на ноль
-    if DivisionByZeroRegex.MatchString(newTask.Expression) {
-        fmt.Println("Ошибка: деление на ноль в выражении:", newTask.Expression)
-        http.Error(w, "Деление на ноль невозможно", http.StatusUnprocessableEntity)
+    if status != 0 {
+        http.Error(w, message, status)
         return
     }
-    
-    // Проверка на недопустимые числа (например, 08)
-    if InvalidNumberRegex.MatchString(newTask.Expression) {
-        fmt.Println("Ошибка: выражение содержит числа с ведущими нулями:", newTask.Expression)
-        http.Error(w, "Числа с ведущими нулями недопустимы", http.StatusUnprocessableEntity)
+    // Проверка приоритета
+    if newTask.Priority < 0 || newTask.Priority > MaxPriority {
+        fmt.Println("Ошибка: недопустимый приоритет:", newTask.Priority)
+        http.Error(w, fmt.Sprintf("Приоритет должен быть от 0 до %d", MaxPriority), http.StatusUnprocessableEntity)
         return
     }
-    
-    // Проверка, что выражение не пустое
-    if newTask.Expression == "" {
-        fmt.Println("Ошибка: выражение отсутствует")
-        http.Error(w, "Выражение не должно быть пустым", http.StatusUnprocessableEntity)
-        return
+    newTask.Owner = ClientKey(r)
+
+    // Проверка адреса обратного вызова
+    if newTask.CallbackURL != "" {
+        if err := checkCallbackURL(newTask.CallbackURL); err != nil {
+            fmt.Println("Ошибка: некорректный callback_url:", newTask.CallbackURL, err)
+            http.Error(w, "Некорректный callback_url: "+err.Error(), http.StatusUnprocessableEntity)
+            return
+        }
+    }
+
+    // Проверка времени запуска и расписания
+    runAt := newTask.RunAt
+    if newTask.Delay != "" {
+        delay, err := time.ParseDuration(newTask.Delay)
+        if err != nil || delay < 0 || runAt != nil {
+            fmt.Println("Ошибка: некорректная задержка:", newTask.Delay)
+            http.Error(w, "Некорректная задержка (delay нельзя сочетать с run_at)", http.StatusUnprocessableEntity)
+            return
+        }
+        delayed := time.Now().Add(delay)
+        runAt = &delayed
+    }
+    var spec *cronSpec
+    if newTask.Schedule != "" {
+        spec, err = parseCron(newTask.Schedule)
+        if err == nil && spec.next(time.Now()).IsZero() {
+            err = fmt.Errorf("расписание %q никогда не наступит", newTask.Schedule)
+        }
+        if err != nil {
+            fmt.Println("Ошибка: некорректное расписание:", err)
+            http.Error(w, "Некорректное расписание: "+err.Error(), http.StatusUnprocessableEntity)
+            return
+        }
     }
 
+    // Упрощение: агенту отправляется каноническая форма, она же служит ключом кеша,
+    // поэтому "x + y" и "y + x" с simplify вычисляются один раз
+    newTask.Simplified = ""
+    if equation != nil {
+        if newTask.Simplify {
+            equation = &calculation.Equation{Left: calculation.Simplify(equation.Left), Right: calculation.Simplify(equation.Right)}
+            newTask.Simplified = calculation.Format(equation.Left) + " = " + calculation.Format(equation.Right)
+        }
+        newTask.cacheKey = equationKey(equation, newTask)
+    } else {
+        if newTask.Simplify {
+            ast = calculation.Simplify(ast)
+            newTask.Simplified = calculation.Format(ast)
+        }
+        // Одинаковые по смыслу выражения ("2+3" и "(2 + 3)") дают один и тот же ключ кеша.
+        // Шаги вычисления привязаны к исходному тексту, поэтому задачи с trace кеш не используют
+        if newTask.program != nil {
+            newTask.cacheKey = programKey(newTask.program, newTask.Variables, newTask.Lists)
+        } else if !newTask.Trace {
+            newTask.cacheKey = modeKey(newTask, expressionKey(ast, newTask.Variables))
+        }
+    }
+
+    // Разбиение интеграла или суммы на части для нескольких агентов
+    var parts []Task
+    if newTask.Parts > 1 {
+        if equation != nil || newTask.Trace || newTask.Mode != "" || runAt != nil || newTask.Schedule != "" {
+            fmt.Println("Ошибка: parts нельзя сочетать с solve, trace, mode, run_at, delay и schedule")
+            http.Error(w, "parts нельзя сочетать с type solve, trace, mode, run_at, delay и schedule", http.StatusUnprocessableEntity)
+            return
+        }
+        parts, status, message = splitTask(newTask, ast)
+        if status != 0 {
+            http.Error(w, message, status)
+            return
+        }
+    }
 
-    // Добавление задачи в очередь
+    // Добавление задачи
     TaskMutex.Lock()
     defer TaskMutex.Unlock()
 
-    TaskIDCounter++
-    newTask.ID = TaskIDCounter
-    newTask.Status = "pending"
-    TaskQueue = append(TaskQueue, newTask)
+    // Повторная отправка с тем же Idempotency-Key возвращает уже созданную задачу
+    now := time.Now()
+    idemKey := ""
+    if key := r.Header.Get("Idempotency-Key"); key != "" {
+        idemKey = idempotencyKey(ClientKey(r), key)
+        if record, exists := lookupIdempotency(idemKey, now); exists {
+            if record.BodyHash != bodyHash {
+                fmt.Println("Ошибка: Idempotency-Key повторно использован с другим телом запроса:", key)
+                http.Error(w, "Idempotency-Key уже использован с другим телом запроса", http.StatusConflict) // 409
+                return
+            }
+            w.Header().Set("Idempotent-Replayed", "true")
+            if record.ScheduleID != 0 {
+                fmt.Printf("Повторный запрос по Idempotency-Key %s, возвращаем расписание ID=%d\n", key, record.ScheduleID)
+                writeScheduleCreated(w, record.ScheduleID)
+                return
+            }
+            fmt.Printf("Повторный запрос по Idempotency-Key %s, возвращаем задачу ID=%d\n", key, record.TaskID)
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusCreated)
+            json.NewEncoder(w).Encode(map[string]int{"id": record.TaskID})
+            return
+        }
+    }
+
+    // Отложенные задачи и расписания ждут вне очереди, их число ограничено отдельно
+    if (newTask.Schedule != "" || (runAt != nil && runAt.After(now))) && waitingFull() {
+        countRejection(&Rejections.QueueFull)
+        fmt.Println("Ошибка: слишком много отложенных задач и расписаний:", waitingTasks())
+        w.Header().Set("Retry-After", strconv.Itoa(QueueRetryAfter))
+        http.Error(w, "Слишком много отложенных задач и расписаний, повторите позже", http.StatusServiceUnavailable) // 503
+        return
+    }
+
+    if newTask.Schedule != "" {
+        // Повторяющееся вычисление: задачи создаются планировщиком
+        start := now
+        if runAt != nil {
+            start = *runAt
+        }
+        schedule := newSchedule(newTask, spec, start)
+        if idemKey != "" {
+            rememberIdempotency(idemKey, idempotencyRecord{ScheduleID: schedule.ID, BodyHash: bodyHash, Created: now})
+        }
+        writeScheduleCreated(w, schedule.ID)
+        return
+    }
+
+    if runAt != nil && runAt.After(now) {
+        // Отложенная задача ждёт своего времени вне очереди
+        TaskIDCounter++
+        newTask.ID = TaskIDCounter
+        newTask.RunAt = runAt
+        newTask.Status = "scheduled"
+        ScheduledTasks[newTask.ID] = newTask
+        publishEvent("created", newTask)
+        fmt.Printf("Задача отложена: ID=%d, Выражение=%s, Запуск=%s\n", newTask.ID, newTask.Expression, runAt.Format(time.RFC3339))
+    } else if len(parts) > 0 {
+        parent, ok := dispatchParts(newTask, parts, now)
+        if !ok {
+            countRejection(&Rejections.QueueFull)
+            fmt.Println("Ошибка: в очереди нет места для всех частей задачи:", len(TaskQueue))
+            w.Header().Set("Retry-After", strconv.Itoa(QueueRetryAfter))
+            http.Error(w, "Очередь задач переполнена, повторите позже", http.StatusServiceUnavailable) // 503
+            return
+        }
+        newTask = parent
+    } else {
+        TaskIDCounter++
+        newTask.ID = TaskIDCounter
+        dispatched, ok := dispatchTask(newTask, now, true)
+        if !ok {
+            countRejection(&Rejections.QueueFull)
+            fmt.Println("Ошибка: очередь задач переполнена:", len(TaskQueue))
+            w.Header().Set("Retry-After", strconv.Itoa(QueueRetryAfter))
+            http.Error(w, "Очередь задач переполнена, повторите позже", http.StatusServiceUnavailable) // 503
+            return
+        }
+        newTask = dispatched
+    }
 
-    fmt.Printf("Задача добавлена: ID=%d, Выражение=%s, Статус=%s\n", newTask.ID, newTask.Expression, newTask.Status)
+    if idemKey != "" {
+        rememberIdempotency(idemKey, idempotencyRecord{TaskID: newTask.ID, BodyHash: bodyHash, Created: now})
+    }
 
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusCreated)
@@ -106,7 +463,75 @@ func AddTask(w http.ResponseWriter, r *http.Request) {
 }
 //_______________________________________________________________________________________________________________________________
 
-// 2) Эндпоинт для получения выражения по ID
+// waitingTasks возвращает число задач вне очереди: отложенных, расписаний и задач, ждущих результата
+// такого же вычисляемого выражения. Вызывается под TaskMutex.
+func waitingTasks() int {
+    count := len(ScheduledTasks) + len(Schedules)
+    for _, followers := range coalescedTasks {
+        count += len(followers)
+    }
+    return count
+}
+
+// waitingFull сообщает, что задач вне очереди уже MaxWaitingTasks. Вызывается под TaskMutex.
+func waitingFull() bool {
+    return MaxWaitingTasks > 0 && waitingTasks() >= MaxWaitingTasks
+}
+//_______________________________________________________________________________________________________________________________
+
+// dispatchTask отправляет задачу на выполнение: берёт результат из кеша, присоединяет к такому же
+// вычисляемому выражению или ставит в очередь. ID задаче назначает вызывающий. created — задача только
+// что создана и получает событие "created"; отложенная задача, выпущенная в очередь, его уже получила.
+// Возвращает false, если очередь переполнена (состояние при этом не меняется). Вызывается под TaskMutex.
+func dispatchTask(task Task, now time.Time, created bool) (Task, bool) {
+    accept := func() {
+        if created {
+            task.Status = "pending"
+            publishEvent("created", task)
+        }
+    }
+
+    if entry, cached := cacheGet(task.cacheKey, now); cached {
+        // Результат уже известен: задача завершается сразу, без агентов
+        cacheStats.Hits++
+        accept()
+        task.TaskResult = entry.TaskResult
+        task.Status = "completed"
+        recordCompletion(task)
+        fmt.Printf("Задача ID=%d взята из кеша: Выражение=%s, Результат=%f\n", task.ID, task.Expression, task.Result)
+        return task, true
+    }
+
+    // Ждущие задачи не занимают очередь, но их число ограничено: сверх MaxWaitingTasks задача встаёт в очередь
+    if leaderID, inflight := inflightLeader(task.cacheKey); inflight && !waitingFull() {
+        // То же выражение уже вычисляется: ждём результат задачи-лидера
+        cacheStats.Coalesced++
+        accept()
+        task.Status = "pending"
+        coalescedTasks[leaderID] = append(coalescedTasks[leaderID], task)
+        fmt.Printf("Задача ID=%d присоединена к задаче ID=%d: Выражение=%s\n", task.ID, leaderID, task.Expression)
+        return task, true
+    }
+
+    // Проверка переполнения очереди
+    if MaxQueueDepth > 0 && len(TaskQueue) >= MaxQueueDepth {
+        return task, false
+    }
+
+    cacheStats.Misses++
+    accept()
+    task.Status = "pending"
+    enqueueTask(task)
+    if task.cacheKey != "" {
+        inflightTasks[task.cacheKey] = task.ID
+    }
+    fmt.Printf("Задача добавлена: ID=%d, Выражение=%s, Статус=%s\n", task.ID, task.Expression, task.Status)
+    return task, true
+}
+//_______________________________________________________________________________________________________________________________
+
+// 2) Эндпоинт для получения выражения по ID.
+// С параметром ?wait=10s ответ задерживается, пока задача не завершится или не истечёт время.
 func GetExpressionByID(w http.ResponseWriter, r *http.Request) {
     defer func() {
         if r := recover(); r != nil {
@@ -121,16 +546,41 @@ func GetExpressionByID(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    TaskMutex.Lock()
-    defer TaskMutex.Unlock()
+    wait, err := parseWait(r.URL.Query().Get("wait"))
+    if err != nil {
+        http.Error(w, "Некорректное время ожидания", http.StatusBadRequest) // 400
+        return
+    }
 
-    // Проверяем, есть ли задача в истории
-    task, exists := CompletedTasks[id]
+    TaskMutex.Lock()
+    // Ищем задачу в очереди, среди выполняемых и в истории
+    task, exists := findTask(id)
     if !exists {
+        TaskMutex.Unlock()
         http.Error(w, "Выражение не найдено", http.StatusNotFound) // 404
         return
     }
 
+    if wait > 0 && !isTerminal(task.Status) {
+        // Ждём уведомления о завершении задачи, не удерживая мьютекс
+        done := waitChannel(id)
+        TaskMutex.Unlock()
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-done:
+        case <-timer.C:
+        case <-r.Context().Done():
+        }
+        timer.Stop()
+
+        TaskMutex.Lock()
+        if current, found := findTask(id); found {
+            task = current
+        }
+    }
+    TaskMutex.Unlock()
+
     response := map[string]Task{"expression": task}
 
     w.Header().Set("Content-Type", "application/json")
@@ -174,18 +624,13 @@ func GetTask(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    task := TaskQueue[0]
+    // Забираем следующую задачу из очереди и отмечаем, что она выдана агенту
+    task := dequeueTask()
+    task.Status = "in-progress" // Статус на английском
+    InProgressTasks[task.ID] = task
+    publishEvent("leased", task)
 
-    // Если задача уже завершена, не меняем её статус
-    if task.Status == "completed" {
-        fmt.Println("Задача уже завершена, пропускаем её")
-    } else {
-        // Меняем статус на "in-progress", если задача не завершена
-        task.Status = "in-progress" // Статус на английском
-        TaskQueue[0] = task // Обновляем очередь
-    }
-
-    fmt.Printf("Задача получена: ID=%d, Выражение=%s, Статус=%s\n", task.ID, task.Expression, task.Status)
+    fmt.Printf("Задача получена: ID=%d, Выражение=%s, Статус=%s, Приоритет=%d\n", task.ID, task.Expression, task.Status, task.Priority)
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]Task{"task": task})
@@ -210,30 +655,38 @@ func UpdateTaskResult(w http.ResponseWriter, r *http.Request) {
     TaskMutex.Lock()
     defer TaskMutex.Unlock()
 
-    for i, task := range TaskQueue {
-        if task.ID == updatedTask.ID {
-            if task.Status == "completed" {
-                http.Error(w, "Задача уже завершена", http.StatusBadRequest)
-                return
-            }
-
-            // Обновляем задачу и переносим в историю
-            TaskQueue[i].Result = updatedTask.Result
-            TaskQueue[i].Status = "completed"
-            CompletedTasks[TaskQueue[i].ID] = TaskQueue[i] // Сохраняем в историю
+    if task, exists := InProgressTasks[updatedTask.ID]; exists {
+        // Обновляем задачу и переносим в историю
+        if updatedTask.Status == "failed" {
+            // Агент не смог вычислить выражение
+            task.Status = "failed"
+            task.Error = updatedTask.Error
+        } else {
+            task.TaskResult = updatedTask.TaskResult
+            task.Status = "completed"
+        }
+        delete(InProgressTasks, task.ID)
+        completeTask(task) // Сохраняем в историю и в кеш результатов
 
-            fmt.Printf("Задача обновлена и сохранена в истории: ID=%d, Результат=%f\n", TaskQueue[i].ID, TaskQueue[i].Result)
+        fmt.Printf("Задача обновлена и сохранена в истории: ID=%d, Статус=%s, Результат=%f\n", task.ID, task.Status, task.Result)
 
-            // Удаляем из очереди
-            TaskQueue = append(TaskQueue[:i], TaskQueue[i+1:]...)
+        // Отправляем обновлённую задачу в ответ
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
 
-            // Отправляем обновлённую задачу в ответ
-            w.Header().Set("Content-Type", "application/json")
-            w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(CompletedTasks[updatedTask.ID])
+        return
+    }
 
-            json.NewEncoder(w).Encode(CompletedTasks[updatedTask.ID])
+    // Задача не выполняется: она могла быть уже завершена или отменена
+    if task, exists := CompletedTasks[updatedTask.ID]; exists {
+        if task.Status == "cancelled" {
+            fmt.Printf("Результат для отменённой задачи ID=%d отклонён\n", task.ID)
+            http.Error(w, "Задача отменена", http.StatusConflict) // 409
             return
         }
+        http.Error(w, "Задача уже завершена", http.StatusBadRequest)
+        return
     }
 
     http.NotFound(w, r)
@@ -246,12 +699,22 @@ func DeleteAllTasks(w http.ResponseWriter, r *http.Request) {
     TaskMutex.Lock()
     defer TaskMutex.Unlock()
 
-    // Очистка очереди задач
-    TaskQueue = []Task{}
+    // Очистка очереди задач и задач, выданных агентам
+    TaskQueue = TaskHeap{}
+    resetScheduler()
 
     // Очистка завершённых задач
     CompletedTasks = make(map[int]Task)
     TaskIDCounter = 0
+    resetIdempotency()
+    ScheduledTasks = make(map[int]Task)
+    Schedules = make(map[int]*Schedule)
+    for id := range taskWaiters {
+        notifyWaiters(id)
+    }
+    inflightTasks = make(map[string]int)
+    coalescedTasks = make(map[int][]Task)
+    PartedTasks = make(map[int]*partedTask)
 
     // Проверяем, что данные очищены
     fmt.Printf("Очистили TaskQueue: %v, CompletedTasks: %v\n", TaskQueue, CompletedTasks)
@@ -0,0 +1,60 @@
+package handler
+
+import (
+    "crypto/sha256"
+    "time"
+)
+
+// idempotencyRecord запоминает, какая задача (или расписание) была создана по ключу Idempotency-Key.
+type idempotencyRecord struct {
+    TaskID     int
+    ScheduleID int // запрос создал расписание, а не задачу
+    BodyHash   [sha256.Size]byte
+    Created    time.Time
+}
+
+var (
+    // Записи идемпотентности по ключу "клиент|Idempotency-Key". Доступ под TaskMutex.
+    IdempotencyRecords = make(map[string]idempotencyRecord)
+    // Ключи записей в порядке создания — для удаления устаревших записей с начала списка.
+    idempotencyOrder []string
+)
+//_______________________________________________________________________________________________________________________________
+
+// idempotencyKey возвращает ключ записи: Idempotency-Key действует только в пределах одного клиента.
+func idempotencyKey(client, key string) string {
+    return client + "|" + key
+}
+//_______________________________________________________________________________________________________________________________
+
+// lookupIdempotency ищет запись по ключу, предварительно удалив записи старше IdempotencyRetention.
+// Вызывается под TaskMutex.
+func lookupIdempotency(key string, now time.Time) (idempotencyRecord, bool) {
+    for len(idempotencyOrder) > 0 {
+        oldest := idempotencyOrder[0]
+        record, exists := IdempotencyRecords[oldest]
+        if exists && now.Sub(record.Created) < IdempotencyRetention {
+            break
+        }
+        delete(IdempotencyRecords, oldest)
+        idempotencyOrder = idempotencyOrder[1:]
+    }
+
+    record, exists := IdempotencyRecords[key]
+    return record, exists
+}
+//_______________________________________________________________________________________________________________________________
+
+// rememberIdempotency сохраняет запись о созданной задаче или расписании. Вызывается под TaskMutex.
+func rememberIdempotency(key string, record idempotencyRecord) {
+    IdempotencyRecords[key] = record
+    idempotencyOrder = append(idempotencyOrder, key)
+}
+//_______________________________________________________________________________________________________________________________
+
+// resetIdempotency удаляет все записи (идентификаторы задач начинаются заново). Вызывается под TaskMutex.
+func resetIdempotency() {
+    IdempotencyRecords = make(map[string]idempotencyRecord)
+    idempotencyOrder = nil
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,51 @@
+package handler
+
+import (
+    "encoding/json"
+    "net/http"
+    "sync"
+)
+
+// RejectionStats — счётчики отклонённых запросов на добавление задачи.
+type RejectionStats struct {
+    RateLimited int `json:"rate_limited"` // 429: клиент превысил лимит запросов
+    QueueFull   int `json:"queue_full"`   // 503: очередь задач переполнена
+    TooLong     int `json:"too_long"`     // 413: выражение длиннее MaxExpressionLength
+    TooDeep     int `json:"too_deep"`     // 422: глубина AST больше MaxASTDepth
+}
+
+var (
+    Rejections   RejectionStats
+    MetricsMutex sync.Mutex
+)
+//_______________________________________________________________________________________________________________________________
+
+// countRejection увеличивает один из счётчиков отказов.
+func countRejection(counter *int) {
+    MetricsMutex.Lock()
+    defer MetricsMutex.Unlock()
+    *counter++
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для получения метрик оркестратора
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+    TaskMutex.Lock()
+    queueDepth := len(TaskQueue)
+    inProgress := len(InProgressTasks)
+    cache := currentCacheStats()
+    TaskMutex.Unlock()
+
+    MetricsMutex.Lock()
+    rejections := Rejections
+    MetricsMutex.Unlock()
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "queue_depth": queueDepth,
+        "in_progress": inProgress,
+        "rejections":  rejections,
+        "cache":       cache,
+    })
+}
+//_______________________________________________________________________________________________________________________________
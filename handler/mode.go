@@ -0,0 +1,268 @@
+package handler
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// validateTaskExpression проверяет выражение задачи с учётом режима вычисления (поле mode).
+func validateTaskExpression(task *Task) (calculation.Node, int, string) {
+    if task.Unit != "" && task.Mode != "units" {
+        return nil, http.StatusUnprocessableEntity, "unit доступен только в режиме units"
+    }
+    if (task.Width != "" || task.Base != 0) && task.Mode != "integer" {
+        return nil, http.StatusUnprocessableEntity, "width и base доступны только в режиме integer"
+    }
+    if len(task.Lists) > 0 && task.Mode != "" {
+        return nil, http.StatusUnprocessableEntity, "Списки в variables недоступны в режиме " + task.Mode
+    }
+    task.program = nil
+    if calculation.IsProgram(task.Expression) {
+        if task.Mode != "" {
+            return nil, http.StatusUnprocessableEntity, "Программы недоступны в режиме " + task.Mode
+        }
+        status, message := validateProgram(task)
+        return nil, status, message
+    }
+    switch task.Mode {
+    case "":
+        ast, status, message := parseExpression(task.Expression)
+        if status != 0 {
+            return nil, status, message
+        }
+        // Переменные-списки подставляются литералами: дальше это обычные векторы
+        ast = calculation.BindLists(ast, task.Lists)
+        if status, message := checkExpression(ast, task.Variables); status != 0 {
+            return nil, status, message
+        }
+        // Пошаговое решение сворачивает операции в числа, логических шагов в нём нет
+        if task.Trace && calculation.UsesLogic(ast) {
+            return nil, http.StatusUnprocessableEntity, "Пошаговое решение недоступно для сравнений и условий"
+        }
+        // Упрощение x * 0 → 0 теряет размер вектора, а шаги вычисления — только числа
+        if (task.Trace || task.Simplify) && calculation.UsesArrays(ast) {
+            return nil, http.StatusUnprocessableEntity, "trace и simplify недоступны для векторов и матриц"
+        }
+        kind, _ := calculation.TypeOf(ast)
+        task.Boolean = kind == calculation.KindBoolean
+        return ast, 0, ""
+    case "units":
+        return validateUnits(task)
+    case "integer":
+        return validateInteger(task)
+    case "complex":
+        if task.Trace {
+            return nil, http.StatusUnprocessableEntity, "Пошаговое решение недоступно в комплексном режиме"
+        }
+        ast, status, message := parseExpression(task.Expression)
+        if status != 0 {
+            return nil, status, message
+        }
+        if err := calculation.ValidateComplex(ast, task.Variables); err != nil {
+            fmt.Println("Ошибка проверки выражения:", err)
+            return nil, http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+        }
+        return ast, 0, ""
+    }
+    fmt.Println("Ошибка: неизвестный режим вычисления:", task.Mode)
+    return nil, http.StatusUnprocessableEntity, "Неизвестный режим вычисления (mode)"
+}
+//_______________________________________________________________________________________________________________________________
+
+// validateProgram проверяет программу из нескольких инструкций ("f(x) = x^2 + 1; a = f(3); a * 2"):
+// порядок определений, число аргументов и типы. Вычисляет программу агент.
+func validateProgram(task *Task) (int, string) {
+    if task.Trace || task.Simplify {
+        return http.StatusUnprocessableEntity, "trace и simplify недоступны для программ"
+    }
+    if status, message := checkExpressionText(task.Expression); status != 0 {
+        return status, message
+    }
+    program, err := calculation.ParseProgram(task.Expression)
+    if err != nil {
+        fmt.Println("Ошибка разбора программы:", err)
+        return http.StatusUnprocessableEntity, "Некорректное выражение"
+    }
+    kind, err := calculation.CheckProgram(program, task.Variables, task.Lists)
+    if err != nil {
+        fmt.Println("Ошибка проверки программы:", err)
+        return http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+    }
+    task.Boolean = kind == calculation.KindBoolean
+    task.program = program
+    return 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// modeKey добавляет режим вычисления (и единицу результата, разрядность, систему счисления) к ключу кеша:
+// "sqrt(-1)" в разных режимах — разные результаты.
+func modeKey(task Task, key string) string {
+    if task.Mode == "" || key == "" {
+        return key
+    }
+    if task.Mode == "integer" {
+        return fmt.Sprintf("%s %s base %d %s", task.Mode, task.Width, task.Base, key)
+    }
+    if task.Unit != "" {
+        return fmt.Sprintf("%s %s in %s", task.Mode, key, task.Unit)
+    }
+    return task.Mode + " " + key
+}
+//_______________________________________________________________________________________________________________________________
+
+// validateUnits проверяет выражение с единицами измерения. Размерность не зависит от значений,
+// поэтому выражение вычисляется сразу: ошибка размерности (m + s, перевод m/s в kg) — это 422,
+// а ошибки вычисления (деление на ноль) остаются агенту и завершают задачу со статусом failed.
+func validateUnits(task *Task) (calculation.Node, int, string) {
+    // Упрощение x - x → 0 теряет размерность, а пошаговое решение не показывает единицы
+    if task.Trace || task.Simplify {
+        return nil, http.StatusUnprocessableEntity, "trace и simplify недоступны с единицами измерения"
+    }
+    ast, status, message := parseExpression(task.Expression)
+    if status != 0 {
+        return nil, status, message
+    }
+    if err := calculation.ValidateUnits(ast, task.Variables); err != nil {
+        fmt.Println("Ошибка проверки выражения:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+    }
+
+    if task.Unit != "" {
+        if _, err := calculation.ParseUnit(task.Unit); err != nil {
+            fmt.Println("Ошибка: некорректная единица результата:", task.Unit, err)
+            return nil, http.StatusUnprocessableEntity, "Некорректная единица результата: " + err.Error()
+        }
+    }
+
+    var dimensionError *calculation.DimensionError
+    quantity, err := calculation.EvalUnits(ast, task.Variables)
+    if err == nil {
+        _, _, err = calculation.Convert(quantity, task.Unit)
+    }
+    if errors.As(err, &dimensionError) {
+        fmt.Println("Ошибка размерности:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректные единицы измерения: " + err.Error()
+    }
+    return ast, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// validateInteger проверяет выражение целочисленного режима и подставляет разрядность и систему счисления
+// по умолчанию. Переполнение и деление на ноль обнаружит агент — задача завершится со статусом failed.
+func validateInteger(task *Task) (calculation.Node, int, string) {
+    // Упрощение сворачивает константы в float64, а пошаговое решение считает в действительных числах
+    if task.Trace || task.Simplify {
+        return nil, http.StatusUnprocessableEntity, "trace и simplify недоступны в целочисленном режиме"
+    }
+    if task.Width == "" {
+        task.Width = calculation.WidthInt64
+    }
+    if task.Width != calculation.WidthInt64 && task.Width != calculation.WidthBig {
+        return nil, http.StatusUnprocessableEntity, "width должен быть int64 или big"
+    }
+    if task.Base == 0 {
+        task.Base = 10
+    }
+    if task.Base < 2 || task.Base > 36 {
+        return nil, http.StatusUnprocessableEntity, "base должен быть от 2 до 36"
+    }
+
+    ast, status, message := parseExpression(task.Expression)
+    if status != 0 {
+        return nil, status, message
+    }
+    if err := calculation.ValidateInteger(ast, task.Variables); err != nil {
+        fmt.Println("Ошибка проверки выражения:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+    }
+    return ast, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// MarshalJSON записывает задачу в JSON. В комплексном режиме результат — объект {"re": ..., "im": ...},
+// в целочисленном — строка в системе счисления base ("0xFF"), у сравнений — true или false
+// (только у завершённой задачи), у векторов и матриц — вложенные массивы. Переменные-списки
+// записываются в variables вместе с числами.
+func (t Task) MarshalJSON() ([]byte, error) {
+    type plain Task
+    var result interface{}
+    completed := t.Status == "completed"
+    switch {
+    case t.Array != nil:
+        result = t.Array
+    case t.Boolean:
+        if completed {
+            result = t.Result != 0
+        }
+    case t.Mode == "integer":
+        if t.Integer != "" {
+            result = t.Integer
+        }
+    case t.Mode == "complex":
+        if completed {
+            result = calculation.Complex{Re: t.Result, Im: t.Imag}
+        }
+    default:
+        if t.Result != 0 {
+            result = t.Result
+        }
+    }
+    return json.Marshal(struct {
+        plain
+        Result    interface{}            `json:"result,omitempty"`
+        Variables map[string]interface{} `json:"variables,omitempty"`
+    }{plain(t), result, calculation.JoinVariables(t.Variables, t.Lists)})
+}
+//_______________________________________________________________________________________________________________________________
+
+// UnmarshalJSON читает задачу из JSON: результат — число, объект {"re": ..., "im": ...}, строка
+// целочисленного режима, логическое значение (хранится как 1 или 0) или вложенные массивы вектора или матрицы.
+// Значения variables — числа или списки чисел.
+func (t *Task) UnmarshalJSON(data []byte) error {
+    type plain Task
+    var decoded struct {
+        plain
+        Result    json.RawMessage            `json:"result,omitempty"`
+        Variables map[string]json.RawMessage `json:"variables,omitempty"`
+    }
+    if err := json.Unmarshal(data, &decoded); err != nil {
+        return err
+    }
+    *t = Task(decoded.plain)
+    var err error
+    if t.Variables, t.Lists, err = calculation.SplitVariables(decoded.Variables); err != nil {
+        return err
+    }
+
+    if len(decoded.Result) == 0 || string(decoded.Result) == "null" {
+        return nil
+    }
+    if decoded.Result[0] == '{' {
+        var value calculation.Complex
+        if err := json.Unmarshal(decoded.Result, &value); err != nil {
+            return err
+        }
+        t.Result, t.Imag = value.Re, value.Im
+        return nil
+    }
+    if decoded.Result[0] == '"' {
+        return json.Unmarshal(decoded.Result, &t.Integer)
+    }
+    if decoded.Result[0] == '[' {
+        t.Array = append(json.RawMessage(nil), decoded.Result...)
+        return nil
+    }
+    if value := string(decoded.Result); value == "true" || value == "false" {
+        t.Boolean = true
+        if value == "true" {
+            t.Result = 1
+        }
+        return nil
+    }
+    return json.Unmarshal(decoded.Result, &t.Result)
+}
+//_______________________________________________________________________________________________________________________________
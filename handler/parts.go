@@ -0,0 +1,270 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "time"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// partedTask — задача, разбитая на части (поле parts): отрезок интегрирования или суммирования
+// делится между несколькими задачами, которые вычисляют разные агенты, а результаты складываются.
+// Матричное произведение делится по блокам строк левой матрицы, а блоки результата склеиваются.
+type partedTask struct {
+    Task      Task
+    Remaining int                   // сколько частей ещё не завершено
+    Result    float64               // сумма результатов завершённых частей
+    Estimate  float64               // сумма оценок погрешности завершённых частей
+    Points    [][]calculation.Point // точки графика по частям (для задач type: "plot")
+    Blocks    []json.RawMessage     // блоки строк матричного произведения по частям
+}
+
+// Задачи, ожидающие своих частей. Доступ под TaskMutex.
+var PartedTasks = make(map[int]*partedTask)
+//_______________________________________________________________________________________________________________________________
+
+// splitTask делит integrate(...) или sum(...) верхнего уровня на task.Parts частей с равными отрезками,
+// а matmul(A, B) — на блоки строк матрицы A. Пределы должны вычисляться без связанной переменной.
+// Возвращает nil, если делить нечего (пустой отрезок, меньше двух слагаемых или строк),
+// или код ответа и сообщение об ошибке.
+func splitTask(task Task, ast calculation.Node) ([]Task, int, string) {
+    if MaxTaskParts > 0 && task.Parts > MaxTaskParts {
+        fmt.Println("Ошибка: слишком много частей:", task.Parts)
+        return nil, http.StatusUnprocessableEntity, fmt.Sprintf("parts не может быть больше %d", MaxTaskParts)
+    }
+    call, ok := ast.(*calculation.Call)
+    if ok && call.Name == "matmul" {
+        return splitMatmul(task, call)
+    }
+    if !ok || !calculation.IsSpecialForm(call) {
+        fmt.Println("Ошибка: parts без integrate, sum или matmul:", task.Expression)
+        return nil, http.StatusUnprocessableEntity, "parts допустим только для выражения вида integrate(...), sum(...) или matmul(...)"
+    }
+    from, err := calculation.Eval(call.Args[2], task.Variables)
+    if err == nil {
+        var to float64
+        to, err = calculation.Eval(call.Args[3], task.Variables)
+        if err == nil {
+            return splitRange(task, call, from, to)
+        }
+    }
+    fmt.Println("Ошибка вычисления пределов:", err)
+    return nil, http.StatusUnprocessableEntity, "Некорректные пределы: " + err.Error()
+}
+//_______________________________________________________________________________________________________________________________
+
+// splitRange строит части задачи для отрезка [from, to].
+func splitRange(task Task, call *calculation.Call, from, to float64) ([]Task, int, string) {
+    var bounds []float64
+    if call.Name == "sum" {
+        if from != math.Trunc(from) || to != math.Trunc(to) {
+            return nil, http.StatusUnprocessableEntity, "Пределы суммы должны быть целыми числами"
+        }
+        // Число слагаемых проверяется до деления: count * k не должно переполниться
+        if math.Abs(from) > calculation.MaxSumBound || math.Abs(to) > calculation.MaxSumBound {
+            return nil, http.StatusUnprocessableEntity, "Пределы суммы должны быть не больше 2^53 по модулю"
+        }
+        count := int64(to) - int64(from) + 1
+        if count > calculation.MaxSumTerms {
+            return nil, http.StatusUnprocessableEntity, fmt.Sprintf("Слишком много слагаемых: %d (не больше %d)", count, calculation.MaxSumTerms)
+        }
+        if count < 2 {
+            return nil, 0, ""
+        }
+        // Каждая часть суммирует свой отрезок целых чисел [bounds[k], bounds[k+1]-1]
+        parts := int64(task.Parts)
+        if count < parts {
+            parts = count
+        }
+        for k := int64(0); k <= parts; k++ {
+            bounds = append(bounds, from+float64(count*k/parts))
+        }
+    } else if from != to {
+        for k := 0; k < task.Parts; k++ {
+            bounds = append(bounds, from+(to-from)*float64(k)/float64(task.Parts))
+        }
+        bounds = append(bounds, to)
+    }
+    if len(bounds) < 3 {
+        return nil, 0, ""
+    }
+
+    var parts []Task
+    for k := 0; k+1 < len(bounds); k++ {
+        upper := bounds[k+1]
+        if call.Name == "sum" {
+            upper--
+        }
+        part := &calculation.Call{Name: call.Name, Args: []calculation.Node{
+            call.Args[0], call.Args[1], &calculation.NumberLit{Value: bounds[k]}, &calculation.NumberLit{Value: upper},
+        }}
+        parts = append(parts, Task{
+            Expression:  calculation.Format(part),
+            Priority:    task.Priority,
+            Owner:       task.Owner,
+            TaskOptions: TaskOptions{Variables: task.Variables},
+            cacheKey:    expressionKey(part, task.Variables),
+        })
+    }
+    return parts, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// splitMatmul делит matmul(A, B) на части matmul(блок строк A, B): строки результата
+// вычисляются независимо. A вычисляется в оркестраторе, B передаётся частям как записана.
+func splitMatmul(task Task, call *calculation.Call) ([]Task, int, string) {
+    left, err := calculation.EvalValue(call.Args[0], task.Variables)
+    if err != nil {
+        fmt.Println("Ошибка вычисления левой матрицы:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректная матрица: " + err.Error()
+    }
+    if left.Kind != calculation.KindMatrix || len(left.Matrix) < 2 {
+        return nil, 0, ""
+    }
+
+    count := len(left.Matrix)
+    parts := task.Parts
+    if count < parts {
+        parts = count
+    }
+    var tasks []Task
+    for k := 0; k < parts; k++ {
+        block := &calculation.ArrayLit{}
+        for _, row := range left.Matrix[count*k/parts : count*(k+1)/parts] {
+            elements := &calculation.ArrayLit{}
+            for _, x := range row {
+                elements.Elements = append(elements.Elements, &calculation.NumberLit{Value: x})
+            }
+            block.Elements = append(block.Elements, elements)
+        }
+        part := &calculation.Call{Name: "matmul", Args: []calculation.Node{block, call.Args[1]}}
+        tasks = append(tasks, Task{
+            Expression:  calculation.Format(part),
+            Priority:    task.Priority,
+            Owner:       task.Owner,
+            TaskOptions: TaskOptions{Variables: task.Variables},
+            cacheKey:    expressionKey(part, task.Variables),
+        })
+    }
+    return tasks, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// joinBlocks склеивает блоки строк матричного произведения (или элементы вектора) в один массив.
+func joinBlocks(blocks []json.RawMessage) (json.RawMessage, error) {
+    var rows []json.RawMessage
+    for _, block := range blocks {
+        var blockRows []json.RawMessage
+        if err := json.Unmarshal(block, &blockRows); err != nil {
+            return nil, err
+        }
+        rows = append(rows, blockRows...)
+    }
+    return json.Marshal(rows)
+}
+//_______________________________________________________________________________________________________________________________
+
+// dispatchParts создаёт задачу-родителя и отправляет на выполнение её части.
+// Возвращает false, если в очереди не хватает места для всех частей. Вызывается под TaskMutex.
+func dispatchParts(parent Task, parts []Task, now time.Time) (Task, bool) {
+    if MaxQueueDepth > 0 && len(TaskQueue)+len(parts) > MaxQueueDepth {
+        return parent, false
+    }
+
+    TaskIDCounter++
+    parent.ID = TaskIDCounter
+    parent.Status = "pending"
+    parent.cacheKey = ""
+    // ID частей известны заранее: часть из кеша может завершить родителя ещё внутри цикла
+    for i := range parts {
+        TaskIDCounter++
+        parts[i].ID = TaskIDCounter
+        parts[i].ParentID = parent.ID
+        parent.Children = append(parent.Children, parts[i].ID)
+    }
+    PartedTasks[parent.ID] = &partedTask{Task: parent, Remaining: len(parts), Points: make([][]calculation.Point, len(parts)), Blocks: make([]json.RawMessage, len(parts))}
+    publishEvent("created", parent)
+    fmt.Printf("Задача разбита на части: ID=%d, Выражение=%s, Части=%v\n", parent.ID, parent.Expression, parent.Children)
+
+    for _, part := range parts {
+        dispatchTask(part, now, true)
+    }
+
+    if parted, exists := PartedTasks[parent.ID]; exists {
+        return parted.Task, true
+    }
+    return CompletedTasks[parent.ID], true
+}
+//_______________________________________________________________________________________________________________________________
+
+// recordPart учитывает завершение части: когда завершены все части, родитель получает сумму их результатов
+// (для графика — все точки частей по порядку, для матричного произведения — все блоки строк).
+// Если часть не вычислена или отменена, родитель завершается ошибкой, а остальные части отменяются.
+// Вызывается под TaskMutex.
+func recordPart(part Task) {
+    parted, exists := PartedTasks[part.ParentID]
+    if !exists {
+        return
+    }
+
+    if part.Status == "completed" {
+        parted.Result += part.Result
+        parted.Estimate += part.ErrorEstimate
+        for i, id := range parted.Task.Children {
+            if id == part.ID {
+                parted.Points[i] = part.Points
+                parted.Blocks[i] = part.Array
+            }
+        }
+        parted.Remaining--
+        if parted.Remaining > 0 {
+            return
+        }
+        delete(PartedTasks, part.ParentID)
+        parent := parted.Task
+        parent.Result = parted.Result
+        parent.ErrorEstimate = parted.Estimate
+        if parent.Type == "plot" {
+            // Точки частей склеиваются в порядке отрезков, а не в порядке завершения
+            for _, points := range parted.Points {
+                parent.Points = append(parent.Points, points...)
+            }
+        }
+        parent.Status = "completed"
+        if part.Array != nil {
+            // Блоки строк склеиваются в порядке строк левой матрицы
+            array, err := joinBlocks(parted.Blocks)
+            if err != nil {
+                parent.Status = "failed"
+                parent.Error = "некорректный блок матрицы: " + err.Error()
+            }
+            parent.Array = array
+        }
+        fmt.Printf("Все части задачи ID=%d завершены, Результат=%f\n", parent.ID, parent.Result)
+        recordCompletion(parent)
+        return
+    }
+
+    delete(PartedTasks, part.ParentID)
+    parent := parted.Task
+    parent.Status = "failed"
+    parent.Error = fmt.Sprintf("часть ID=%d: %s", part.ID, part.Error)
+    if part.Status == "cancelled" {
+        parent.Error = fmt.Sprintf("часть ID=%d отменена", part.ID)
+    }
+    fmt.Printf("Задача ID=%d не вычислена: %s\n", parent.ID, parent.Error)
+    recordCompletion(parent)
+    cancelParts(parent)
+}
+//_______________________________________________________________________________________________________________________________
+
+// cancelParts отменяет незавершённые части задачи. Вызывается под TaskMutex.
+func cancelParts(parent Task) {
+    for _, id := range parent.Children {
+        cancelTask(id)
+    }
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,319 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "html"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// Число точек графика, если samples не задано
+const DefaultPlotSamples = 100
+
+// Размеры SVG-графика в пикселях
+const (
+    plotWidth  = 640
+    plotHeight = 400
+    plotMargin = 48
+)
+
+// PlotRequest — тело запроса POST /api/v1/plot.
+type PlotRequest struct {
+    Expression string             `json:"expression"`
+    Variable   string             `json:"variable,omitempty"` // переменная по оси X (по умолчанию — единственная без значения)
+    Range      []float64          `json:"range"`              // отрезок [min, max] по оси X
+    Samples    int                `json:"samples,omitempty"`  // число точек, включая концы отрезка
+    Variables  map[string]float64 `json:"variables,omitempty"`
+    Format     string             `json:"format,omitempty"` // "json" (по умолчанию) или "svg"
+}
+
+// PlotResponse — точки графика.
+type PlotResponse struct {
+    ID         int                 `json:"id,omitempty"` // задача, если график строили агенты
+    Expression string              `json:"expression"`
+    Variable   string              `json:"variable"`
+    Range      []float64           `json:"range"`
+    Samples    int                 `json:"samples"`
+    Points     []calculation.Point `json:"points"`
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для построения графика выражения одной переменной на отрезке.
+// До PlotPartSamples точек вычисляются сразу в оркестраторе, больше — делятся на задачи для агентов:
+// тогда ответ 202 с ID задачи, а точки отдаёт GET /api/v1/plot/{id}.
+func Plot(w http.ResponseWriter, r *http.Request) {
+    var request PlotRequest
+    if !readRequest(w, r, &request) {
+        return
+    }
+    if request.Format != "" && request.Format != "json" && request.Format != "svg" {
+        http.Error(w, "format должен быть json или svg", http.StatusUnprocessableEntity) // 422
+        return
+    }
+
+    task := Task{
+        Expression: request.Expression,
+        Owner:      ClientKey(r),
+        TaskOptions: TaskOptions{
+            Type:      "plot",
+            Variable:  request.Variable,
+            Range:     request.Range,
+            Samples:   request.Samples,
+            Variables: request.Variables,
+        },
+    }
+    ast, status, message := validatePlot(&task)
+    if status != 0 {
+        http.Error(w, message, status)
+        return
+    }
+
+    // Сразу строится только небольшой график без integrate и sum: их в каждой точке вычисляют агенты
+    if EvaluateEnabled && task.Samples <= PlotPartSamples && !calculation.UsesSpecialForms(ast) {
+        task.Points = calculation.Sample(ast, task.Variable, task.Variables, task.Range[0], task.Range[1], task.Samples)
+        writePlot(w, request.Format, task)
+        return
+    }
+
+    // Большой график вычисляют агенты: каждая задача — PlotPartSamples точек своего участка отрезка
+    parts := splitPlot(task, ast)
+
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+    var ok bool
+    if len(parts) > 1 {
+        task, ok = dispatchParts(task, parts, time.Now())
+    } else {
+        task.cacheKey = plotKey(ast, task)
+        TaskIDCounter++
+        task.ID = TaskIDCounter
+        task, ok = dispatchTask(task, time.Now(), true)
+    }
+    if !ok {
+        countRejection(&Rejections.QueueFull)
+        fmt.Println("Ошибка: в очереди нет места для графика:", len(TaskQueue))
+        w.Header().Set("Retry-After", strconv.Itoa(QueueRetryAfter))
+        http.Error(w, "Очередь задач переполнена, повторите позже", http.StatusServiceUnavailable) // 503
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Location", fmt.Sprintf("/api/v1/plot/%d", task.ID))
+    w.WriteHeader(http.StatusAccepted) // 202
+    json.NewEncoder(w).Encode(map[string]interface{}{"id": task.ID, "status": task.Status})
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для получения графика, который строили агенты: GET /api/v1/plot/{id}?format=svg
+func PlotByID(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", "GET")
+        http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed) // 405
+        return
+    }
+    id, err := strconv.Atoi(r.URL.Path[len("/api/v1/plot/"):])
+    if err != nil {
+        http.Error(w, "Некорректный идентификатор", http.StatusBadRequest) // 400
+        return
+    }
+
+    TaskMutex.Lock()
+    task, exists := findTask(id)
+    TaskMutex.Unlock()
+    if !exists || task.Type != "plot" || task.ParentID != 0 {
+        http.Error(w, "График не найден", http.StatusNotFound) // 404
+        return
+    }
+
+    switch task.Status {
+    case "completed":
+        writePlot(w, r.URL.Query().Get("format"), task)
+    case "failed", "cancelled":
+        http.Error(w, fmt.Sprintf("График не построен (%s): %s", task.Status, task.Error), http.StatusConflict) // 409
+    default:
+        // График ещё строится
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted) // 202
+        json.NewEncoder(w).Encode(map[string]interface{}{"id": task.ID, "status": task.Status})
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// validatePlot проверяет выражение, отрезок, число точек и переменную графика.
+// Если переменная не указана, это единственное имя без значения в variables.
+func validatePlot(task *Task) (calculation.Node, int, string) {
+    ast, status, message := parseExpression(task.Expression)
+    if status != 0 {
+        return nil, status, message
+    }
+    if len(task.Range) != 2 || !(task.Range[0] < task.Range[1]) || math.IsInf(task.Range[0], 0) || math.IsInf(task.Range[1], 0) {
+        return nil, http.StatusUnprocessableEntity, "range должен быть отрезком [min, max], min < max"
+    }
+    if task.Samples == 0 {
+        task.Samples = DefaultPlotSamples
+    }
+    if task.Samples < 2 || (MaxPlotSamples > 0 && task.Samples > MaxPlotSamples) {
+        fmt.Println("Ошибка: недопустимое число точек графика:", task.Samples)
+        return nil, http.StatusUnprocessableEntity, fmt.Sprintf("samples должно быть от 2 до %d", MaxPlotSamples)
+    }
+
+    if task.Variable == "" {
+        unknown := unknownVariables(task.Variables, ast)
+        if len(unknown) != 1 {
+            fmt.Println("Ошибка: не удалось определить переменную графика:", unknown)
+            return nil, http.StatusUnprocessableEntity, "Укажите переменную графика (variable)"
+        }
+        task.Variable = unknown[0]
+    }
+    if !IdentRegex.MatchString(task.Variable) {
+        return nil, http.StatusUnprocessableEntity, "Некорректная переменная графика"
+    }
+    if _, bound := task.Variables[task.Variable]; bound {
+        return nil, http.StatusUnprocessableEntity, "Переменная графика не должна иметь значения в variables"
+    }
+
+    env := map[string]float64{task.Variable: 0}
+    for name, value := range task.Variables {
+        env[name] = value
+    }
+    if err := calculation.Validate(ast, env); err != nil {
+        fmt.Println("Ошибка проверки выражения:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректное выражение: " + err.Error()
+    }
+    if status, message := requireNumber(ast); status != 0 {
+        return nil, status, message
+    }
+    return ast, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// splitPlot делит точки графика на задачи по PlotPartSamples точек. Каждая часть получает
+// свой участок отрезка с концами в точках общей сетки.
+func splitPlot(task Task, ast calculation.Node) []Task {
+    size := PlotPartSamples
+    if size < 2 {
+        size = 2
+    }
+    var parts []Task
+    for first := 0; first < task.Samples; first += size {
+        last := first + size - 1
+        if last >= task.Samples {
+            last = task.Samples - 1
+        }
+        part := task
+        part.Range = []float64{
+            calculation.SampleX(task.Range[0], task.Range[1], first, task.Samples),
+            calculation.SampleX(task.Range[0], task.Range[1], last, task.Samples),
+        }
+        part.Samples = last - first + 1
+        part.cacheKey = plotKey(ast, part)
+        parts = append(parts, part)
+    }
+    return parts
+}
+//_______________________________________________________________________________________________________________________________
+
+// plotKey возвращает ключ кеша для графика: переменная, отрезок, число точек и нормализованное выражение.
+func plotKey(ast calculation.Node, task Task) string {
+    return fmt.Sprintf("plot %s in %v by %d: %s", task.Variable, task.Range, task.Samples, expressionKey(ast, task.Variables))
+}
+//_______________________________________________________________________________________________________________________________
+
+// writePlot отправляет точки графика в JSON или SVG.
+func writePlot(w http.ResponseWriter, format string, task Task) {
+    if format == "svg" {
+        w.Header().Set("Content-Type", "image/svg+xml")
+        w.Write([]byte(renderSVG(task.Expression, task.Points)))
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(PlotResponse{
+        ID:         task.ID,
+        Expression: task.Expression,
+        Variable:   task.Variable,
+        Range:      task.Range,
+        Samples:    task.Samples,
+        Points:     task.Points,
+    })
+}
+//_______________________________________________________________________________________________________________________________
+
+// renderSVG рисует график ломаной линией. Точки без значения разрывают линию,
+// масштаб по оси Y подбирается по вычисленным значениям.
+func renderSVG(title string, points []calculation.Point) string {
+    minX, maxX := 0.0, 1.0
+    if len(points) > 0 {
+        minX, maxX = points[0].X, points[len(points)-1].X
+    }
+    minY, maxY := math.Inf(1), math.Inf(-1)
+    for _, point := range points {
+        if point.Y != nil {
+            minY = math.Min(minY, *point.Y)
+            maxY = math.Max(maxY, *point.Y)
+        }
+    }
+    if math.IsInf(minY, 1) {
+        minY, maxY = -1, 1 // ни одной точки со значением
+    } else if minY == maxY {
+        minY, maxY = minY-1, maxY+1
+    }
+
+    left, right := float64(plotMargin), float64(plotWidth-plotMargin/2)
+    top, bottom := float64(plotMargin/2), float64(plotHeight-plotMargin)
+    screenX := func(x float64) float64 { return left + (x-minX)/(maxX-minX)*(right-left) }
+    screenY := func(y float64) float64 { return bottom - (y-minY)/(maxY-minY)*(bottom-top) }
+    label := func(v float64) string { return strconv.FormatFloat(v, 'g', 6, 64) }
+
+    var svg strings.Builder
+    fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", plotWidth, plotHeight, plotWidth, plotHeight)
+    fmt.Fprintf(&svg, `<title>%s</title>`+"\n", html.EscapeString(title))
+    fmt.Fprintf(&svg, `<rect x="0" y="0" width="%d" height="%d" fill="white"/>`+"\n", plotWidth, plotHeight)
+    fmt.Fprintf(&svg, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="none" stroke="#999"/>`+"\n", left, top, right-left, bottom-top)
+
+    // Оси координат, если они попадают в область графика
+    if minY < 0 && maxY > 0 {
+        fmt.Fprintf(&svg, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ccc"/>`+"\n", left, screenY(0), right, screenY(0))
+    }
+    if minX < 0 && maxX > 0 {
+        fmt.Fprintf(&svg, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ccc"/>`+"\n", screenX(0), top, screenX(0), bottom)
+    }
+
+    // Подписи границ отрезка и значений
+    fmt.Fprintf(&svg, `<g font-family="sans-serif" font-size="12" fill="#333">`+"\n")
+    fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="start">%s</text>`+"\n", left, bottom+16, label(minX))
+    fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="end">%s</text>`+"\n", right, bottom+16, label(maxX))
+    fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="end">%s</text>`+"\n", left-4, bottom, label(minY))
+    fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="end">%s</text>`+"\n", left-4, top+12, label(maxY))
+    fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", (left+right)/2, float64(plotHeight-8), html.EscapeString(title))
+    svg.WriteString("</g>\n")
+
+    // Ломаная: каждая непрерывная серия точек — отдельный polyline, одиночная точка — кружок
+    var segment []string
+    flush := func() {
+        if len(segment) == 1 {
+            coordinates := strings.Split(segment[0], ",")
+            fmt.Fprintf(&svg, `<circle cx="%s" cy="%s" r="1.5" fill="#1f77b4"/>`+"\n", coordinates[0], coordinates[1])
+        } else if len(segment) > 1 {
+            fmt.Fprintf(&svg, `<polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="%s"/>`+"\n", strings.Join(segment, " "))
+        }
+        segment = segment[:0]
+    }
+    for _, point := range points {
+        if point.Y == nil {
+            flush()
+            continue
+        }
+        segment = append(segment, fmt.Sprintf("%.2f,%.2f", screenX(point.X), screenY(*point.Y)))
+    }
+    flush()
+
+    svg.WriteString("</svg>\n")
+    return svg.String()
+}
+//_______________________________________________________________________________________________________________________________
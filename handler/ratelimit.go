@@ -0,0 +1,109 @@
+package handler
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// tokenBucket — "ведро" токенов одного клиента.
+type tokenBucket struct {
+    tokens float64   // сколько запросов клиент может сделать прямо сейчас
+    last   time.Time // момент последнего пополнения
+}
+
+var (
+    rateBuckets    = make(map[string]*tokenBucket) // Ведра токенов по ключу клиента
+    RateLimitMutex sync.Mutex
+)
+
+// Сколько вёдер храним, прежде чем удалять заполненные (неактивные) вёдра.
+const maxIdleBuckets = 10000
+//_______________________________________________________________________________________________________________________________
+
+// ClientKey определяет клиента запроса: по заголовку X-API-Key из APIKeys, иначе — по IP-адресу.
+// Неизвестный ключ не даёт отдельного ведра: иначе новый ключ в каждом запросе обходил бы лимит.
+// Ключ API хешируется, так как результат виден другим клиентам в поле owner задачи.
+func ClientKey(r *http.Request) string {
+    if key := r.Header.Get("X-API-Key"); APIKeys[key] {
+        sum := sha256.Sum256([]byte(key))
+        return "key:" + hex.EncodeToString(sum[:8])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    return "ip:" + host
+}
+//_______________________________________________________________________________________________________________________________
+
+// allowRequest списывает токен из ведра клиента.
+// Если токенов нет, возвращает false и время, через которое появится следующий токен.
+func allowRequest(client string, now time.Time) (bool, time.Duration) {
+    RateLimitMutex.Lock()
+    defer RateLimitMutex.Unlock()
+
+    if RateLimitPerSecond <= 0 {
+        return true, 0 // Ограничение выключено
+    }
+
+    bucket, exists := rateBuckets[client]
+    if !exists {
+        if len(rateBuckets) >= maxIdleBuckets {
+            evictIdleBuckets(now)
+        }
+        bucket = &tokenBucket{tokens: float64(RateLimitBurst), last: now}
+        rateBuckets[client] = bucket
+    }
+
+    // Пополняем ведро пропорционально прошедшему времени
+    elapsed := now.Sub(bucket.last).Seconds()
+    if elapsed > 0 {
+        bucket.tokens = math.Min(float64(RateLimitBurst), bucket.tokens+elapsed*RateLimitPerSecond)
+        bucket.last = now
+    }
+
+    if bucket.tokens >= 1 {
+        bucket.tokens--
+        return true, 0
+    }
+
+    wait := (1 - bucket.tokens) / RateLimitPerSecond
+    return false, time.Duration(wait * float64(time.Second))
+}
+//_______________________________________________________________________________________________________________________________
+
+// evictIdleBuckets удаляет вёдра, которые успели заполниться полностью:
+// такие клиенты давно не присылали запросов и ничего не теряют при удалении.
+func evictIdleBuckets(now time.Time) {
+    for client, bucket := range rateBuckets {
+        refilled := bucket.tokens + now.Sub(bucket.last).Seconds()*RateLimitPerSecond
+        if refilled >= float64(RateLimitBurst) {
+            delete(rateBuckets, client)
+        }
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// ResetRateLimits очищает все вёдра токенов (используется в тестах и при смене настроек).
+func ResetRateLimits() {
+    RateLimitMutex.Lock()
+    defer RateLimitMutex.Unlock()
+    rateBuckets = make(map[string]*tokenBucket)
+}
+//_______________________________________________________________________________________________________________________________
+
+// setRetryAfter выставляет заголовок Retry-After, округляя ожидание вверх до целых секунд.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+    seconds := int(math.Ceil(wait.Seconds()))
+    if seconds < 1 {
+        seconds = 1
+    }
+    w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,260 @@
+package handler
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// Schedule — выражение, которое вычисляется повторно по расписанию cron.
+type Schedule struct {
+    ID         int    `json:"id"`
+    Expression string `json:"expression"`
+    Cron       string `json:"schedule"`
+    Priority   int    `json:"priority,omitempty"`
+    Owner      string `json:"owner,omitempty"`
+    TaskOptions
+    Unit    string        `json:"unit,omitempty"`     // в какую единицу переводить результат (mode: "units")
+    NextRun *time.Time    `json:"next_run,omitempty"` // nil — расписание остановлено или больше не наступит
+    Active  bool          `json:"active"`
+    History []ScheduleRun `json:"history"`
+
+    spec     *cronSpec
+    cacheKey string
+}
+
+// ScheduleRun — один запуск расписания: созданная задача и её результат.
+type ScheduleRun struct {
+    TaskID    int       `json:"task_id"`
+    StartedAt time.Time `json:"started_at"`
+    Status    string    `json:"status"`
+    TaskResult
+    Error string `json:"error,omitempty"`
+}
+
+var (
+    // Отложенные задачи (run_at/delay), ещё не попавшие в очередь. Доступ под TaskMutex.
+    ScheduledTasks = make(map[int]Task)
+    // Повторяющиеся расписания. Доступ под TaskMutex.
+    Schedules         = make(map[int]*Schedule)
+    ScheduleIDCounter int
+)
+//_______________________________________________________________________________________________________________________________
+
+// newSchedule создаёт расписание для задачи. Первый запуск — не раньше start. Вызывается под TaskMutex.
+func newSchedule(task Task, spec *cronSpec, start time.Time) *Schedule {
+    ScheduleIDCounter++
+    schedule := &Schedule{
+        ID:          ScheduleIDCounter,
+        Expression:  task.Expression,
+        Cron:        task.Schedule,
+        Priority:    task.Priority,
+        Owner:       task.Owner,
+        TaskOptions: task.TaskOptions,
+        Unit:        task.Unit,
+        NextRun:     nextRun(spec, start),
+        Active:      true,
+        History:     []ScheduleRun{},
+        spec:        spec,
+        cacheKey:    task.cacheKey,
+    }
+    Schedules[schedule.ID] = schedule
+    fmt.Printf("Расписание добавлено: ID=%d, Выражение=%s, Расписание=%s\n", schedule.ID, schedule.Expression, schedule.Cron)
+    return schedule
+}
+//_______________________________________________________________________________________________________________________________
+
+// writeScheduleCreated отвечает на создание расписания (и на повтор запроса с тем же Idempotency-Key):
+// ID расписания и время следующего запуска. Вызывается под TaskMutex.
+func writeScheduleCreated(w http.ResponseWriter, id int) {
+    var next *time.Time
+    if schedule, exists := Schedules[id]; exists {
+        next = schedule.NextRun
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{"schedule_id": id, "next_run": next})
+}
+//_______________________________________________________________________________________________________________________________
+
+// MarshalJSON записывает расписание в JSON: переменные-списки — в variables вместе с числами.
+func (s Schedule) MarshalJSON() ([]byte, error) {
+    type plain Schedule
+    return json.Marshal(struct {
+        plain
+        Variables map[string]interface{} `json:"variables,omitempty"`
+    }{plain(s), calculation.JoinVariables(s.Variables, s.Lists)})
+}
+//_______________________________________________________________________________________________________________________________
+
+// MarshalJSON записывает запуск расписания в JSON. Мнимая часть, целочисленный результат, логическое значение
+// (только у завершённого запуска) и вектор или матрица — отдельными полями imag, integer, boolean и array.
+func (r ScheduleRun) MarshalJSON() ([]byte, error) {
+    type plain ScheduleRun
+    var boolean *bool
+    if r.Boolean && r.Status == "completed" {
+        value := r.Result != 0
+        boolean = &value
+    }
+    return json.Marshal(struct {
+        plain
+        Imag    float64         `json:"imag,omitempty"`
+        Integer string          `json:"integer,omitempty"`
+        Boolean *bool           `json:"boolean,omitempty"`
+        Array   json.RawMessage `json:"array,omitempty"`
+    }{plain(r), r.Imag, r.Integer, boolean, r.Array})
+}
+//_______________________________________________________________________________________________________________________________
+
+// nextRun возвращает следующий запуск после after или nil, если расписание больше не наступит.
+func nextRun(spec *cronSpec, after time.Time) *time.Time {
+    next := spec.next(after)
+    if next.IsZero() {
+        return nil
+    }
+    return &next
+}
+//_______________________________________________________________________________________________________________________________
+
+// ReleaseDueTasks отправляет в очередь отложенные задачи, время которых наступило,
+// и создаёт задачи для расписаний. Если очередь переполнена, задачи ждут следующего вызова.
+func ReleaseDueTasks(now time.Time) {
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+
+    // Отложенные задачи — в порядке времени запуска
+    var due []Task
+    for _, task := range ScheduledTasks {
+        if task.RunAt == nil || !task.RunAt.After(now) {
+            due = append(due, task)
+        }
+    }
+    sort.Slice(due, func(i, j int) bool {
+        if due[i].RunAt != nil && due[j].RunAt != nil && !due[i].RunAt.Equal(*due[j].RunAt) {
+            return due[i].RunAt.Before(*due[j].RunAt)
+        }
+        return due[i].ID < due[j].ID
+    })
+    for _, task := range due {
+        if _, ok := dispatchTask(task, now, false); !ok {
+            fmt.Println("Очередь переполнена, отложенные задачи ждут следующей проверки")
+            return
+        }
+        delete(ScheduledTasks, task.ID)
+    }
+
+    // Повторяющиеся расписания
+    for _, schedule := range Schedules {
+        if !schedule.Active || schedule.NextRun == nil || schedule.NextRun.After(now) {
+            continue
+        }
+        task := Task{
+            Expression:  schedule.Expression,
+            Priority:    schedule.Priority,
+            Owner:       schedule.Owner,
+            TaskOptions: schedule.TaskOptions,
+            TaskResult:  TaskResult{Unit: schedule.Unit}, // единица, в которую переводится результат
+            ScheduleID:  schedule.ID,
+            cacheKey:    schedule.cacheKey,
+        }
+        // Сначала записываем запуск в историю: при попадании в кеш задача завершится сразу
+        TaskIDCounter++
+        task.ID = TaskIDCounter
+        schedule.History = append(schedule.History, ScheduleRun{TaskID: task.ID, StartedAt: now, Status: "pending"})
+        if _, ok := dispatchTask(task, now, true); !ok {
+            schedule.History = schedule.History[:len(schedule.History)-1]
+            fmt.Println("Очередь переполнена, запуск расписания отложен:", schedule.ID)
+            return
+        }
+        if len(schedule.History) > ScheduleHistoryLimit {
+            schedule.History = schedule.History[len(schedule.History)-ScheduleHistoryLimit:]
+        }
+        schedule.NextRun = nextRun(schedule.spec, now)
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// recordScheduleRun обновляет историю расписания, когда задача запуска завершилась. Вызывается под TaskMutex.
+func recordScheduleRun(task Task) {
+    schedule, exists := Schedules[task.ScheduleID]
+    if !exists {
+        return
+    }
+    for i := len(schedule.History) - 1; i >= 0; i-- {
+        if schedule.History[i].TaskID == task.ID {
+            schedule.History[i].Status = task.Status
+            schedule.History[i].TaskResult = task.TaskResult
+            schedule.History[i].Error = task.Error
+            return
+        }
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// StartScheduler запускает фоновую проверку отложенных задач и расписаний.
+func StartScheduler(interval time.Duration) {
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for now := range ticker.C {
+            ReleaseDueTasks(now)
+        }
+    }()
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для получения списка расписаний
+func GetAllSchedules(w http.ResponseWriter, r *http.Request) {
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+
+    schedules := []Schedule{}
+    for _, schedule := range Schedules {
+        schedules = append(schedules, *schedule)
+    }
+    sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string][]Schedule{"schedules": schedules})
+}
+//_______________________________________________________________________________________________________________________________
+
+// Эндпоинт для расписания по ID: GET — получить с историей запусков, DELETE — остановить
+func ScheduleByID(w http.ResponseWriter, r *http.Request) {
+    idStr := r.URL.Path[len("/api/v1/schedules/"):] // Парсим ID из URL
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        http.Error(w, "Некорректный идентификатор", http.StatusBadRequest) // 400
+        return
+    }
+
+    TaskMutex.Lock()
+    defer TaskMutex.Unlock()
+
+    schedule, exists := Schedules[id]
+    if !exists {
+        http.Error(w, "Расписание не найдено", http.StatusNotFound) // 404
+        return
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodDelete:
+        schedule.Active = false
+        schedule.NextRun = nil
+        fmt.Println("Расписание остановлено:", id)
+    default:
+        w.Header().Set("Allow", "GET, DELETE")
+        http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed) // 405
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]Schedule{"schedule": *schedule})
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,176 @@
+package handler
+
+import (
+    "container/heap"
+)
+
+// Уровни приоритета задач: 0 — обычный, MaxPriority — самый срочный.
+const MaxPriority = 9
+
+// TaskHeap — очередь задач в виде двоичной кучи.
+// Задачи разных владельцев выдаются по справедливому разделению (weighted fair queuing),
+// приоритет меняет порядок только внутри задач одного владельца (см. dequeueTask).
+type TaskHeap []Task
+
+func (h TaskHeap) Len() int { return len(h) }
+
+func (h TaskHeap) Less(i, j int) bool {
+    if h[i].virtualFinish != h[j].virtualFinish {
+        return h[i].virtualFinish < h[j].virtualFinish
+    }
+    return h[i].ID < h[j].ID
+}
+
+func (h TaskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *TaskHeap) Push(x interface{}) { *h = append(*h, x.(Task)) }
+
+func (h *TaskHeap) Pop() interface{} {
+    old := *h
+    task := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return task
+}
+//_______________________________________________________________________________________________________________________________
+
+var (
+    // Задачи, выданные агентам и ещё не завершённые. Доступ под TaskMutex.
+    InProgressTasks = make(map[int]Task)
+
+    // Виртуальное время справедливой очереди и виртуальное время окончания
+    // последней задачи каждого владельца. Доступ под TaskMutex.
+    virtualTime float64
+    ownerFinish = make(map[string]float64)
+
+    // Число задач каждого владельца в очереди по уровням приоритета. Доступ под TaskMutex.
+    ownerQueued = make(map[string]*[MaxPriority + 1]int)
+)
+//_______________________________________________________________________________________________________________________________
+
+// ownerWeight возвращает вес владельца в справедливой очереди (по умолчанию 1).
+func ownerWeight(owner string) float64 {
+    if weight, ok := OwnerWeights[owner]; ok && weight > 0 {
+        return weight
+    }
+    return 1
+}
+//_______________________________________________________________________________________________________________________________
+
+// enqueueTask ставит задачу в очередь за O(log n). Вызывается под TaskMutex.
+//
+// Каждой задаче назначается виртуальное время окончания: следующая задача владельца
+// "заканчивается" на 1/вес позже предыдущей, но не раньше текущего виртуального времени.
+// Поэтому владелец с тысячей задач в очереди не задерживает задачу другого владельца:
+// она получает время рядом с текущим и выдаётся почти сразу.
+func enqueueTask(task Task) {
+    start := virtualTime
+    if finish, ok := ownerFinish[task.Owner]; ok && finish > start {
+        start = finish
+    }
+    task.virtualFinish = start + 1/ownerWeight(task.Owner)
+    ownerFinish[task.Owner] = task.virtualFinish
+
+    pushQueuedTask(task)
+}
+//_______________________________________________________________________________________________________________________________
+
+// pushQueuedTask кладёт в кучу задачу с уже назначенным виртуальным временем. Вызывается под TaskMutex.
+func pushQueuedTask(task Task) {
+    countQueued(task, 1)
+    heap.Push(&TaskQueue, task)
+}
+//_______________________________________________________________________________________________________________________________
+
+// removeQueuedTask удаляет из кучи задачу с индексом i. Вызывается под TaskMutex.
+func removeQueuedTask(i int) Task {
+    task := heap.Remove(&TaskQueue, i).(Task)
+    countQueued(task, -1)
+    return task
+}
+//_______________________________________________________________________________________________________________________________
+
+// countQueued учитывает задачу в числе задач владельца с её приоритетом. Вызывается под TaskMutex.
+func countQueued(task Task, delta int) {
+    counts := ownerQueued[task.Owner]
+    if counts == nil {
+        counts = new([MaxPriority + 1]int)
+        ownerQueued[task.Owner] = counts
+    }
+    counts[task.Priority] += delta
+
+    for _, count := range counts {
+        if count > 0 {
+            return
+        }
+    }
+    delete(ownerQueued, task.Owner)
+}
+//_______________________________________________________________________________________________________________________________
+
+// hasUrgentTask сообщает, есть ли у владельца задачи в очереди с приоритетом выше priority.
+// Вызывается под TaskMutex.
+func hasUrgentTask(owner string, priority int) bool {
+    counts := ownerQueued[owner]
+    if counts == nil {
+        return false
+    }
+    for p := priority + 1; p <= MaxPriority; p++ {
+        if counts[p] > 0 {
+            return true
+        }
+    }
+    return false
+}
+//_______________________________________________________________________________________________________________________________
+
+// dequeueTask извлекает следующую задачу. Вызывается под TaskMutex, очередь не пуста.
+//
+// Очередной слот справедливой очереди получает владелец, у которого он наступает раньше всех,
+// а в этот слот выдаётся самая срочная задача этого владельца. Поэтому приоритет ускоряет задачи
+// клиента только относительно его же задач, и клиент с задачами приоритета 9 не отнимает
+// у остальных больше своей доли. Без задач разного приоритета у владельца выдача занимает O(log n),
+// иначе к ней добавляется проход по очереди в поисках самой срочной задачи.
+func dequeueTask() Task {
+    task := removeQueuedTask(0)
+
+    if hasUrgentTask(task.Owner, task.Priority) {
+        urgent := -1
+        for i, other := range TaskQueue {
+            if other.Owner != task.Owner || other.Priority <= task.Priority {
+                continue
+            }
+            if urgent < 0 || other.Priority > TaskQueue[urgent].Priority ||
+                (other.Priority == TaskQueue[urgent].Priority && other.ID < TaskQueue[urgent].ID) {
+                urgent = i
+            }
+        }
+        // Срочная задача занимает слот извлечённой, а та возвращается в очередь на место срочной
+        if urgent >= 0 {
+            slot := task.virtualFinish
+            task.virtualFinish = TaskQueue[urgent].virtualFinish
+            urgentTask := removeQueuedTask(urgent)
+            pushQueuedTask(task)
+            task = urgentTask
+            task.virtualFinish = slot
+        }
+    }
+
+    if task.virtualFinish > virtualTime {
+        virtualTime = task.virtualFinish
+    }
+    // Владелец без задач впереди виртуального времени ничем не отличается от нового
+    if finish, ok := ownerFinish[task.Owner]; ok && finish <= virtualTime {
+        delete(ownerFinish, task.Owner)
+    }
+    return task
+}
+//_______________________________________________________________________________________________________________________________
+
+// resetScheduler сбрасывает состояние справедливой очереди. Вызывается под TaskMutex.
+func resetScheduler() {
+    InProgressTasks = make(map[int]Task)
+    virtualTime = 0
+    ownerFinish = make(map[string]float64)
+    ownerQueued = make(map[string]*[MaxPriority + 1]int)
+}
+//_______________________________________________________________________________________________________________________________
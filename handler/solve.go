@@ -0,0 +1,103 @@
+package handler
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+// validateEquation проверяет задачу решения уравнения (type: "solve"): синтаксис обеих частей,
+// диапазон поиска и переменную. Если переменная не указана, она определяется по уравнению —
+// это единственное имя без значения в variables. Возвращает разобранное уравнение
+// или код ответа и сообщение об ошибке.
+func validateEquation(task *Task) (*calculation.Equation, int, string) {
+    if len(task.Lists) > 0 {
+        return nil, http.StatusUnprocessableEntity, "Списки в variables доступны только для вычисления выражений"
+    }
+    if status, message := checkExpressionText(task.Expression); status != 0 {
+        return nil, status, message
+    }
+
+    equation, err := calculation.ParseEquation(task.Expression)
+    if err != nil {
+        fmt.Println("Ошибка разбора уравнения:", err)
+        return nil, http.StatusUnprocessableEntity, "Некорректное уравнение"
+    }
+    if MaxASTDepth > 0 && (calculation.Depth(equation.Left) > MaxASTDepth || calculation.Depth(equation.Right) > MaxASTDepth) {
+        countRejection(&Rejections.TooDeep)
+        fmt.Println("Ошибка: слишком глубокая вложенность уравнения:", task.Expression)
+        return nil, http.StatusUnprocessableEntity, "Слишком глубокая вложенность выражения"
+    }
+    if task.Trace {
+        return nil, http.StatusUnprocessableEntity, "Пошаговое решение недоступно для уравнений"
+    }
+    if len(task.Range) != 0 && (len(task.Range) != 2 || !(task.Range[0] < task.Range[1])) {
+        return nil, http.StatusUnprocessableEntity, "range должен быть отрезком [min, max], min < max"
+    }
+
+    // Переменная, относительно которой решается уравнение
+    if task.Variable == "" {
+        unknown := unknownVariables(task.Variables, equation.Left, equation.Right)
+        if len(unknown) != 1 {
+            fmt.Println("Ошибка: не удалось определить переменную уравнения:", unknown)
+            return nil, http.StatusUnprocessableEntity, "Укажите переменную уравнения (variable)"
+        }
+        task.Variable = unknown[0]
+    }
+    if !IdentRegex.MatchString(task.Variable) {
+        return nil, http.StatusUnprocessableEntity, "Некорректная переменная уравнения"
+    }
+    if _, bound := task.Variables[task.Variable]; bound {
+        return nil, http.StatusUnprocessableEntity, "Переменная уравнения не должна иметь значения в variables"
+    }
+
+    // Остальные переменные заданы, функции существуют
+    env := map[string]float64{task.Variable: 0}
+    for name, value := range task.Variables {
+        env[name] = value
+    }
+    for _, side := range []calculation.Node{equation.Left, equation.Right} {
+        if err := calculation.Validate(side, env); err != nil {
+            fmt.Println("Ошибка проверки уравнения:", err)
+            return nil, http.StatusUnprocessableEntity, "Некорректное уравнение: " + err.Error()
+        }
+    }
+    if status, message := requireNumber(equation.Left, equation.Right); status != 0 {
+        return nil, status, message
+    }
+    return equation, 0, ""
+}
+//_______________________________________________________________________________________________________________________________
+
+// equationKey возвращает ключ кеша для решения уравнения: переменная, диапазон, нормализованные части и значения переменных.
+func equationKey(equation *calculation.Equation, task Task) string {
+    return fmt.Sprintf("solve %s in %v: %s", task.Variable, task.Range,
+        expressionKey(&calculation.BinaryOp{Operator: "=", Left: equation.Left, Right: equation.Right}, task.Variables))
+}
+
+// unknownVariables возвращает имена переменных выражений, у которых нет значения в variables (кроме констант).
+func unknownVariables(variables map[string]float64, nodes ...calculation.Node) []string {
+    var unknown []string
+    for _, node := range nodes {
+        for _, name := range calculation.Variables(node) {
+            _, bound := variables[name]
+            _, constant := calculation.Constants[name]
+            if !bound && !constant && !contains(unknown, name) {
+                unknown = append(unknown, name)
+            }
+        }
+    }
+    return unknown
+}
+//_______________________________________________________________________________________________________________________________
+
+func contains(names []string, name string) bool {
+    for _, existing := range names {
+        if existing == name {
+            return true
+        }
+    }
+    return false
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,61 @@
+package handler
+
+import (
+    "strconv"
+    "time"
+)
+
+// Каналы ожидания завершения задач: канал закрывается, когда задача переходит
+// в конечное состояние, и все ожидающие обработчики просыпаются одновременно. Доступ под TaskMutex.
+var taskWaiters = make(map[int]chan struct{})
+//_______________________________________________________________________________________________________________________________
+
+// isTerminal сообщает, что задача больше не изменится.
+func isTerminal(status string) bool {
+    return status == "completed" || status == "failed" || status == "cancelled"
+}
+//_______________________________________________________________________________________________________________________________
+
+// waitChannel возвращает канал, который закроется при завершении задачи. Вызывается под TaskMutex.
+func waitChannel(id int) chan struct{} {
+    ch, exists := taskWaiters[id]
+    if !exists {
+        ch = make(chan struct{})
+        taskWaiters[id] = ch
+    }
+    return ch
+}
+//_______________________________________________________________________________________________________________________________
+
+// notifyWaiters будит всех, кто ждёт завершения задачи. Вызывается под TaskMutex.
+func notifyWaiters(id int) {
+    if ch, exists := taskWaiters[id]; exists {
+        close(ch)
+        delete(taskWaiters, id)
+    }
+}
+//_______________________________________________________________________________________________________________________________
+
+// parseWait разбирает параметр ?wait=: длительность ("10s", "500ms") или число секунд ("10").
+// Слишком долгое ожидание ограничивается MaxWaitTimeout.
+func parseWait(raw string) (time.Duration, error) {
+    if raw == "" {
+        return 0, nil
+    }
+    wait, err := time.ParseDuration(raw)
+    if err != nil {
+        seconds, convErr := strconv.ParseFloat(raw, 64)
+        if convErr != nil {
+            return 0, err
+        }
+        wait = time.Duration(seconds * float64(time.Second))
+    }
+    if wait < 0 {
+        wait = 0
+    }
+    if wait > MaxWaitTimeout {
+        wait = MaxWaitTimeout
+    }
+    return wait, nil
+}
+//_______________________________________________________________________________________________________________________________
@@ -0,0 +1,922 @@
+package test
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/gulovv/web_calculator/calculation"
+)
+
+func TestTrace(t *testing.T) {
+    expression := "(2+3)*4 - 30"
+    ast, err := calculation.Parse(expression)
+    if err != nil {
+        t.Fatalf("Ошибка разбора выражения: %v", err)
+    }
+
+    steps, err := calculation.Trace(expression, ast, nil)
+    if err != nil {
+        t.Fatalf("Ошибка вычисления: %v", err)
+    }
+    expected := []calculation.Step{
+        {Expression: "(2+3)", Span: calculation.Span{Start: 0, End: 5}, Result: 5, Rewritten: "5*4 - 30"},
+        {Expression: "5*4", Span: calculation.Span{Start: 0, End: 7}, Result: 20, Rewritten: "20 - 30"},
+        {Expression: "20 - 30", Span: calculation.Span{Start: 0, End: 12}, Result: -10, Rewritten: "-10"},
+    }
+    if len(steps) != len(expected) {
+        t.Fatalf("Ожидалось %d шага, но получили %+v", len(expected), steps)
+    }
+    for i := range expected {
+        if steps[i] != expected[i] {
+            t.Errorf("Шаг %d: ожидалось %+v, но получили %+v", i+1, expected[i], steps[i])
+        }
+    }
+
+    // Отрицательный промежуточный результат берётся в скобки
+    expression = "2 * (1 - 4)"
+    ast, _ = calculation.Parse(expression)
+    steps, _ = calculation.Trace(expression, ast, nil)
+    if len(steps) != 2 || steps[0].Rewritten != "2 * (-3)" {
+        t.Errorf("Ожидалось промежуточное выражение 2 * (-3), но получили %+v", steps)
+    }
+
+    // Функции и переменные сворачиваются по значениям уже вычисленных аргументов
+    expression = "sqrt(x) * 2 + max(1, x, -x)"
+    ast, _ = calculation.Parse(expression)
+    steps, err = calculation.Trace(expression, ast, map[string]float64{"x": 16})
+    if err != nil || len(steps) != 5 || steps[4].Result != 24 || steps[2].Rewritten != "8 + max(1, 16, (-16))" {
+        t.Errorf("Ожидалось 5 шагов с результатом 24, но получили %+v (%v)", steps, err)
+    }
+}
+
+func TestEval(t *testing.T) {
+    variables := map[string]float64{"x": 3, "rate": 0.5}
+    tests := []struct {
+        expression string
+        expected   float64
+    }{
+        {"2 + 3 * 4", 14},
+        {"9 - 4 - 3", 2},
+        {"-2^2", -4},
+        {"2^3^2", 512},
+        {"-(2 + 3) * -2", 10},
+        {"sqrt(16) + max(1, x, 2)", 7},
+        {"2 * pi * rate", 3.141592653589793},
+        {"x / (x - 1)", 1.5},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        result, err := calculation.Eval(ast, variables)
+        if err != nil || result != test.expected {
+            t.Errorf("%s: ожидалось %v, но получили %v (ошибка: %v)", test.expression, test.expected, result, err)
+        }
+    }
+
+    // Деление на ноль обнаруживается при вычислении, а не при разборе
+    ast, err := calculation.Parse("1 / (x - 3)")
+    if err != nil {
+        t.Fatalf("Выражение должно разбираться, но получили ошибку: %v", err)
+    }
+    if _, err := calculation.Eval(ast, variables); err == nil {
+        t.Error("Ожидалась ошибка деления на ноль")
+    }
+
+    // Неизвестная переменная и неверное число аргументов
+    for _, expression := range []string{"y + 1", "sqrt(1, 2)", "foo(1)"} {
+        ast, _ := calculation.Parse(expression)
+        if err := calculation.Validate(ast, variables); err == nil {
+            t.Errorf("%s: ожидалась ошибка проверки", expression)
+        }
+    }
+}
+
+func TestSimplify(t *testing.T) {
+    tests := []struct {
+        expression string
+        expected   string
+    }{
+        {"x * 1 + 0", "x"},
+        {"y + x + 1", "x + y + 1"},
+        {"1 + (x + y)", "x + y + 1"},
+        {"2 * 3 + x", "x + 6"},
+        {"3 * x * 2", "6 * x"},
+        {"x - x", "0"},
+        {"(x + y) - (y + x)", "0"},
+        {"-(-x) / 1", "x"},
+        {"0 - x", "-x"},
+        {"2 ^ x ^ 1", "2 ^ x"},
+        {"sqrt(16) * (x - 0)", "4 * x"},
+        {"1 / (2 - 2) + x", "1 / 0 + x"}, // деление на ноль не сворачивается, ошибка останется при вычислении
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        if simplified := calculation.Format(calculation.Simplify(ast)); simplified != test.expected {
+            t.Errorf("%s: ожидалось %q, но получили %q", test.expression, test.expected, simplified)
+        }
+    }
+
+    // Упрощённая запись снова разбирается и даёт тот же результат
+    variables := map[string]float64{"x": 1.5, "y": -2}
+    for _, expression := range []string{"-(x + 1) * 2 - 3 / (y - -1)", "2 ^ -x * (y - x) - -y", "max(x, -y) ^ 2 / (x * y)"} {
+        ast, _ := calculation.Parse(expression)
+        expected, _ := calculation.Eval(ast, variables)
+        formatted := calculation.Format(calculation.Simplify(ast))
+        reparsed, err := calculation.Parse(formatted)
+        if err != nil {
+            t.Errorf("%s: упрощённая запись %q не разбирается: %v", expression, formatted, err)
+            continue
+        }
+        if result, _ := calculation.Eval(reparsed, variables); result != expected {
+            t.Errorf("%s: ожидалось %v, но %q дало %v", expression, expected, formatted, result)
+        }
+    }
+}
+
+func TestDerive(t *testing.T) {
+    tests := []struct {
+        expression string
+        expected   string
+    }{
+        {"x^2 + 3", "2 * x"},
+        {"3 * x * y", "3 * y"},
+        {"sin(x) * x", "cos(x) * x + sin(x)"},
+        {"exp(2 * x)", "2 * exp(2 * x)"},
+        {"pow(x, 3) - y", "3 * x ^ 2"},
+        {"y^2", "0"},
+    }
+    for _, test := range tests {
+        ast, _ := calculation.Parse(test.expression)
+        derivative, err := calculation.Derive(ast, "x")
+        if err != nil {
+            t.Errorf("%s: ошибка дифференцирования: %v", test.expression, err)
+            continue
+        }
+        if text := calculation.Format(derivative); text != test.expected {
+            t.Errorf("%s: ожидалось %q, но получили %q", test.expression, test.expected, text)
+        }
+    }
+
+    // Сравнение с конечной разностью для правил частного, степени и цепного правила
+    const h = 1e-6
+    for _, expression := range []string{"(x + 1) / (x - 2)", "x^x", "ln(x^2 + 1)", "sqrt(x) * atan(x)", "2^x / cos(x)", "log(x) - acos(x / 2)"} {
+        ast, _ := calculation.Parse(expression)
+        derivative, err := calculation.Derive(ast, "x")
+        if err != nil {
+            t.Errorf("%s: ошибка дифференцирования: %v", expression, err)
+            continue
+        }
+        at := 0.7
+        exact, err := calculation.Eval(derivative, map[string]float64{"x": at})
+        if err != nil {
+            t.Errorf("%s: ошибка вычисления производной: %v", expression, err)
+            continue
+        }
+        right, _ := calculation.Eval(ast, map[string]float64{"x": at + h})
+        left, _ := calculation.Eval(ast, map[string]float64{"x": at - h})
+        if numeric := (right - left) / (2 * h); exact-numeric > 1e-5 || numeric-exact > 1e-5 {
+            t.Errorf("%s: производная %v, конечная разность %v", expression, exact, numeric)
+        }
+    }
+
+    // Кусочно-постоянные функции не дифференцируются
+    ast, _ := calculation.Parse("floor(x)")
+    if _, err := calculation.Derive(ast, "x"); err == nil {
+        t.Error("Ожидалась ошибка для floor(x)")
+    }
+}
+
+func TestSolve(t *testing.T) {
+    tests := []struct {
+        equation string
+        expected []float64
+    }{
+        {"2*x + 3 = 7", []float64{2}},              // линейное
+        {"x^2 - 5*x + 6", []float64{2, 3}},         // квадратное, правая часть 0
+        {"x^2 = -a", []float64{}},                  // нет действительных корней (a = 1)
+        {"x^3 - x = 0", []float64{-1, 0, 1}},       // численно: смена знака
+        {"(x - 2)^2 * (x + 1)", []float64{-1, 2}},  // кратный корень без смены знака
+        {"cos(x) = x", []float64{0.7390851332151607}},
+        {"1 / x = 0", []float64{}},                 // полюс не корень
+    }
+    for _, test := range tests {
+        equation, err := calculation.ParseEquation(test.equation)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.equation, err)
+            continue
+        }
+        roots, err := calculation.Solve(equation, "x", map[string]float64{"a": 1}, -10, 10)
+        if err != nil {
+            t.Errorf("%s: ошибка решения: %v", test.equation, err)
+            continue
+        }
+        if len(roots) != len(test.expected) {
+            t.Errorf("%s: ожидались корни %v, но получили %v", test.equation, test.expected, roots)
+            continue
+        }
+        for i := range roots {
+            if diff := roots[i] - test.expected[i]; diff > 1e-9 || diff < -1e-9 {
+                t.Errorf("%s: ожидались корни %v, но получили %v", test.equation, test.expected, roots)
+                break
+            }
+        }
+    }
+
+    // Тождество не имеет конечного списка корней
+    equation, _ := calculation.ParseEquation("2 * x = x + x")
+    if _, err := calculation.Solve(equation, "x", nil, -10, 10); err == nil {
+        t.Error("Ожидалась ошибка для тождества")
+    }
+
+    // "=" допустимо только в уравнении
+    if _, err := calculation.Parse("x = 1"); err == nil {
+        t.Error("Ожидалась ошибка разбора выражения со знаком =")
+    }
+}
+
+func TestIntegrateAndSum(t *testing.T) {
+    tests := []struct {
+        expression string
+        expected   float64
+    }{
+        {"integrate(x^2, x, 0, 3)", 9},
+        {"integrate(sin(t), t, 0, pi)", 2},
+        {"integrate(1 / sqrt(x), x, 0, 1)", 2},       // особенность на конце отрезка
+        {"integrate(exp(x), x, 1, 0)", 1 - math.E},  // пределы в обратном порядке
+        {"integrate(x * y, x, 0, 2)", 6},             // y = 3 из переменных
+        {"sum(i, i, 1, 100)", 5050},
+        {"sum(1 / k^2, k, 1, 100000)", 1.6449240668982263},
+        {"sum(x, x, 5, 1)", 0},                       // пустая сумма
+        {"integrate(sum(x^n, n, 0, 2), x, 0, 1)", 1 + 0.5 + 1.0/3},
+        {"sum(1, i, 2^53 - 3, 2^53)", 4}, // у самой границы счётчик не застревает
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        env := map[string]float64{"y": 3}
+        if err := calculation.Validate(ast, env); err != nil {
+            t.Errorf("%s: ошибка проверки: %v", test.expression, err)
+            continue
+        }
+        result, err := calculation.Eval(ast, env)
+        if err != nil {
+            t.Errorf("%s: ошибка вычисления: %v", test.expression, err)
+            continue
+        }
+        if diff := result - test.expected; diff > 1e-8 || diff < -1e-8 {
+            t.Errorf("%s: ожидалось %v, но получили %v", test.expression, test.expected, result)
+        }
+    }
+
+    // Оценка погрешности интеграла
+    ast, _ := calculation.Parse("integrate(exp(-x^2), x, -3, 3)")
+    value, estimate, err := calculation.EvalWithErrorEstimate(ast, nil)
+    if err != nil || estimate <= 0 || estimate > 1e-9 || math.Abs(value-1.7724146965190422) > 1e-9 {
+        t.Errorf("Ожидался интеграл 1.7724146965 с малой погрешностью, но получили %v ± %v (%v)", value, estimate, err)
+    }
+
+    // sum с четырьмя аргументами — всегда sum(выражение, i, от, до): счётчик с тем же именем,
+    // что у переменной, — ошибка, а не сумма чисел
+    env := map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4}
+    for expression, expected := range map[string]float64{
+        "sum(b * k, k, 1, 3)":   12,
+        "sum([a, b, c, d])":     10,
+        "integrate(b, b, 0, 1)": 0.5, // у integrate связанная переменная перекрывает переменную
+    } {
+        ast, err := calculation.Parse(expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", expression, err)
+            continue
+        }
+        if err := calculation.Validate(ast, env); err != nil {
+            t.Errorf("%s: ошибка проверки: %v", expression, err)
+            continue
+        }
+        if result, err := calculation.Eval(ast, env); err != nil || math.Abs(result-expected) > 1e-9 {
+            t.Errorf("%s: ожидалось %v, но получили %v (%v)", expression, expected, result, err)
+        }
+    }
+    for _, expression := range []string{"sum(a, b, c, d)", "sum(1, 2, 3, 4)", "sum(k * sum(k, k, 1, 2), k, 1, 3)"} {
+        ast, _ := calculation.Parse(expression)
+        if err := calculation.Validate(ast, env); err == nil {
+            t.Errorf("%s: ожидалась ошибка проверки", expression)
+        }
+    }
+
+    // Связанная переменная не является свободной
+    ast, _ = calculation.Parse("integrate(x * a, x, 0, b)")
+    if variables := calculation.Variables(ast); strings.Join(variables, ",") != "a,b" {
+        t.Errorf("Ожидались переменные a,b, но получили %v", variables)
+    }
+
+    for _, expression := range []string{
+        "integrate(x, 1, 0, 1)",  // второй аргумент — не имя переменной
+        "integrate(x, x, 0)",
+        "sum(i, i, 0.5, 2)",      // дробные пределы суммы
+        "integrate(x, x, 0, t)",  // t не задана
+        "sum(i, i, 10^17, 10^17 + 16)", // пределы за 2^53
+        "sum(i, i, 1, 10^8)",           // слишком много слагаемых
+    } {
+        ast, err := calculation.Parse(expression)
+        if err != nil {
+            continue
+        }
+        if _, err := calculation.Eval(ast, nil); err == nil {
+            t.Errorf("%s: ожидалась ошибка", expression)
+        }
+    }
+}
+
+func TestSample(t *testing.T) {
+    ast, _ := calculation.Parse("1 / x + a")
+    points := calculation.Sample(ast, "x", map[string]float64{"a": 1}, -1, 1, 5)
+    expected := []float64{0, -1, 0, 3, 2} // при x = 0 значения нет
+    if len(points) != len(expected) {
+        t.Fatalf("Ожидалось %d точек, но получили %d", len(expected), len(points))
+    }
+    for i, point := range points {
+        if x := -1 + 0.5*float64(i); point.X != x {
+            t.Errorf("Точка %d: ожидался x = %v, но получили %v", i, x, point.X)
+        }
+        if i == 2 {
+            if point.Y != nil || point.Error == "" {
+                t.Errorf("В точке x = 0 ожидалась ошибка, но получили %+v", point)
+            }
+            continue
+        }
+        if point.Y == nil || *point.Y != expected[i] {
+            t.Errorf("Точка %d: ожидалось %v, но получили %+v", i, expected[i], point)
+        }
+    }
+
+    // Корень из отрицательного числа — NaN, точка помечается ошибкой
+    ast, _ = calculation.Parse("sqrt(x)")
+    if points := calculation.Sample(ast, "x", nil, -1, 1, 3); points[0].Y != nil || points[2].Y == nil {
+        t.Errorf("Ожидалась ошибка только в x = -1, но получили %+v", points)
+    }
+}
+
+func TestEvalComplex(t *testing.T) {
+    tests := []struct {
+        expression string
+        expected   complex128
+    }{
+        {"(3+4i)*(1-2i)", 11 - 2i},
+        {"sqrt(-1)", 1i},
+        {"j^2", -1},
+        {"abs(3 + 4j)", 5},
+        {"conj(2 + 3i) + re(5i) + im(2 - 7i)", -5 - 3i},
+        {"arg(-1)", complex(math.Pi, 0)},
+        {"exp(i * pi) + 1", 0},
+        {"1 / (1 + i)", 0.5 - 0.5i},
+        {"2^3i", complex(math.Cos(3*math.Ln2), math.Sin(3*math.Ln2))}, // 2^(3i)
+        {"x * i", 2i},                                                 // x = 2 из переменных
+        {"floor(2.5) + i", 2 + 1i},
+        {"e^(pi i)", -1},                                              // мнимая единица после имени — умножение
+        {"exp(2 pi j)", 1},
+        {"(1 + i)^-2", -0.5i},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        result, err := calculation.EvalComplex(ast, map[string]float64{"x": 2})
+        if err != nil {
+            t.Errorf("%s: ошибка вычисления: %v", test.expression, err)
+            continue
+        }
+        if diff := result - test.expected; math.Abs(real(diff)) > 1e-12 || math.Abs(imag(diff)) > 1e-12 {
+            t.Errorf("%s: ожидалось %v, но получили %v", test.expression, test.expected, result)
+        }
+    }
+
+    // Небольшие целые степени считаются умножением — без погрешности cmplx.Pow
+    for expression, expected := range map[string]complex128{"i^2": -1, "i^3": -1i, "(1 + i)^4": -4, "i^-1": -1i} {
+        ast, _ := calculation.Parse(expression)
+        if result, err := calculation.EvalComplex(ast, nil); err != nil || result != expected {
+            t.Errorf("%s: ожидалось ровно %v, но получили %v (%v)", expression, expected, result, err)
+        }
+    }
+
+    // Переменная i перекрывает мнимую единицу
+    ast, _ := calculation.Parse("i + 1")
+    if result, _ := calculation.EvalComplex(ast, map[string]float64{"i": 2}); result != 3 {
+        t.Errorf("Ожидалось 3, но получили %v", result)
+    }
+
+    for _, expression := range []string{"1 / (i - i)", "floor(i)", "sum(k, k, 1, 2)"} {
+        ast, _ := calculation.Parse(expression)
+        if _, err := calculation.EvalComplex(ast, nil); err == nil {
+            t.Errorf("%s: ожидалась ошибка", expression)
+        }
+    }
+
+    // В действительном режиме sqrt(-1) — ошибка
+    ast, _ = calculation.Parse("sqrt(-1)")
+    if _, err := calculation.Eval(ast, nil); err == nil {
+        t.Error("Ожидалась ошибка sqrt(-1) в действительном режиме")
+    }
+}
+
+func TestEvalUnits(t *testing.T) {
+    tests := []struct {
+        expression string
+        unit       string
+        value      float64
+        result     string
+    }{
+        {"5 km + 300 m", "", 5300, "m"},
+        {"5 km + 300 m", "km", 5.3, "km"},
+        {"60 km/h * 2 h", "km", 120, "km"},
+        {"100 km / (2 h)", "", 100000.0 / 7200, "m/s"},
+        {"100 km / (2 h)", "km/h", 50, "km/h"},
+        {"2 kg * 9.8 m/s^2", "", 19.6, "N"},
+        {"3 m^2 * 2 m", "L", 6000, "L"},
+        {"sqrt(16 m^2)", "", 4, "m"},
+        {"x * 1 kWh", "kJ", 7200, "kJ"}, // x = 2 из переменных
+        {"max(1 ft, 30 cm)", "cm", 30.48, "cm"},
+        {"2 * pi * 3", "", 6 * math.Pi, ""},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        quantity, err := calculation.EvalUnits(ast, map[string]float64{"x": 2})
+        if err != nil {
+            t.Errorf("%s: ошибка вычисления: %v", test.expression, err)
+            continue
+        }
+        value, unit, err := calculation.Convert(quantity, test.unit)
+        if err != nil || unit != test.result || math.Abs(value-test.value) > 1e-9*math.Abs(test.value) {
+            t.Errorf("%s в %q: ожидалось %v %s, но получили %v %s (%v)", test.expression, test.unit, test.value, test.result, value, unit, err)
+        }
+    }
+
+    // Ошибки размерности
+    for _, expression := range []string{"1 m + 1 s", "exp(2 m)", "2^(1 s)", "1 m^0.5", "min(1 m, 1 kg)", "1 m^(10^300)", "(1 m^2)^-60"} {
+        ast, _ := calculation.Parse(expression)
+        _, err := calculation.EvalUnits(ast, nil)
+        if _, ok := err.(*calculation.DimensionError); !ok {
+            t.Errorf("%s: ожидалась ошибка размерности, но получили %v", expression, err)
+        }
+    }
+    ast, _ := calculation.Parse("5 m")
+    quantity, _ := calculation.EvalUnits(ast, nil)
+    if _, _, err := calculation.Convert(quantity, "s"); err == nil {
+        t.Error("Ожидалась ошибка перевода m в s")
+    }
+    if _, err := calculation.ParseUnit("km/parsec"); err == nil {
+        t.Error("Ожидалась ошибка неизвестной единицы")
+    }
+}
+
+func TestEvalInteger(t *testing.T) {
+    tests := []struct {
+        expression string
+        width      string
+        base       int
+        result     string
+    }{
+        {"0xFF & 0b1010", calculation.WidthInt64, 2, "0b1010"},
+        {"0xF0 | 0x0F", calculation.WidthInt64, 16, "0xFF"},
+        {"0xFF xor 0o17", calculation.WidthInt64, 16, "0xF0"},
+        {"1 << 10", calculation.WidthInt64, 10, "1024"},
+        {"-16 >> 2", calculation.WidthInt64, 10, "-4"},
+        {"7 / 2 + 7 // 2 + 7 % 2", calculation.WidthInt64, 10, "7"},
+        {"-7 / 2", calculation.WidthInt64, 10, "-3"},
+        {"-7 // 2", calculation.WidthInt64, 10, "-4"},
+        {"-7 % 2", calculation.WidthInt64, 10, "-1"},
+        {"1 + 2 << 3 & 0xFF", calculation.WidthInt64, 10, "24"}, // ((1 + 2) << 3) & 0xFF
+        {"x * max(3, abs(-5))", calculation.WidthInt64, 10, "10"}, // x = 2 из переменных
+        {"-255", calculation.WidthInt64, 16, "-0xFF"},
+        {"-9223372036854775808 + 1", calculation.WidthInt64, 10, "-9223372036854775807"},
+        {"2^64", calculation.WidthBig, 10, "18446744073709551616"},
+        {"pow(2, 100) - 1", calculation.WidthBig, 16, "0xFFFFFFFFFFFFFFFFFFFFFFFFF"},
+        {"123456789012345678901234567890 + 1", calculation.WidthBig, 10, "123456789012345678901234567891"},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        if err := calculation.ValidateInteger(ast, map[string]float64{"x": 2}); err != nil {
+            t.Errorf("%s: ошибка проверки: %v", test.expression, err)
+            continue
+        }
+        value, err := calculation.EvalInteger(ast, map[string]float64{"x": 2}, test.width)
+        if err != nil {
+            t.Errorf("%s: ошибка вычисления: %v", test.expression, err)
+            continue
+        }
+        if result := calculation.FormatInteger(value, test.base); result != test.result {
+            t.Errorf("%s: ожидалось %s, но получили %s", test.expression, test.result, result)
+        }
+    }
+
+    // Ошибки вычисления: переполнение int64 (в том числе промежуточного значения), деление на ноль,
+    // отрицательная степень и сдвиг
+    for _, expression := range []string{"2^63", "1 << 63", "0x7FFFFFFFFFFFFFFF + 1", "5 // (2 - 2)", "2^-1", "1 << -1", "2^1000000",
+        "(1 << 70) >> 10", "2^64 - 2^64 + 1", "0 * 18446744073709551616", "abs(-9223372036854775808)"} {
+        ast, _ := calculation.Parse(expression)
+        if _, err := calculation.EvalInteger(ast, nil, calculation.WidthInt64); err == nil {
+            t.Errorf("%s: ожидалась ошибка", expression)
+        }
+    }
+    // Недоступно в целочисленном режиме
+    for _, expression := range []string{"2.5 + 1", "sqrt(4)", "pi", "y + 1"} {
+        ast, _ := calculation.Parse(expression)
+        if err := calculation.ValidateInteger(ast, map[string]float64{"y": 0.5}); err == nil {
+            t.Errorf("%s: ожидалась ошибка проверки", expression)
+        }
+    }
+
+    // В действительном режиме: % и // работают, побитовые операторы — нет
+    ast, _ := calculation.Parse("7.5 % 2 + 7 // 2")
+    if result, err := calculation.Eval(ast, nil); err != nil || result != 4.5 {
+        t.Errorf("Ожидалось 4.5, но получили %v (%v)", result, err)
+    }
+    ast, _ = calculation.Parse("5 & 3")
+    if err := calculation.Validate(ast, nil); err == nil {
+        t.Error("Ожидалась ошибка: & только в целочисленном режиме")
+    }
+    ast, _ = calculation.Parse("(0xFF & x) << 2 | 1")
+    if text := calculation.Format(ast); text != "(0xFF & x) << 2 | 1" {
+        t.Errorf("Неверная запись выражения: %s", text)
+    }
+
+    // Большие целые, которые float64 округляет до одного числа, различаются в записи (ключе кеша)
+    first, _ := calculation.Parse("9007199254740993")
+    second, _ := calculation.Parse("9007199254740992")
+    if calculation.PrintAST(first) == calculation.PrintAST(second) {
+        t.Errorf("Записи %s и %s совпадают", calculation.PrintAST(first), calculation.PrintAST(second))
+    }
+}
+
+func TestEvalValue(t *testing.T) {
+    env := map[string]float64{"x": 150, "zero": 0}
+    tests := []struct {
+        expression string
+        kind       calculation.Kind
+        number     float64
+        boolean    bool
+    }{
+        {"if(x > 100, x*0.9, x)", calculation.KindNumber, 135, false},
+        {"if(x <= 100, x*0.9, x)", calculation.KindNumber, 150, false},
+        {"x >= 150 and x != 151", calculation.KindBoolean, 0, true},
+        {"x < 100 or x == 150", calculation.KindBoolean, 0, true},
+        {"not x > 100", calculation.KindBoolean, 0, false},
+        {"not 1 > 2 and 3 > 4 or 1 == 1", calculation.KindBoolean, 0, true}, // ((not 1 > 2) and 3 > 4) or 1 == 1
+        {"(x > 1) == (x > 2)", calculation.KindBoolean, 0, true},
+        {"1 + 2 < 2 * 2", calculation.KindBoolean, 0, true},
+        {"2 * if(x > 0, 1, -1) + 1", calculation.KindNumber, 3, false},
+        // Короткое замыкание: деление на ноль не вычисляется
+        {"if(zero == 0, 0, 1 / zero)", calculation.KindNumber, 0, false},
+        {"zero == 0 or 1 / zero > 1", calculation.KindBoolean, 0, true},
+        {"zero != 0 and 1 / zero > 1", calculation.KindBoolean, 0, false},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        if kind, err := calculation.TypeOf(ast); err != nil || kind != test.kind {
+            t.Errorf("%s: ожидался тип %s, но получили %s (%v)", test.expression, test.kind, kind, err)
+        }
+        value, err := calculation.EvalValue(ast, env)
+        if err != nil || value.Kind != test.kind || value.Number != test.number || value.Boolean != test.boolean {
+            t.Errorf("%s: ожидалось %v/%v, но получили %+v (%v)", test.expression, test.number, test.boolean, value, err)
+        }
+        // Запись выражения разбирается в то же дерево
+        again, err := calculation.Parse(calculation.Format(ast))
+        if err != nil || calculation.PrintAST(again) != calculation.PrintAST(ast) {
+            t.Errorf("%s: запись %s разбирается иначе", test.expression, calculation.Format(ast))
+        }
+    }
+
+    // Ошибки типов обнаруживаются без вычисления
+    for _, expression := range []string{"1 + (x > 2)", "if(x, 1, 2)", "if(x > 1, 1, x > 2)", "not x", "x > 1 and 2", "(x > 1) < (x > 2)", "1 < x < 3", "sqrt(x > 1)"} {
+        ast, err := calculation.Parse(expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", expression, err)
+            continue
+        }
+        if _, err := calculation.TypeOf(ast); err == nil {
+            t.Errorf("%s: ожидалась ошибка типа", expression)
+        } else if _, ok := err.(*calculation.TypeError); !ok {
+            t.Errorf("%s: ожидалась *TypeError, но получили %T", expression, err)
+        }
+    }
+    // Логическое значение там, где нужно число
+    ast, _ := calculation.Parse("x > 1")
+    if _, err := calculation.Eval(ast, env); err == nil {
+        t.Error("Ожидалась ошибка: Eval вернул число для сравнения")
+    }
+    // Производная кусочной функции
+    ast, _ = calculation.Parse("if(x > 0, x^2, -x)")
+    derivative, err := calculation.Derive(ast, "x")
+    if err != nil || calculation.Format(derivative) != "if(x > 0, 2 * x, -1)" {
+        t.Errorf("Неверная производная: %v (%v)", derivative, err)
+    }
+}
+
+func TestMatrix(t *testing.T) {
+    env := map[string]float64{"x": 2}
+    tests := []struct {
+        expression string
+        expected   string // значение в JSON
+    }{
+        {"[1, 2, 3]", "[1,2,3]"},
+        {"[[1,2],[3,4]]", "[[1,2],[3,4]]"},
+        {"[1, 2] + [3, 4]", "[4,6]"},
+        {"x * [1, 2]", "[2,4]"},
+        {"[[1,2],[3,4]] ^ 2", "[[1,4],[9,16]]"}, // поэлементно, матричное произведение — matmul
+        {"-[1, x]", "[-1,-2]"},
+        {"sqrt([4, 9])", "[2,3]"},
+        {"dot([1, 2, 3], [4, 5, 6])", "32"},
+        {"matmul([[1,2],[3,4]], [[5,6],[7,8]])", "[[19,22],[43,50]]"},
+        {"matmul([[1,2],[3,4]], [1, 1])", "[3,7]"},
+        {"matmul([1, 1], [[1,2],[3,4]])", "[4,6]"},
+        {"transpose([[1,2,3],[4,5,6]])", "[[1,4],[2,5],[3,6]]"},
+        {"det([[1,2],[3,4]])", "-2"},
+        {"det([[0,1],[1,0]])", "-1"}, // перестановка строк меняет знак
+        {"inv([[4,7],[2,6]])", "[[0.6,-0.7],[-0.2,0.4]]"},
+        {"if(x > 1, [1], [2])", "[1]"},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        kind, err := calculation.TypeOf(ast)
+        value, evalErr := calculation.EvalValue(ast, env)
+        if err != nil || evalErr != nil || kind != value.Kind {
+            t.Errorf("%s: тип %s, значение %+v (%v, %v)", test.expression, kind, value, err, evalErr)
+            continue
+        }
+        // Значения inv округляются, чтобы не зависеть от погрешности
+        rounded, _ := json.Marshal(value.Interface())
+        var decoded interface{}
+        json.Unmarshal(rounded, &decoded)
+        if result := roundJSON(decoded); result != test.expected {
+            t.Errorf("%s: ожидалось %s, но получили %s", test.expression, test.expected, result)
+        }
+        // Запись выражения разбирается в то же дерево
+        again, err := calculation.Parse(calculation.Format(ast))
+        if err != nil || calculation.PrintAST(again) != calculation.PrintAST(ast) {
+            t.Errorf("%s: запись %s разбирается иначе", test.expression, calculation.Format(ast))
+        }
+    }
+
+    // Ошибки типов обнаруживаются без вычисления
+    for _, expression := range []string{"[1, [2]]", "[[1], 2]", "[1, 2] + [[1, 2]]", "[1, 2] > 1", "dot(1, 2)", "det([1, 2])", "[x > 1]", "sin(dot([1], [1]) > 0) + [1]"} {
+        ast, err := calculation.Parse(expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", expression, err)
+            continue
+        }
+        if _, err := calculation.TypeOf(ast); err == nil {
+            t.Errorf("%s: ожидалась ошибка типа", expression)
+        }
+    }
+    // Ошибки размеров — при вычислении
+    for _, expression := range []string{"[1, 2] + [1, 2, 3]", "[[1, 2], [3]]", "dot([1], [1, 2])", "matmul([[1, 2]], [[1, 2]])", "det([[1, 2, 3], [4, 5, 6]])", "inv([[1, 2], [2, 4]])", "[]"} {
+        ast, err := calculation.Parse(expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", expression, err)
+            continue
+        }
+        if _, err := calculation.EvalValue(ast, env); err == nil {
+            t.Errorf("%s: ожидалась ошибка вычисления", expression)
+        }
+    }
+    // Векторы недоступны в других режимах
+    ast, _ := calculation.Parse("[1, 2] * 2")
+    if err := calculation.ValidateInteger(ast, env); err == nil {
+        t.Error("Ожидалась ошибка: векторы в целочисленном режиме")
+    }
+    if _, err := calculation.Eval(ast, env); err == nil {
+        t.Error("Ожидалась ошибка: Eval вернул число для вектора")
+    }
+}
+
+// roundJSON записывает декодированный JSON с числами, округлёнными до 9 знаков.
+func roundJSON(value interface{}) string {
+    switch v := value.(type) {
+    case float64:
+        return strconv.FormatFloat(math.Round(v*1e9)/1e9, 'f', -1, 64)
+    case []interface{}:
+        elements := make([]string, len(v))
+        for i, element := range v {
+            elements[i] = roundJSON(element)
+        }
+        return "[" + strings.Join(elements, ",") + "]"
+    }
+    return fmt.Sprint(value)
+}
+
+func TestStatistics(t *testing.T) {
+    lists := map[string][]float64{"data": {2, 4, 4, 4, 5, 5, 7, 9}, "i": {100}}
+    tests := []struct {
+        expression string
+        expected   float64
+    }{
+        {"mean(data)", 5},
+        {"median(data)", 4.5},
+        {"median([3, 1, 2])", 2},
+        {"variance(data)", 32.0 / 7}, // выборочная, делитель n - 1
+        {"stddev([1, 2, 3])", 1},
+        {"percentile(data, 25)", 4},
+        {"percentile([1, 2, 3, 4], 50)", 2.5},
+        {"percentile(data, 100)", 9},
+        {"sum(data)", 40},
+        {"count(data)", 8},
+        {"count(data, 1, [2, 3])", 11}, // списки разворачиваются
+        {"max(data, 10)", 10},
+        {"min([3, 1, 2])", 1},
+        {"mean(data * 2)", 10},
+        {"sum(k, k, 1, 4)", 10},        // четыре аргумента — специальная форма
+        {"integrate(i, i, 0, 1)", 0.5}, // связанная переменная integrate перекрывает список
+        {"sum(1, 2, 3)", 6},
+        {"sum([1, 2, 3, 4])", 10},
+    }
+    for _, test := range tests {
+        ast, err := calculation.Parse(test.expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.expression, err)
+            continue
+        }
+        ast = calculation.BindLists(ast, lists)
+        if err := calculation.Validate(ast, nil); err != nil {
+            t.Errorf("%s: ошибка проверки: %v", test.expression, err)
+            continue
+        }
+        if kind, err := calculation.TypeOf(ast); err != nil || kind != calculation.KindNumber {
+            t.Errorf("%s: ожидалось число, но получили %s (%v)", test.expression, kind, err)
+        }
+        result, err := calculation.Eval(ast, nil)
+        if err != nil || math.Abs(result-test.expected) > 1e-9 {
+            t.Errorf("%s: ожидалось %v, но получили %v (%v)", test.expression, test.expected, result, err)
+        }
+    }
+
+    // Ошибки: матрица вместо списка, нет аргументов, дисперсия одного значения, процентиль вне [0, 100],
+    // список с именем счётчика sum
+    for _, expression := range []string{"mean([[1, 2]])", "mean()", "variance([1])", "percentile([1, 2], 101)", "percentile([1, 2])", "sum(i, i, 1, 4)"} {
+        ast, err := calculation.Parse(expression)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", expression, err)
+            continue
+        }
+        ast = calculation.BindLists(ast, lists)
+        if _, err := calculation.Eval(ast, nil); err == nil {
+            t.Errorf("%s: ожидалась ошибка", expression)
+        }
+    }
+
+    // Значения переменных из JSON: числа и списки
+    var raw map[string]json.RawMessage
+    json.Unmarshal([]byte(`{"x": 2, "data": [1, 2.5]}`), &raw)
+    numbers, split, err := calculation.SplitVariables(raw)
+    if err != nil || numbers["x"] != 2 || len(split["data"]) != 2 || split["data"][1] != 2.5 {
+        t.Errorf("Неверное разделение переменных: %v %v (%v)", numbers, split, err)
+    }
+    for _, body := range []string{`{"data": []}`, `{"data": ["a"]}`, `{"data": [[1]]}`, `{"x": "1"}`} {
+        json.Unmarshal([]byte(body), &raw)
+        if _, _, err := calculation.SplitVariables(raw); err == nil {
+            t.Errorf("%s: ожидалась ошибка", body)
+        }
+    }
+}
+
+func TestProgram(t *testing.T) {
+    env := map[string]float64{"k": 10}
+    lists := map[string][]float64{"data": {1, 2, 3}}
+    tests := []struct {
+        program  string
+        expected float64
+    }{
+        {"f(x) = x^2 + 1; a = f(3); a * 2", 20},
+        {"a = 2; f(x) = a * x; a = 3; f(1)", 2}, // функция видит значение a на момент определения
+        {"f(x) = x + k; f(1)", 11},
+        {"x = 5; f(x) = x * 2; f(1) + x", 7}, // параметр перекрывает переменную
+        {"fact(n) = if(n <= 1, 1, n * fact(n - 1)); fact(5)", 120},
+        {"fib(n) = if(n < 2, n, fib(n - 1) + fib(n - 2)); fib(15)", 610},
+        {"g(x, y) = x - y; h(x) = g(x, 1) * 2; h(4)", 6},
+        {"f(x) = x^2; integrate(f(x), x, 0, 3)", 9},
+        {"v = data * 2; mean(v)", 4},
+        {"m = [[1, 2], [3, 4]]; det(m)", -2},
+        {"pi2() = pi * 2; pi2() / pi", 2},
+        {"a = 1; a = a + 1; a = a * 3;", 6},
+    }
+    for _, test := range tests {
+        program, err := calculation.ParseProgram(test.program)
+        if err != nil {
+            t.Errorf("%s: ошибка разбора: %v", test.program, err)
+            continue
+        }
+        if kind, err := calculation.CheckProgram(program, env, lists); err != nil || kind != calculation.KindNumber {
+            t.Errorf("%s: ожидалось число, но получили %s (%v)", test.program, kind, err)
+        }
+        value, _, err := calculation.EvalProgram(program, env, lists)
+        if err != nil || value.Kind != calculation.KindNumber || math.Abs(value.Number-test.expected) > 1e-9 {
+            t.Errorf("%s: ожидалось %v, но получили %v (%v)", test.program, test.expected, value.Interface(), err)
+        }
+    }
+
+    // Итоговые значения — только имена, определённые программой
+    program, _ := calculation.ParseProgram("f(x) = x^2 + 1; a = f(3); v = [a, 1]; a * 2")
+    _, bindings, err := calculation.EvalProgram(program, env, nil)
+    data, _ := json.Marshal(bindings)
+    if expected := `{"a":10,"f":"f(x) = x ^ 2 + 1","v":[10,1]}`; err != nil || string(data) != expected {
+        t.Errorf("Ожидались значения %s, но получили %s (%v)", expected, data, err)
+    }
+    if expected := "f(x) = ((x ^ 2) + 1); a = f(3); v = [a, 1]; (a * 2)"; program.String() != expected {
+        t.Errorf("Ожидалась запись %q, но получили %q", expected, program.String())
+    }
+
+    // Программа — инструкции через ";", одно выражение или уравнение — нет
+    for input, expected := range map[string]bool{"a = 1;": true, "1; 2": true, "f(x) = x; f(2)": true, "2 + 3": false, "x = 2": false, "1 +; 2": false} {
+        if calculation.IsProgram(input) != expected {
+            t.Errorf("IsProgram(%q): ожидалось %v", input, expected)
+        }
+    }
+
+    // Бесконечная рекурсия обрывается по глубине вызовов
+    program, _ = calculation.ParseProgram("g(n) = g(n + 1); g(0)")
+    if _, err := calculation.CheckProgram(program, nil, nil); err != nil {
+        t.Errorf("Проверка не вычисляет программу, но получили ошибку: %v", err)
+    }
+    if _, _, err := calculation.EvalProgram(program, nil, nil); err == nil || !strings.Contains(err.Error(), "глубина рекурсии") {
+        t.Errorf("Ожидалась ошибка глубины рекурсии, но получили %v", err)
+    }
+
+    for _, input := range []string{
+        "sin(x) = x; sin(1)",       // встроенную функцию переопределить нельзя
+        "a = 1 < 2; a",             // логическое значение не присваивается
+        "f(x) = x",                 // последняя инструкция — определение
+        "f(x) = x; f(1, 2)",        // неверное число аргументов
+        "a = b + 1; b = 2; a",      // b ещё не определена
+        "f(x) = x; f([1, 2])",      // аргумент функции — число
+        "f(x) = y; y = 1; f(1)",    // замыкание видит только уже определённые переменные
+    } {
+        program, err := calculation.ParseProgram(input)
+        if err == nil {
+            _, err = calculation.CheckProgram(program, nil, nil)
+        }
+        if err == nil {
+            t.Errorf("%s: ожидалась ошибка", input)
+        }
+    }
+    for _, input := range []string{"1 + 2 = 3; 1", "f(x + 1) = x; 1", "f(x, x) = x; 1", "a = 1 2", ";"} {
+        if _, err := calculation.ParseProgram(input); err == nil {
+            t.Errorf("%s: ожидалась ошибка разбора", input)
+        }
+    }
+}
+
+func TestToJSONValues(t *testing.T) {
+    ast, err := calculation.Parse("x + 2 * 3 * integrate(u, u, 0, 2) + if(1 < 2, 5, 6)")
+    if err != nil {
+        t.Fatalf("Ошибка разбора: %v", err)
+    }
+    tree := calculation.ToJSON(ast)
+    value := func(node *calculation.JSONNode) string {
+        if node.Value == nil {
+            return "-"
+        }
+        return strconv.FormatFloat(*node.Value, 'g', -1, 64)
+    }
+
+    // ((x + ((2 * 3) * integrate(u, u, 0, 2))) + if(1 < 2, 5, 6))
+    inner, condition := tree.Children[0], tree.Children[1]
+    product := inner.Children[1]
+    integral := product.Children[1]
+    got := []string{value(tree), value(inner), value(inner.Children[0]), value(product), value(product.Children[0]),
+        value(integral), value(integral.Children[0]), value(condition), value(condition.Children[0])}
+    // Переменная, интеграл (его вычисляют только агенты) и сравнение значения не имеют
+    expected := []string{"-", "-", "-", "-", "6", "-", "-", "5", "-"}
+    if strings.Join(got, " ") != strings.Join(expected, " ") {
+        t.Errorf("Ожидались значения %v, но получили %v", expected, got)
+    }
+}
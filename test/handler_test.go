@@ -1,11 +1,23 @@
 package test
 
 import (
+    "bufio"
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
+    "fmt"
+    "io"
+    "math"
     "net/http"
     "net/http/httptest"
     "testing"
     "strings"
+    "strconv"
+    "time"
+    "github.com/gulovv/web_calculator/calculation"
     "github.com/gulovv/web_calculator/handler"
 )
 
@@ -52,7 +64,7 @@ func TestAddTask(t *testing.T) {
 func TestGetExpressionByID(t *testing.T) {
     // Prepare the handler with a task in the CompletedTasks map
     handler.CompletedTasks = map[int]handler.Task{
-       1: {ID: 1, Expression: "3 + 2", Status: "completed", Result: 5},
+       1: {ID: 1, Expression: "3 + 2", Status: "completed", TaskResult: handler.TaskResult{Result: 5}},
     }
 
     tests := []struct {
@@ -96,7 +108,7 @@ func TestGetExpressionByID(t *testing.T) {
 
 func TestGetAllExpressions(t *testing.T) {
     handler.TaskQueue = []handler.Task{
-        {ID: 1, Expression: "3 + 2", Status: "completed", Result: 5},
+        {ID: 1, Expression: "3 + 2", Status: "completed", TaskResult: handler.TaskResult{Result: 5}},
     }
 
     req := httptest.NewRequest("GET", "/api/v1/expressions", nil)
@@ -198,4 +210,1585 @@ func TestDeleteAllTasks(t *testing.T) {
     if len(handler.TaskQueue) != 0 {
         t.Errorf("Ожидалась пустая очередь задач, но осталось %d задач", len(handler.TaskQueue))
     }
-}
\ No newline at end of file
+}
+
+func TestAddTaskAdmissionControl(t *testing.T) {
+    oldRate, oldBurst, oldDepth := handler.RateLimitPerSecond, handler.RateLimitBurst, handler.MaxQueueDepth
+    oldLength, oldAST, oldOverhead := handler.MaxExpressionLength, handler.MaxASTDepth, handler.MaxBodyOverhead
+    oldKeys := handler.APIKeys
+    defer func() {
+        handler.RateLimitPerSecond, handler.RateLimitBurst, handler.MaxQueueDepth = oldRate, oldBurst, oldDepth
+        handler.MaxExpressionLength, handler.MaxASTDepth, handler.MaxBodyOverhead = oldLength, oldAST, oldOverhead
+        handler.APIKeys = oldKeys
+        handler.ResetRateLimits()
+    }()
+
+    handler.TaskQueue = []handler.Task{}
+    handler.RateLimitPerSecond, handler.RateLimitBurst = 0.001, 2
+    handler.MaxQueueDepth, handler.MaxExpressionLength, handler.MaxASTDepth = 5, 20, 4
+    handler.APIKeys = map[string]bool{"client-a": true, "client-b": true, "client-c": true, "client-d": true}
+    handler.MaxBodyOverhead = 100 // тело не больше 20*6 + 100 байт
+    handler.ResetRateLimits()
+
+    tests := []struct {
+        name           string
+        apiKey         string
+        body           string
+        expectedStatus int
+    }{
+        {"First request within burst", "client-a", `{"expression": "1 + 1"}`, http.StatusCreated},
+        {"Second request within burst", "client-a", `{"expression": "1 + 2"}`, http.StatusCreated},
+        {"Burst exhausted", "client-a", `{"expression": "1 + 3"}`, http.StatusTooManyRequests},
+        {"Unknown key limited by IP", "unknown-1", `{"expression": "1 + 4"}`, http.StatusCreated},
+        {"Another unknown key shares IP bucket", "unknown-2", `{"expression": "1 + 5"}`, http.StatusCreated},
+        {"IP burst exhausted", "unknown-3", `{"expression": "1 + 6"}`, http.StatusTooManyRequests},
+        {"Expression too long", "client-b", `{"expression": "1 + 1 + 1 + 1 + 1 + 1 + 1"}`, http.StatusRequestEntityTooLarge},
+        {"AST too deep", "client-b", `{"expression": "((((1 + 1))))*2*2*2"}`, http.StatusUnprocessableEntity},
+        {"Body too large", "client-d", `{"expression": "1 + 1", "callback_url": "http://example.com/` + strings.Repeat("x", 300) + `"}`, http.StatusRequestEntityTooLarge},
+        {"Last free slot in queue", "client-c", `{"expression": "2 * 2"}`, http.StatusCreated},
+        {"Queue is full", "client-c", `{"expression": "2 * 3"}`, http.StatusServiceUnavailable},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(tt.body))
+            req.Header.Set("X-API-Key", tt.apiKey)
+            w := httptest.NewRecorder()
+
+            handler.AddTask(w, req)
+
+            t.Logf("Ответ получен с кодом: %d", w.Code)
+            if w.Code != tt.expectedStatus {
+                t.Errorf("Ожидался статус %d, но получили %d", tt.expectedStatus, w.Code)
+            }
+            if (w.Code == http.StatusTooManyRequests || w.Code == http.StatusServiceUnavailable) && w.Header().Get("Retry-After") == "" {
+                t.Errorf("Ожидался заголовок Retry-After")
+            }
+        })
+    }
+
+    w := httptest.NewRecorder()
+    handler.GetMetrics(w, httptest.NewRequest("GET", "/api/v1/metrics", nil))
+
+    var metrics struct {
+        QueueDepth int                    `json:"queue_depth"`
+        Rejections handler.RejectionStats `json:"rejections"`
+    }
+    if err := json.NewDecoder(w.Body).Decode(&metrics); err != nil {
+        t.Fatalf("Ожидался корректный JSON, но возникла ошибка: %v", err)
+    }
+    t.Logf("Метрики: %+v", metrics)
+    if metrics.QueueDepth != 5 {
+        t.Errorf("Ожидалась глубина очереди 5, но получили %d", metrics.QueueDepth)
+    }
+    if metrics.Rejections.RateLimited < 1 || metrics.Rejections.QueueFull < 1 || metrics.Rejections.TooLong < 1 || metrics.Rejections.TooDeep < 1 {
+        t.Errorf("Ожидались ненулевые счётчики отказов, но получили %+v", metrics.Rejections)
+    }
+}
+
+func TestAddTaskIdempotencyKey(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.TaskQueue = []handler.Task{}
+
+    send := func(key, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+        req.Header.Set("Idempotency-Key", key)
+        w := httptest.NewRecorder()
+        handler.AddTask(w, req)
+        return w
+    }
+    taskID := func(w *httptest.ResponseRecorder) int {
+        var response map[string]int
+        if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+            t.Fatalf("Ожидался корректный JSON, но возникла ошибка: %v", err)
+        }
+        return response["id"]
+    }
+
+    first := send("retry-1", `{"expression": "7 * 6"}`)
+    if first.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusCreated, first.Code)
+    }
+    firstID := taskID(first)
+
+    retry := send("retry-1", `{"expression": "7 * 6"}`)
+    if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+        t.Fatalf("Ожидался повтор с кодом %d, но получили %d", http.StatusCreated, retry.Code)
+    }
+    if id := taskID(retry); id != firstID {
+        t.Errorf("Ожидался исходный ID %d, но получили %d", firstID, id)
+    }
+    if len(handler.TaskQueue) != 1 {
+        t.Errorf("Ожидалась одна задача в очереди, но получили %d", len(handler.TaskQueue))
+    }
+
+    conflict := send("retry-1", `{"expression": "7 * 7"}`)
+    if conflict.Code != http.StatusConflict {
+        t.Errorf("Ожидался статус %d, но получили %d", http.StatusConflict, conflict.Code)
+    }
+
+    other := send("retry-2", `{"expression": "7 * 6"}`)
+    if id := taskID(other); id == firstID {
+        t.Errorf("Ожидался новый ID для другого ключа, но получили %d", id)
+    }
+}
+
+func TestResultCacheAndCoalescing(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    add := func(expression string) int {
+        req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "`+expression+`"}`))
+        w := httptest.NewRecorder()
+        handler.AddTask(w, req)
+        if w.Code != http.StatusCreated {
+            t.Fatalf("Ожидался статус %d, но получили %d", http.StatusCreated, w.Code)
+        }
+        var response map[string]int
+        json.NewDecoder(w.Body).Decode(&response)
+        return response["id"]
+    }
+
+    leaderID := add("2+3")
+    followerID := add("(2 + 3)")
+    if len(handler.TaskQueue) != 1 {
+        t.Fatalf("Ожидалась одна задача в очереди, но получили %d", len(handler.TaskQueue))
+    }
+
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+
+    w := httptest.NewRecorder()
+    body := strings.NewReader(`{"id": ` + strconv.Itoa(leaderID) + `, "result": 5}`)
+    handler.UpdateTaskResult(w, httptest.NewRequest("POST", "/api/v1/task/result", body))
+    if w.Code != http.StatusOK {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusOK, w.Code)
+    }
+    if task := handler.CompletedTasks[followerID]; task.Status != "completed" || task.Result != 5 {
+        t.Errorf("Ожидалось, что присоединённая задача завершится с результатом 5, но получили %+v", task)
+    }
+
+    cachedID := add("2 + 3")
+    if task := handler.CompletedTasks[cachedID]; task.Status != "completed" || task.Result != 5 {
+        t.Errorf("Ожидался результат из кеша, но получили %+v", task)
+    }
+    if len(handler.TaskQueue) != 0 {
+        t.Errorf("Ожидалась пустая очередь, но получили %d задач", len(handler.TaskQueue))
+    }
+
+    w = httptest.NewRecorder()
+    handler.GetMetrics(w, httptest.NewRequest("GET", "/api/v1/metrics", nil))
+    var metrics struct {
+        Cache handler.CacheStats `json:"cache"`
+    }
+    json.NewDecoder(w.Body).Decode(&metrics)
+    t.Logf("Статистика кеша: %+v", metrics.Cache)
+    if metrics.Cache.Hits != 1 || metrics.Cache.Misses != 1 || metrics.Cache.Coalesced != 1 {
+        t.Errorf("Ожидалось hits=1, misses=1, coalesced=1, но получили %+v", metrics.Cache)
+    }
+
+    // Сверх MaxWaitingTasks одинаковая задача не ждёт лидера, а встаёт в очередь
+    oldWaiting := handler.MaxWaitingTasks
+    defer func() { handler.MaxWaitingTasks = oldWaiting }()
+    handler.MaxWaitingTasks = 1
+    add("7 * 6")
+    add("7*6")
+    add("(7 * 6)")
+    if len(handler.TaskQueue) != 2 {
+        t.Errorf("Ожидались две задачи в очереди, но получили %d", len(handler.TaskQueue))
+    }
+}
+
+func TestCancelTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    for _, expression := range []string{"9 - 4", "(9-4)"} {
+        req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "`+expression+`"}`))
+        handler.AddTask(httptest.NewRecorder(), req)
+    }
+
+    cancel := func(id string) int {
+        w := httptest.NewRecorder()
+        handler.ExpressionByID(w, httptest.NewRequest("DELETE", "/api/v1/expressions/"+id, nil))
+        return w.Code
+    }
+
+    // Отмена задачи-лидера: присоединённая задача занимает её место в очереди
+    if code := cancel("1"); code != http.StatusOK {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusOK, code)
+    }
+    if len(handler.TaskQueue) != 1 || handler.TaskQueue[0].ID != 2 {
+        t.Fatalf("Ожидалась задача ID=2 в очереди, но получили %+v", handler.TaskQueue)
+    }
+
+    w := httptest.NewRecorder()
+    handler.TaskHeartbeat(w, httptest.NewRequest("POST", "/api/v1/task/heartbeat", strings.NewReader(`{"id": 1}`)))
+    var heartbeat map[string]interface{}
+    json.NewDecoder(w.Body).Decode(&heartbeat)
+    if heartbeat["cancelled"] != true {
+        t.Errorf("Ожидалось, что heartbeat сообщит об отмене, но получили %v", heartbeat)
+    }
+
+    w = httptest.NewRecorder()
+    handler.UpdateTaskResult(w, httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 5}`)))
+    if w.Code != http.StatusConflict {
+        t.Errorf("Ожидался статус %d для позднего результата, но получили %d", http.StatusConflict, w.Code)
+    }
+
+    tests := []struct {
+        name           string
+        id             string
+        expectedStatus int
+    }{
+        {"Cancel pending task", "2", http.StatusOK},
+        {"Cancel already cancelled task", "2", http.StatusConflict},
+        {"Cancel unknown task", "999", http.StatusNotFound},
+        {"Invalid ID format", "abc", http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if code := cancel(tt.id); code != tt.expectedStatus {
+                t.Errorf("Ожидался статус %d, но получили %d", tt.expectedStatus, code)
+            }
+        })
+    }
+
+    if len(handler.TaskQueue) != 0 {
+        t.Errorf("Ожидалась пустая очередь, но получили %d задач", len(handler.TaskQueue))
+    }
+}
+
+func TestPriorityAndFairScheduling(t *testing.T) {
+    oldKeys := handler.APIKeys
+    defer func() { handler.APIKeys = oldKeys }()
+    handler.APIKeys = map[string]bool{"bulk": true, "interactive": true}
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    add := func(apiKey, body string) int {
+        req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+        req.Header.Set("X-API-Key", apiKey)
+        w := httptest.NewRecorder()
+        handler.AddTask(w, req)
+        var response map[string]int
+        json.NewDecoder(w.Body).Decode(&response)
+        return response["id"]
+    }
+
+    // Массовый клиент ставит в очередь пачку задач, затем интерактивный — одну
+    for i := 1; i <= 5; i++ {
+        add("bulk", `{"expression": "100 + `+strconv.Itoa(i)+`"}`)
+    }
+    interactiveID := add("interactive", `{"expression": "200 + 1"}`)
+    urgentID := add("bulk", `{"expression": "300 + 1", "priority": 5}`)
+
+    var order []int
+    for len(handler.TaskQueue) > 0 {
+        w := httptest.NewRecorder()
+        handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        order = append(order, response["task"].ID)
+    }
+    t.Logf("Порядок выдачи задач: %v", order)
+
+    if order[0] != urgentID {
+        t.Errorf("Ожидалось, что первой будет срочная задача ID=%d, но получили %v", urgentID, order)
+    }
+    position := -1
+    for i, id := range order {
+        if id == interactiveID {
+            position = i
+        }
+    }
+    if position < 0 || position > 2 {
+        t.Errorf("Ожидалось, что задача интерактивного клиента не будет ждать всю пачку, но получили порядок %v", order)
+    }
+
+    // Приоритет действует только внутри владельца: пачка срочных задач не задерживает обычную задачу другого клиента
+    for i := 1; i <= 5; i++ {
+        add("bulk", `{"expression": "400 + `+strconv.Itoa(i)+`", "priority": 9}`)
+    }
+    interactiveID = add("interactive", `{"expression": "500 + 1"}`)
+    order = nil
+    for len(handler.TaskQueue) > 0 {
+        w := httptest.NewRecorder()
+        handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        order = append(order, response["task"].ID)
+    }
+    t.Logf("Порядок выдачи задач разного приоритета: %v", order)
+    if len(order) != 6 || (order[0] != interactiveID && order[1] != interactiveID) {
+        t.Errorf("Ожидалось, что задача интерактивного клиента будет выдана первой или второй, но получили порядок %v", order)
+    }
+
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 1", "priority": 10}`)))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d для недопустимого приоритета, но получили %d", http.StatusUnprocessableEntity, w.Code)
+    }
+}
+
+func TestScheduledTasks(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    add := func(body string) (int, map[string]interface{}) {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        var response map[string]interface{}
+        json.NewDecoder(w.Body).Decode(&response)
+        return w.Code, response
+    }
+
+    // Отложенная задача не попадает в очередь до наступления времени
+    if code, _ := add(`{"expression": "40 + 2", "delay": "1h"}`); code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusCreated, code)
+    }
+    if len(handler.TaskQueue) != 0 || len(handler.ScheduledTasks) != 1 {
+        t.Fatalf("Ожидалась одна отложенная задача вне очереди, но очередь: %d, отложенных: %d", len(handler.TaskQueue), len(handler.ScheduledTasks))
+    }
+    handler.ReleaseDueTasks(time.Now().Add(30 * time.Minute))
+    if len(handler.TaskQueue) != 0 {
+        t.Errorf("Задача не должна попасть в очередь раньше времени")
+    }
+    handler.ReleaseDueTasks(time.Now().Add(2 * time.Hour))
+    if len(handler.TaskQueue) != 1 || len(handler.ScheduledTasks) != 0 {
+        t.Errorf("Ожидалось, что задача попадёт в очередь, но очередь: %d, отложенных: %d", len(handler.TaskQueue), len(handler.ScheduledTasks))
+    }
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+
+    // Повторяющееся расписание
+    code, response := add(`{"expression": "6 * 7", "schedule": "30 * * * *"}`)
+    if code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusCreated, code)
+    }
+    scheduleID := int(response["schedule_id"].(float64))
+    nextRun, err := time.Parse(time.RFC3339, response["next_run"].(string))
+    if err != nil || nextRun.Minute() != 30 {
+        t.Errorf("Ожидался запуск в 30 минут, но получили %v", response["next_run"])
+    }
+
+    handler.ReleaseDueTasks(nextRun)
+    if len(handler.TaskQueue) != 1 || handler.TaskQueue[0].ScheduleID != scheduleID {
+        t.Fatalf("Ожидалась задача расписания в очереди, но получили %+v", handler.TaskQueue)
+    }
+    runID := handler.TaskQueue[0].ID
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    body := strings.NewReader(`{"id": ` + strconv.Itoa(runID) + `, "result": 42}`)
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", body))
+
+    w := httptest.NewRecorder()
+    handler.ScheduleByID(w, httptest.NewRequest("GET", "/api/v1/schedules/"+strconv.Itoa(scheduleID), nil))
+    var scheduleResponse map[string]handler.Schedule
+    json.NewDecoder(w.Body).Decode(&scheduleResponse)
+    schedule := scheduleResponse["schedule"]
+    t.Logf("Расписание: %+v", schedule)
+    if len(schedule.History) != 1 || schedule.History[0].Result != 42 || schedule.History[0].Status != "completed" {
+        t.Errorf("Ожидался один завершённый запуск с результатом 42, но получили %+v", schedule.History)
+    }
+    if schedule.NextRun == nil || !schedule.NextRun.After(nextRun) {
+        t.Errorf("Ожидался следующий запуск после %v, но получили %v", nextRun, schedule.NextRun)
+    }
+
+    if code, _ := add(`{"expression": "1 + 1", "schedule": "61 * * * *"}`); code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d для некорректного расписания, но получили %d", http.StatusUnprocessableEntity, code)
+    }
+
+    // Повтор запроса с тем же Idempotency-Key не создаёт второе расписание
+    scheduled := len(handler.Schedules)
+    var ids []float64
+    for i := 0; i < 2; i++ {
+        req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2 + 2", "schedule": "@every 1h"}`))
+        req.Header.Set("Idempotency-Key", "schedule-once")
+        w := httptest.NewRecorder()
+        handler.AddTask(w, req)
+        var response map[string]interface{}
+        json.NewDecoder(w.Body).Decode(&response)
+        if w.Code != http.StatusCreated || response["schedule_id"] == nil {
+            t.Fatalf("Ожидался статус %d и schedule_id, но получили %d: %v", http.StatusCreated, w.Code, response)
+        }
+        ids = append(ids, response["schedule_id"].(float64))
+        if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != (i == 1) {
+            t.Errorf("Запрос %d: заголовок Idempotent-Replayed = %v", i+1, replayed)
+        }
+    }
+    if len(handler.Schedules) != scheduled+1 || ids[0] != ids[1] {
+        t.Errorf("Ожидалось одно новое расписание, но получили %d (ID %v)", len(handler.Schedules)-scheduled, ids)
+    }
+
+    // Отложенные задачи и расписания не проходят через очередь, но их число ограничено
+    oldWaiting := handler.MaxWaitingTasks
+    defer func() { handler.MaxWaitingTasks = oldWaiting }()
+    handler.MaxWaitingTasks = len(handler.ScheduledTasks) + len(handler.Schedules)
+    for _, body := range []string{`{"expression": "3 + 3", "delay": "1h"}`, `{"expression": "3 + 4", "schedule": "@every 1h"}`} {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+            t.Errorf("Ожидался статус %d с Retry-After для %s, но получили %d", http.StatusServiceUnavailable, body, w.Code)
+        }
+    }
+}
+
+func TestAddTaskIgnoresForgedFields(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 1", "schedule": "@every 1h"}`)))
+    if w.Code != http.StatusCreated || len(handler.Schedules) != 1 {
+        t.Fatalf("Ожидалось одно расписание, но получили статус %d и %d расписаний", w.Code, len(handler.Schedules))
+    }
+
+    // ID, статус, результат и расписание из запроса не берутся
+    seen := make(map[int]bool)
+    for i := 0; i < 3; i++ {
+        body := fmt.Sprintf(`{"id": 1, "status": "completed", "result": 99, "array": [1], "integer": "0xFF", "schedule_id": 1, "expression": "2 + %d"}`, i)
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        var response map[string]int
+        json.NewDecoder(w.Body).Decode(&response)
+        if w.Code != http.StatusCreated || seen[response["id"]] {
+            t.Fatalf("Ожидалась новая задача, но получили статус %d и ID %d", w.Code, response["id"])
+        }
+        seen[response["id"]] = true
+    }
+    if len(handler.TaskQueue) != 3 {
+        t.Fatalf("Ожидалось 3 задачи в очереди, но получили %d", len(handler.TaskQueue))
+    }
+    for _, task := range handler.TaskQueue {
+        if task.Status != "pending" || task.Result != 0 || task.Array != nil || task.Integer != "" || task.ScheduleID != 0 {
+            t.Errorf("Поля из запроса не должны попасть в задачу: %+v", task)
+        }
+    }
+
+    // Результат задачи не попадает в историю чужого расписания
+    w = httptest.NewRecorder()
+    handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+    var response map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&response)
+    if response["task"].Result != 0 {
+        t.Errorf("Агент не должен получить результат из запроса, но получил %v", response["task"].Result)
+    }
+    body := strings.NewReader(`{"id": ` + strconv.Itoa(response["task"].ID) + `, "result": 5}`)
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", body))
+    for _, schedule := range handler.Schedules {
+        if len(schedule.History) != 0 {
+            t.Errorf("История расписания должна быть пустой, но получили %+v", schedule.History)
+        }
+    }
+}
+
+func TestCompletionCallbacks(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    oldSecret, oldDelay, oldAttempts := handler.CallbackSecret, handler.CallbackBaseDelay, handler.CallbackMaxAttempts
+    defer func() {
+        handler.CallbackSecret, handler.CallbackBaseDelay, handler.CallbackMaxAttempts = oldSecret, oldDelay, oldAttempts
+        delete(handler.CallbackAllowedHosts, "127.0.0.1")
+    }()
+    handler.CallbackSecret, handler.CallbackBaseDelay, handler.CallbackMaxAttempts = "test-secret", time.Millisecond, 3
+    handler.CallbackAllowedHosts["127.0.0.1"] = true // получатели ниже — на loopback
+
+    // Получатель, который принимает обратный вызов только с третьей попытки
+    var attempts int
+    var received handler.Task
+    receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        attempts++
+        body, _ := io.ReadAll(r.Body)
+        mac := hmac.New(sha256.New, []byte("test-secret"))
+        mac.Write(body)
+        if r.Header.Get("X-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+            t.Errorf("Некорректная подпись обратного вызова: %s", r.Header.Get("X-Signature"))
+        }
+        if attempts < 3 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        json.Unmarshal(body, &received)
+    }))
+    defer receiver.Close()
+
+    // Получатель, который всегда отвечает ошибкой
+    broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusInternalServerError)
+    }))
+    defer broken.Close()
+
+    complete := func(expression, callbackURL, result string) {
+        body := `{"expression": "` + expression + `", "callback_url": "` + callbackURL + `"}`
+        handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        w := httptest.NewRecorder()
+        handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        update := `{"id": ` + strconv.Itoa(response["task"].ID) + `, ` + result + `}`
+        handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(update)))
+    }
+
+    complete("11 * 11", receiver.URL, `"result": 121`)
+    complete("12 * 12", broken.URL, `"status": "failed", "error": "ошибка агента"`)
+    handler.WaitCallbacks()
+
+    if attempts != 3 || received.Status != "completed" || received.Result != 121 {
+        t.Errorf("Ожидалась доставка результата 121 с третьей попытки, но попыток: %d, получено: %+v", attempts, received)
+    }
+
+    w := httptest.NewRecorder()
+    handler.GetDeadLetters(w, httptest.NewRequest("GET", "/api/v1/callbacks/dead-letters", nil))
+    var response map[string][]handler.DeadLetter
+    json.NewDecoder(w.Body).Decode(&response)
+    deadLetters := response["dead_letters"]
+    if len(deadLetters) != 1 || deadLetters[0].URL != broken.URL || deadLetters[0].Attempts != 3 {
+        t.Fatalf("Ожидалась одна запись о недоставленном вызове, но получили %+v", deadLetters)
+    }
+    var failed handler.Task
+    json.Unmarshal(deadLetters[0].Payload, &failed)
+    if failed.Status != "failed" || failed.Error != "ошибка агента" {
+        t.Errorf("Ожидалась проваленная задача в недоставленном вызове, но получили %+v", failed)
+    }
+
+    // Внутренние адреса без разрешения в CallbackAllowedHosts запрещены (SSRF)
+    for _, callbackURL := range []string{
+        "ftp://example.com",
+        "http://169.254.169.254/latest/meta-data",
+        "http://10.0.0.5:8080/hook",
+        "http://localhost/hook",
+        "http://[::1]/hook",
+        "http://0.0.0.0/hook",
+    } {
+        w = httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 1", "callback_url": "`+callbackURL+`"}`)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", callbackURL, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+
+    // Без CALLBACK_SECRET обратные вызовы не принимаются
+    handler.CallbackSecret = ""
+    w = httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 1", "callback_url": "`+receiver.URL+`"}`)))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d без CALLBACK_SECRET, но получили %d", http.StatusUnprocessableEntity, w.Code)
+    }
+}
+
+func TestStreamTaskEvents(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    lastEventID := handler.EventIDCounter
+
+    // Создаём историю: одна задача завершается, другая отменяется
+    for _, expression := range []string{"13 * 13", "14 * 14"} {
+        handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "`+expression+`"}`)))
+    }
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 169}`)))
+    handler.ExpressionByID(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/expressions/2", nil))
+
+    // Возобновление по Last-Event-ID с фильтром по ID
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    req := httptest.NewRequest("GET", "/api/v1/expressions/stream?id=1", nil).WithContext(ctx)
+    req.Header.Set("Last-Event-ID", strconv.Itoa(lastEventID))
+    w := httptest.NewRecorder()
+    handler.StreamTaskEvents(w, req)
+
+    var events []string
+    for _, line := range strings.Split(w.Body.String(), "\n") {
+        if strings.HasPrefix(line, "event: ") {
+            events = append(events, strings.TrimPrefix(line, "event: "))
+        }
+    }
+    t.Logf("События задачи 1: %v", events)
+    if strings.Join(events, ",") != "created,leased,completed" {
+        t.Errorf("Ожидались события created,leased,completed, но получили %v", events)
+    }
+
+    // Живой поток: событие приходит подписчику сразу после изменения состояния
+    server := httptest.NewServer(http.HandlerFunc(handler.StreamTaskEvents))
+    defer server.Close()
+    resp, err := http.Get(server.URL + "?owner=ip:192.0.2.1")
+    if err != nil {
+        t.Fatalf("Ошибка подключения к потоку: %v", err)
+    }
+    defer resp.Body.Close()
+    if resp.Header.Get("Content-Type") != "text/event-stream" {
+        t.Errorf("Ожидался Content-Type text/event-stream, но получили %s", resp.Header.Get("Content-Type"))
+    }
+
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "15 * 15"}`)))
+
+    scanner := bufio.NewScanner(resp.Body)
+    for scanner.Scan() {
+        if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+            var event handler.TaskEvent
+            json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+            if event.Type != "created" || event.Task.Expression != "15 * 15" {
+                t.Errorf("Ожидалось событие created для 15 * 15, но получили %+v", event)
+            }
+            break
+        }
+    }
+}
+
+func TestGetExpressionByIDWait(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "16 * 16"}`)))
+
+    get := func(query string) handler.Task {
+        w := httptest.NewRecorder()
+        handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/1"+query, nil))
+        if w.Code != http.StatusOK {
+            t.Errorf("Ожидался статус %d, но получили %d", http.StatusOK, w.Code)
+        }
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        return response["expression"]
+    }
+
+    // Таймаут: возвращается текущий статус
+    started := time.Now()
+    if task := get("?wait=50ms"); task.Status != "pending" {
+        t.Errorf("Ожидался статус pending после таймаута, но получили %s", task.Status)
+    }
+    if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+        t.Errorf("Ответ пришёл раньше таймаута: %v", elapsed)
+    }
+
+    // Завершение задачи будит ожидающий запрос до истечения таймаута
+    result := make(chan handler.Task)
+    go func() { result <- get("?wait=5s") }()
+
+    time.Sleep(20 * time.Millisecond)
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 256}`)))
+
+    select {
+    case task := <-result:
+        if task.Status != "completed" || task.Result != 256 {
+            t.Errorf("Ожидался завершённый результат 256, но получили %+v", task)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("Ожидающий запрос не получил уведомление о завершении задачи")
+    }
+
+    w := httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/1?wait=soon", nil))
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("Ожидался статус %d для некорректного wait, но получили %d", http.StatusBadRequest, w.Code)
+    }
+}
+
+func TestEvaluate(t *testing.T) {
+    handler.ResetRateLimits()
+
+    w := httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "2 + 2 * 3"}`)))
+    if w.Code != http.StatusOK {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusOK, w.Code)
+    }
+    var response handler.EvaluateResponse
+    json.NewDecoder(w.Body).Decode(&response)
+    if response.Result != 8 || response.AST != "(2 + (2 * 3))" {
+        t.Errorf("Ожидался результат 8 и AST (2 + (2 * 3)), но получили %+v", response)
+    }
+
+    // Некорректное выражение и превышение глубины AST
+    w = httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "2 +"}`)))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d, но получили %d", http.StatusUnprocessableEntity, w.Code)
+    }
+
+    // integrate и sum синхронно не вычисляются
+    for _, expression := range []string{"integrate(x^2, x, 0, 1)", "1 + sum(i, i, 1, 10^7)"} {
+        w = httptest.NewRecorder()
+        handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "`+expression+`"}`)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", expression, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+
+    // sum с четырьмя аргументами — всегда sum(выражение, i, от, до); сумма четырёх чисел пишется списком
+    w = httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "sum([1, 2, 3, 4])"}`)))
+    response = handler.EvaluateResponse{}
+    json.NewDecoder(w.Body).Decode(&response)
+    if w.Code != http.StatusOK || response.Result != 10 {
+        t.Errorf("Ожидался результат 10, но получили статус %d и %+v", w.Code, response)
+    }
+    for _, body := range []string{
+        `{"expression": "sum(1, 2, 3, 4)"}`,
+        `{"expression": "sum(a, b, c, d)", "variables": {"a": 1, "b": 2, "c": 3, "d": 4}}`,
+    } {
+        w = httptest.NewRecorder()
+        handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+
+    maxDepth := handler.MaxASTDepth
+    handler.MaxASTDepth = 2
+    w = httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "1 + 2 + 3 + 4"}`)))
+    handler.MaxASTDepth = maxDepth
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d для глубокого выражения, но получили %d", http.StatusUnprocessableEntity, w.Code)
+    }
+
+    // Эндпоинт можно отключить
+    handler.EvaluateEnabled = false
+    w = httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "2 + 2"}`)))
+    handler.EvaluateEnabled = true
+    if w.Code != http.StatusNotFound {
+        t.Errorf("Ожидался статус %d для отключённого эндпоинта, но получили %d", http.StatusNotFound, w.Code)
+    }
+}
+
+func TestInspectAST(t *testing.T) {
+    handler.ResetRateLimits()
+
+    w := httptest.NewRecorder()
+    handler.InspectAST(w, httptest.NewRequest("POST", "/api/v1/ast", strings.NewReader(`{"expression": "(1 + 2) * 3"}`)))
+    if w.Code != http.StatusOK {
+        t.Fatalf("Ожидался статус %d, но получили %d", http.StatusOK, w.Code)
+    }
+    var response handler.ASTResponse
+    json.NewDecoder(w.Body).Decode(&response)
+
+    root := response.Tree
+    if root == nil || root.Type != "binary" || root.Operator != "*" || *root.Value != 9 || len(root.Children) != 2 {
+        t.Fatalf("Ожидался корень * со значением 9, но получили %+v", root)
+    }
+    if root.Span.Start != 0 || root.Span.End != 11 {
+        t.Errorf("Ожидался фрагмент корня [0, 11), но получили %+v", root.Span)
+    }
+    sum, three := root.Children[0], root.Children[1]
+    if sum.Operator != "+" || *sum.Value != 3 || sum.Span.Start != 0 || sum.Span.End != 7 {
+        t.Errorf("Ожидался узел (1 + 2) на [0, 7), но получили %+v", sum)
+    }
+    if three.Type != "number" || *three.Value != 3 || three.Span.Start != 10 || three.Span.End != 11 {
+        t.Errorf("Ожидалось число 3 на [10, 11), но получили %+v", three)
+    }
+
+    w = httptest.NewRecorder()
+    handler.InspectAST(w, httptest.NewRequest("POST", "/api/v1/ast", strings.NewReader(`{"expression": "(1 + 2"}`)))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d, но получили %d", http.StatusUnprocessableEntity, w.Code)
+    }
+}
+
+func TestTraceTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Обычная задача попадает в кеш, задача с trace его не использует
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "(2+3)*4"}`)))
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 20}`)))
+
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "(2+3)*4", "trace": true}`)))
+    if len(handler.TaskQueue) != 1 || !handler.TaskQueue[0].Trace {
+        t.Fatalf("Ожидалось, что задача с trace попадёт в очередь, но очередь: %+v", handler.TaskQueue)
+    }
+
+    // Агент присылает шаги вместе с результатом
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    result := `{"id": 2, "result": 20, "steps": [
+        {"expression": "(2+3)", "span": {"start": 0, "end": 5}, "result": 5, "rewritten": "5*4"},
+        {"expression": "5*4", "span": {"start": 0, "end": 7}, "result": 20, "rewritten": "20"}]}`
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(result)))
+
+    w := httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    var response map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&response)
+    task := response["expression"]
+    if task.Status != "completed" || len(task.Steps) != 2 || task.Steps[0].Rewritten != "5*4" {
+        t.Errorf("Ожидалась завершённая задача с двумя шагами, но получили %+v", task)
+    }
+}
+
+func TestAddTaskVariables(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Одно и то же выражение с разными значениями переменных — разные задачи
+    for _, body := range []string{
+        `{"expression": "x * max(2, y)", "variables": {"x": 2, "y": 5}}`,
+        `{"expression": "x * max(2,y)", "variables": {"x": 3, "y": 5}}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusCreated {
+            t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+        }
+    }
+    if len(handler.TaskQueue) != 2 {
+        t.Errorf("Ожидалось 2 задачи в очереди, но получили %d", len(handler.TaskQueue))
+    }
+
+    // Переменная без значения и неизвестная функция
+    for _, body := range []string{
+        `{"expression": "x + 1"}`,
+        `{"expression": "foo(2)"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+
+    // Синхронное вычисление тоже принимает переменные
+    w := httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "2^x / (x - 3)", "variables": {"x": 4}}`)))
+    var response handler.EvaluateResponse
+    json.NewDecoder(w.Body).Decode(&response)
+    if w.Code != http.StatusOK || response.Result != 16 {
+        t.Errorf("Ожидался результат 16, но получили статус %d и %+v", w.Code, response)
+    }
+    w = httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "2 / (x - 3)", "variables": {"x": 3}}`)))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("Ожидался статус %d для деления на ноль, но получили %d", http.StatusUnprocessableEntity, w.Code)
+    }
+}
+
+func TestAddTaskSimplify(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Эквивалентные выражения с simplify дают одну каноническую форму и вычисляются один раз
+    for _, expression := range []string{"y + x * 1", "(x + y) + 0"} {
+        body := `{"expression": "` + expression + `", "variables": {"x": 2, "y": 3}, "simplify": true}`
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusCreated {
+            t.Fatalf("Ожидался статус %d, но получили %d", http.StatusCreated, w.Code)
+        }
+    }
+    if len(handler.TaskQueue) != 1 || handler.TaskQueue[0].Simplified != "x + y" {
+        t.Fatalf("Ожидалась одна задача с упрощённой формой x + y, но очередь: %+v", handler.TaskQueue)
+    }
+
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 5}`)))
+
+    w := httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    var response map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&response)
+    if task := response["expression"]; task.Status != "completed" || task.Result != 5 || task.Expression != "(x + y) + 0" {
+        t.Errorf("Ожидалась завершённая задача с результатом 5, но получили %+v", task)
+    }
+}
+
+func TestDeriveExpression(t *testing.T) {
+    handler.ResetRateLimits()
+
+    derive := func(body string) (int, handler.DeriveResponse) {
+        w := httptest.NewRecorder()
+        handler.DeriveExpression(w, httptest.NewRequest("POST", "/api/v1/derive", strings.NewReader(body)))
+        var response handler.DeriveResponse
+        json.NewDecoder(w.Body).Decode(&response)
+        return w.Code, response
+    }
+
+    // Без точки — только текст производной, свободные переменные допустимы
+    code, response := derive(`{"expression": "x^3 * y", "variable": "x"}`)
+    if code != http.StatusOK || response.Derivative != "3 * x ^ 2 * y" || response.Value != nil {
+        t.Errorf("Ожидалась производная 3 * x ^ 2 * y без значения, но получили %d %+v", code, response)
+    }
+
+    // Значение в точке
+    code, response = derive(`{"expression": "x^3 * y", "variable": "x", "variables": {"x": 2, "y": 5}}`)
+    if code != http.StatusOK || response.Value == nil || *response.Value != 60 {
+        t.Errorf("Ожидалось значение 60, но получили %d %+v", code, response)
+    }
+
+    for _, body := range []string{
+        `{"expression": "x^2", "variable": "2x"}`,
+        `{"expression": "floor(x)", "variable": "x"}`,
+        `{"expression": "x * y", "variable": "x", "variables": {"x": 1}}`,
+    } {
+        if code, _ := derive(body); code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, code)
+        }
+    }
+}
+
+func TestSolveTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Переменная определяется по уравнению: k задана, t — нет
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "t^2 = k", "type": "solve", "variables": {"k": 4}}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+    if len(handler.TaskQueue) != 1 || handler.TaskQueue[0].Variable != "t" {
+        t.Fatalf("Ожидалась задача с переменной t, но очередь: %+v", handler.TaskQueue)
+    }
+
+    // Агент присылает корни
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "roots": [-2, 2]}`)))
+
+    // То же уравнение берётся из кеша вместе с корнями
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "t ^ 2 = k", "type": "solve", "variable": "t", "variables": {"k": 4}}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    var response map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&response)
+    if task := response["expression"]; task.Status != "completed" || len(task.Roots) != 2 || task.Roots[1] != 2 {
+        t.Errorf("Ожидались корни [-2 2] из кеша, но получили %+v", task)
+    }
+
+    for _, body := range []string{
+        `{"expression": "x + y = 1", "type": "solve"}`,                      // неясно, какую переменную искать
+        `{"expression": "x = 1", "type": "solve", "variables": {"x": 1}}`,    // у неизвестного есть значение
+        `{"expression": "x = 1 = 2", "type": "solve"}`,
+        `{"expression": "x^2 = 2", "type": "solve", "range": [5, 1]}`,
+        `{"expression": "x = 1"}`,                                           // "=" только в уравнении
+        `{"expression": "x = 1", "type": "integrate"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestIntegrateParts(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Интеграл делится на 4 части для разных агентов
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "integrate(x^2, x, 0, b)", "variables": {"b": 4}, "parts": 4}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+    if len(handler.TaskQueue) != 4 {
+        t.Fatalf("Ожидалось 4 части в очереди, но получили %d", len(handler.TaskQueue))
+    }
+
+    getTask := func(id int) handler.Task {
+        w := httptest.NewRecorder()
+        handler.GetExpressionByID(w, httptest.NewRequest("GET", fmt.Sprintf("/api/v1/expressions/%d", id), nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        return response["expression"]
+    }
+    parent := getTask(1)
+    if parent.Status != "pending" || len(parent.Children) != 4 {
+        t.Fatalf("Ожидался родитель с 4 частями, но получили %+v", parent)
+    }
+
+    // Агенты вычисляют части; родитель завершается, когда готовы все
+    for i := 0; i < 4; i++ {
+        w := httptest.NewRecorder()
+        handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        part := response["task"]
+        if part.ParentID != 1 {
+            t.Errorf("Часть должна ссылаться на родителя: %+v", part)
+        }
+        ast, _ := calculation.Parse(part.Expression)
+        value, estimate, err := calculation.EvalWithErrorEstimate(ast, part.Variables)
+        if err != nil {
+            t.Fatalf("%s: ошибка вычисления: %v", part.Expression, err)
+        }
+        body, _ := json.Marshal(map[string]interface{}{"id": part.ID, "result": value, "error_estimate": estimate})
+        handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", bytes.NewReader(body)))
+        if i < 3 && getTask(1).Status != "pending" {
+            t.Errorf("Родитель завершён раньше всех частей")
+        }
+    }
+    if parent = getTask(1); parent.Status != "completed" || math.Abs(parent.Result-64.0/3) > 1e-9 {
+        t.Errorf("Ожидался результат 21.333, но получили %+v", parent)
+    }
+
+    // Ошибка части завершает родителя ошибкой и отменяет остальные части
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "sum(i, i, 1, 10)", "parts": 3}`)))
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(
+        `{"id": 7, "status": "failed", "error": "сбой агента"}`)))
+    if parent = getTask(6); parent.Status != "failed" || !strings.Contains(parent.Error, "сбой агента") {
+        t.Errorf("Ожидался родитель с ошибкой части, но получили %+v", parent)
+    }
+    if len(handler.TaskQueue) != 0 || getTask(8).Status != "cancelled" {
+        t.Errorf("Ожидалась отмена остальных частей, в очереди %d задач", len(handler.TaskQueue))
+    }
+
+    for _, body := range []string{
+        `{"expression": "2 + 3", "parts": 2}`,                          // делить нечего
+        `{"expression": "integrate(x, x, 0, 1)", "parts": 1000}`,       // больше MaxTaskParts
+        `{"expression": "integrate(x, x, 0, 1)", "parts": 2, "delay": "1m"}`,
+        `{"expression": "sum(i, i, 0.5, 3)", "parts": 2}`,
+        `{"expression": "sum(i, i, 1, 10^12)", "parts": 4}`,            // больше MaxSumTerms
+        `{"expression": "sum(i, i, -10^17, 10^17)", "parts": 4}`,       // пределы за 2^53
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+
+    // Пустую сумму делить нечего: она вычисляется одной задачей
+    w = httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "sum(i, i, 5, 4)", "parts": 2}`)))
+    if w.Code != http.StatusCreated {
+        t.Errorf("Ожидался статус %d для пустой суммы, но получили %d", http.StatusCreated, w.Code)
+    }
+}
+
+func TestPlot(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Небольшой график вычисляется сразу
+    w := httptest.NewRecorder()
+    handler.Plot(w, httptest.NewRequest("POST", "/api/v1/plot", strings.NewReader(
+        `{"expression": "k / t", "range": [-2, 2], "samples": 5, "variables": {"k": 2}}`)))
+    if w.Code != http.StatusOK {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusOK, w.Code, w.Body.String())
+    }
+    var plot handler.PlotResponse
+    json.NewDecoder(w.Body).Decode(&plot)
+    if plot.Variable != "t" || len(plot.Points) != 5 || plot.Points[2].Y != nil || plot.Points[2].Error == "" || *plot.Points[4].Y != 1 {
+        t.Errorf("Ожидались 5 точек с ошибкой в t = 0, но получили %+v", plot)
+    }
+
+    // SVG
+    w = httptest.NewRecorder()
+    handler.Plot(w, httptest.NewRequest("POST", "/api/v1/plot", strings.NewReader(
+        `{"expression": "x^2", "range": [-1, 1], "format": "svg"}`)))
+    if w.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(w.Body.String(), "<polyline") {
+        t.Errorf("Ожидался SVG с ломаной, но получили %s: %.200s", w.Header().Get("Content-Type"), w.Body.String())
+    }
+
+    // Большой график делится на задачи для агентов
+    w = httptest.NewRecorder()
+    handler.Plot(w, httptest.NewRequest("POST", "/api/v1/plot", strings.NewReader(
+        `{"expression": "sin(x)", "range": [0, 10], "samples": 2500}`)))
+    if w.Code != http.StatusAccepted {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+    }
+    if len(handler.TaskQueue) != 3 {
+        t.Fatalf("Ожидалось 3 задачи в очереди, но получили %d", len(handler.TaskQueue))
+    }
+    location := w.Header().Get("Location")
+
+    // Пока части не готовы, график ещё строится
+    w = httptest.NewRecorder()
+    handler.PlotByID(w, httptest.NewRequest("GET", location, nil))
+    if w.Code != http.StatusAccepted {
+        t.Errorf("Ожидался статус %d, но получили %d", http.StatusAccepted, w.Code)
+    }
+
+    // Агенты вычисляют части в обратном порядке
+    var parts []handler.Task
+    for len(handler.TaskQueue) > 0 {
+        w := httptest.NewRecorder()
+        handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        parts = append(parts, response["task"])
+    }
+    for i := len(parts) - 1; i >= 0; i-- {
+        part := parts[i]
+        ast, _ := calculation.Parse(part.Expression)
+        points := calculation.Sample(ast, part.Variable, part.Variables, part.Range[0], part.Range[1], part.Samples)
+        body, _ := json.Marshal(map[string]interface{}{"id": part.ID, "points": points})
+        handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", bytes.NewReader(body)))
+    }
+
+    w = httptest.NewRecorder()
+    handler.PlotByID(w, httptest.NewRequest("GET", location, nil))
+    plot = handler.PlotResponse{}
+    json.NewDecoder(w.Body).Decode(&plot)
+    if len(plot.Points) != 2500 {
+        t.Fatalf("Ожидалось 2500 точек, но получили %d", len(plot.Points))
+    }
+    for i, point := range plot.Points {
+        if x := 10 * float64(i) / 2499; math.Abs(point.X-x) > 1e-12 || point.Y == nil || math.Abs(*point.Y-math.Sin(point.X)) > 1e-12 {
+            t.Fatalf("Точка %d: ожидалось sin(%v), но получили %+v", i, x, point)
+        }
+    }
+
+    // График с интегралом строят агенты, даже небольшой
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    w = httptest.NewRecorder()
+    handler.Plot(w, httptest.NewRequest("POST", "/api/v1/plot", strings.NewReader(
+        `{"expression": "integrate(u * x, u, 0, 1)", "variable": "x", "range": [0, 1], "samples": 5}`)))
+    if w.Code != http.StatusAccepted || len(handler.TaskQueue) != 1 {
+        t.Errorf("Ожидался статус %d и задача в очереди, но получили %d, в очереди %d", http.StatusAccepted, w.Code, len(handler.TaskQueue))
+    }
+
+    for _, body := range []string{
+        `{"expression": "x + y", "range": [0, 1]}`,                 // неясно, какая переменная
+        `{"expression": "x", "range": [1, 0]}`,
+        `{"expression": "x", "range": [0, 1], "samples": 1}`,
+        `{"expression": "x", "range": [0, 1], "samples": 1000000}`,
+        `{"expression": "x", "range": [0, 1], "format": "png"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.Plot(w, httptest.NewRequest("POST", "/api/v1/plot", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestComplexTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "(3+4i)*(1-2i)", "mode": "complex"}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+
+    // Агент получает задачу с режимом и присылает результат как {re, im}
+    w = httptest.NewRecorder()
+    handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+    if !strings.Contains(w.Body.String(), `"mode":"complex"`) || strings.Contains(w.Body.String(), `"result"`) {
+        t.Errorf("Ожидалась задача в комплексном режиме без результата, но получили %s", w.Body.String())
+    }
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(
+        `{"id": 1, "result": {"re": 11, "im": -2}}`)))
+
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/1", nil))
+    var response map[string]map[string]interface{}
+    json.NewDecoder(w.Body).Decode(&response)
+    result, _ := response["expression"]["result"].(map[string]interface{})
+    if result["re"] != 11.0 || result["im"] != -2.0 {
+        t.Errorf("Ожидался результат {re: 11, im: -2}, но получили %v", response["expression"]["result"])
+    }
+
+    // Тот же результат из кеша, но не для действительного режима
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "(3 + 4 * i) * (1 - 2 * i)", "mode": "complex"}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    var task map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&task)
+    if task["expression"].Status != "completed" || task["expression"].Result != 11 || task["expression"].Imag != -2 {
+        t.Errorf("Ожидался результат из кеша, но получили %+v", task["expression"])
+    }
+
+    for _, body := range []string{
+        `{"expression": "sqrt(-1) + i"}`,                    // i без значения в действительном режиме
+        `{"expression": "i", "mode": "quaternion"}`,
+        `{"expression": "i", "mode": "complex", "trace": true}`,
+        `{"expression": "x = i", "mode": "complex", "type": "solve"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestUnitsTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "60 km/h * 2 h", "mode": "units", "unit": "km"}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+    if task := handler.TaskQueue[0]; task.Mode != "units" || task.Unit != "km" {
+        t.Errorf("Ожидалась задача с единицей km, но получили %+v", task)
+    }
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(
+        `{"id": 1, "result": 120, "unit": "km"}`)))
+
+    // Та же величина в тех же единицах — из кеша, в других единицах — новая задача
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "60 km / h * (2 h)", "mode": "units", "unit": "km"}`)))
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "60 km/h * 2 h", "mode": "units", "unit": "mi"}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    var response map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&response)
+    if task := response["expression"]; task.Status != "completed" || task.Result != 120 || task.Unit != "km" {
+        t.Errorf("Ожидалось 120 km из кеша, но получили %+v", task)
+    }
+    if len(handler.TaskQueue) != 1 {
+        t.Errorf("Ожидалась новая задача для mi, в очереди %d", len(handler.TaskQueue))
+    }
+
+    for _, body := range []string{
+        `{"expression": "1 m + 1 s", "mode": "units"}`,                // несовместимые размерности
+        `{"expression": "5 km", "mode": "units", "unit": "kg"}`,       // нельзя перевести
+        `{"expression": "5 km", "mode": "units", "unit": "furlong"}`,  // неизвестная единица
+        `{"expression": "5 km"}`,                                      // единицы без режима units
+        `{"expression": "5", "unit": "km"}`,
+        `{"expression": "5 m - 5 m", "mode": "units", "simplify": true}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestIntegerTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "0xF0 | 0x0F", "mode": "integer", "base": 16}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+    if task := handler.TaskQueue[0]; task.Width != "int64" || task.Base != 16 {
+        t.Errorf("Ожидалась задача int64 в base 16, но получили %+v", task)
+    }
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(
+        `{"id": 1, "result": "0xFF"}`)))
+
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/1", nil))
+    if body := w.Body.String(); !strings.Contains(body, `"result":"0xFF"`) {
+        t.Errorf("Ожидался результат \"0xFF\", но получили %s", body)
+    }
+
+    // То же выражение в той же системе счисления — из кеша, в другой — новая задача
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "(0xF0) | 0x0F", "mode": "integer", "base": 16}`)))
+    handler.AddTask(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "0xF0 | 0x0F", "mode": "integer", "base": 2}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    var response map[string]handler.Task
+    json.NewDecoder(w.Body).Decode(&response)
+    if task := response["expression"]; task.Status != "completed" || task.Integer != "0xFF" {
+        t.Errorf("Ожидалось 0xFF из кеша, но получили %+v", task)
+    }
+    if len(handler.TaskQueue) != 1 {
+        t.Errorf("Ожидалась новая задача для base 2, в очереди %d", len(handler.TaskQueue))
+    }
+
+    // Целочисленное деление — один оператор, а не повторяющиеся
+    w = httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "7 // 2", "mode": "integer"}`)))
+    if w.Code != http.StatusCreated {
+        t.Errorf("Ожидался статус %d для 7 // 2, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+
+    for _, body := range []string{
+        `{"expression": "5 & 3"}`,                                   // побитовые операторы без режима integer
+        `{"expression": "2.5 + 1", "mode": "integer"}`,              // дробное число
+        `{"expression": "sqrt(4)", "mode": "integer"}`,              // дробная функция
+        `{"expression": "x + 1", "mode": "integer", "variables": {"x": 0.5}}`,
+        `{"expression": "1 + 1", "mode": "integer", "width": "int32"}`,
+        `{"expression": "1 + 1", "mode": "integer", "base": 64}`,
+        `{"expression": "1 + 1", "base": 16}`,                       // base без режима integer
+        `{"expression": "1 + 1", "mode": "integer", "simplify": true}`,
+        `{"expression": "7 /// 2", "mode": "integer"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestConditionalTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Условие — числовой результат, сравнение — логический
+    for _, body := range []string{
+        `{"expression": "if(x > 100, x*0.9, x)", "variables": {"x": 150}}`,
+        `{"expression": "x >= 100 and x < 200", "variables": {"x": 150}}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusCreated {
+            t.Fatalf("%s: ожидался статус %d, но получили %d: %s", body, http.StatusCreated, w.Code, w.Body.String())
+        }
+    }
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 135}`)))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 2, "result": true}`)))
+
+    for id, expected := range map[int]string{1: `"result":135`, 2: `"result":true`} {
+        w := httptest.NewRecorder()
+        handler.GetExpressionByID(w, httptest.NewRequest("GET", fmt.Sprintf("/api/v1/expressions/%d", id), nil))
+        if body := w.Body.String(); !strings.Contains(body, expected) {
+            t.Errorf("Задача %d: ожидалось %s, но получили %s", id, expected, body)
+        }
+    }
+
+    // Синхронное вычисление сравнения
+    w := httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "x == 0 or 1 / x > 1", "variables": {"x": 0}}`)))
+    if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `"result":true`) {
+        t.Errorf("Ожидался результат true, но получили %d: %s", w.Code, body)
+    }
+
+    for _, body := range []string{
+        `{"expression": "1 + (x > 2)", "variables": {"x": 1}}`,       // ошибка типа
+        `{"expression": "if(x, 1, 2)", "variables": {"x": 1}}`,        // условие — число
+        `{"expression": "if(x > 1, 1)", "variables": {"x": 1}}`,       // неверное число аргументов
+        `{"expression": "x > 1", "variables": {"x": 1}, "trace": true}`,
+        `{"expression": "1 > 0", "mode": "complex"}`,
+        `{"expression": "x > 1 = 0", "type": "solve"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestMatrixTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Результат — вложенные массивы
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "transpose([[1,2],[3,4]])"}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": [[1, 3], [2, 4]]}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/1", nil))
+    if body := w.Body.String(); !strings.Contains(body, `"result":[[1,3],[2,4]]`) {
+        t.Errorf("Ожидался результат [[1,3],[2,4]], но получили %s", body)
+    }
+
+    // Повтор берётся из кеша вместе с массивом
+    w = httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "transpose([[1, 2], [3, 4]])"}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/2", nil))
+    if body := w.Body.String(); !strings.Contains(body, `"status":"completed"`) || !strings.Contains(body, `"result":[[1,3],[2,4]]`) {
+        t.Errorf("Ожидался результат из кеша, но получили %s", body)
+    }
+
+    // Матричное произведение делится по блокам строк левой матрицы
+    w = httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "matmul([[1,0],[0,1],[2,0],[0,2],[x,x]], [[1,2],[3,4]])", "variables": {"x": 1}, "parts": 2}`)))
+    if w.Code != http.StatusCreated || len(handler.TaskQueue) != 2 {
+        t.Fatalf("Ожидалось 2 части в очереди, но получили %d: %s", len(handler.TaskQueue), w.Body.String())
+    }
+    var parts []handler.Task
+    for i := 0; i < 2; i++ {
+        w := httptest.NewRecorder()
+        handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+        var response map[string]handler.Task
+        json.NewDecoder(w.Body).Decode(&response)
+        parts = append(parts, response["task"])
+    }
+    // Части завершаются в обратном порядке, блоки склеиваются по порядку строк
+    for i := len(parts) - 1; i >= 0; i-- {
+        ast, _ := calculation.Parse(parts[i].Expression)
+        value, err := calculation.EvalValue(ast, parts[i].Variables)
+        if err != nil || value.Kind != calculation.KindMatrix {
+            t.Fatalf("%s: ожидалась матрица, но получили %+v (%v)", parts[i].Expression, value, err)
+        }
+        body, _ := json.Marshal(map[string]interface{}{"id": parts[i].ID, "result": value.Interface()})
+        handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", bytes.NewReader(body)))
+    }
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/3", nil))
+    if body := w.Body.String(); !strings.Contains(body, `"result":[[1,2],[3,4],[2,4],[6,8],[4,6]]`) {
+        t.Errorf("Ожидалось склеенное произведение, но получили %s", body)
+    }
+
+    // Синхронное вычисление
+    w = httptest.NewRecorder()
+    handler.Evaluate(w, httptest.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "[1,2] * 3"}`)))
+    if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `"result":[3,6]`) {
+        t.Errorf("Ожидался результат [3,6], но получили %d: %s", w.Code, body)
+    }
+
+    for _, body := range []string{
+        `{"expression": "[1, 2] + [[1, 2]]"}`,           // вектор и матрица
+        `{"expression": "dot([1, 2])"}`,                 // неверное число аргументов
+        `{"expression": "[1, 2] * 2", "mode": "complex"}`,
+        `{"expression": "[1, 2] * 0", "simplify": true}`, // упрощение потеряло бы размер
+        `{"expression": "[x, 1] = 0", "type": "solve"}`,
+        `{"expression": "matmul([[1, 2]], [[1], [2]])", "parts": 1000}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestListVariables(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    // Набор данных передаётся в variables, а не в тексте выражения
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "percentile(data, p) - mean(data)", "variables": {"data": [1, 2, 3, 4], "p": 50}}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+
+    // Агент получает списки в variables
+    w = httptest.NewRecorder()
+    handler.GetTask(w, httptest.NewRequest("GET", "/api/v1/task", nil))
+    var response struct {
+        Task struct {
+            ID        int                        `json:"id"`
+            Variables map[string]json.RawMessage `json:"variables"`
+        } `json:"task"`
+    }
+    json.NewDecoder(w.Body).Decode(&response)
+    if string(response.Task.Variables["data"]) != "[1,2,3,4]" || string(response.Task.Variables["p"]) != "50" {
+        t.Errorf("Ожидались переменные data и p, но получили %v", response.Task.Variables)
+    }
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(`{"id": 1, "result": 0.25}`)))
+
+    // Тот же набор данных — из кеша, другой — новое вычисление
+    for _, test := range []struct {
+        variables string
+        status    string
+    }{
+        {`{"p": 50, "data": [1, 2, 3, 4]}`, "completed"},
+        {`{"p": 50, "data": [1, 2, 3, 5]}`, "pending"},
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+            `{"expression": "percentile(data, p) - mean(data)", "variables": `+test.variables+`}`)))
+        var created map[string]int
+        json.NewDecoder(w.Body).Decode(&created)
+        w = httptest.NewRecorder()
+        handler.GetExpressionByID(w, httptest.NewRequest("GET", fmt.Sprintf("/api/v1/expressions/%d", created["id"]), nil))
+        if body := w.Body.String(); !strings.Contains(body, `"status":"`+test.status+`"`) {
+            t.Errorf("%s: ожидался статус %s, но получили %s", test.variables, test.status, body)
+        }
+    }
+
+    for _, body := range []string{
+        `{"expression": "mean(data)", "variables": {"data": []}}`,          // пустой список
+        `{"expression": "mean(data)", "variables": {"data": ["a"]}}`,
+        `{"expression": "data + 1 > 2", "variables": {"data": [1, 2]}}`,   // список нельзя сравнивать
+        `{"expression": "mean(data)", "variables": {"data": [1]}, "mode": "complex"}`,
+        `{"expression": "x = mean(data)", "type": "solve", "variables": {"data": [1]}}`,
+        `{"expression": "mean(data)", "variables": {"data": [1, 2]}, "trace": true}`,
+        `{"expression": "sum(1, 2)", "mode": "integer"}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}
+
+func TestProgramTask(t *testing.T) {
+    handler.ResetRateLimits()
+    handler.DeleteAllTasks(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/v1/tasks/delete", nil))
+    handler.ResetResultCache()
+
+    w := httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "f(x) = x^2 + 1; a = f(3); a * 2"}`)))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("Ожидался статус %d, но получили %d: %s", http.StatusCreated, w.Code, w.Body.String())
+    }
+
+    // Агент вычисляет программу и возвращает итоговые значения
+    handler.GetTask(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/task", nil))
+    handler.UpdateTaskResult(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/task/result", strings.NewReader(
+        `{"id": 1, "result": 20, "bindings": {"a": 10, "f": "f(x) = x ^ 2 + 1"}}`)))
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", "/api/v1/expressions/1", nil))
+    if body := w.Body.String(); !strings.Contains(body, `"result":20`) || !strings.Contains(body, `"bindings":{"a":10,"f":"f(x) = x ^ 2 + 1"}`) {
+        t.Errorf("Ожидались результат и значения программы, но получили %s", body)
+    }
+
+    // Та же программа в другой записи — из кеша вместе со значениями
+    w = httptest.NewRecorder()
+    handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(
+        `{"expression": "f(x)=x^2+1;a=f(3);a*2;"}`)))
+    var created map[string]int
+    json.NewDecoder(w.Body).Decode(&created)
+    w = httptest.NewRecorder()
+    handler.GetExpressionByID(w, httptest.NewRequest("GET", fmt.Sprintf("/api/v1/expressions/%d", created["id"]), nil))
+    if body := w.Body.String(); !strings.Contains(body, `"status":"completed"`) || !strings.Contains(body, `"bindings"`) {
+        t.Errorf("Ожидался результат из кеша, но получили %s", body)
+    }
+
+    for _, body := range []string{
+        `{"expression": "f(x) = x"}`,                                 // нет выражения в конце
+        `{"expression": "a = b; a"}`,                                 // b не определена
+        `{"expression": "sqrt(x) = x; 1"}`,                           // встроенная функция
+        `{"expression": "a = 1; a", "mode": "complex"}`,
+        `{"expression": "a = 1; a", "trace": true}`,
+        `{"expression": "a = 1; a", "simplify": true}`,
+        `{"expression": "f(x) = x; integrate(f(x), x, 0, 1)", "parts": 2}`,
+    } {
+        w := httptest.NewRecorder()
+        handler.AddTask(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: ожидался статус %d, но получили %d", body, http.StatusUnprocessableEntity, w.Code)
+        }
+    }
+}